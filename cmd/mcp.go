@@ -54,9 +54,13 @@ func errorResponseHandler(resp http.Response) (CommonOutput, error) {
 }
 
 type SearchInput struct {
-	Model string `json:"model" jsonschema:"the name of the model for searching"`
-	Query string `json:"query" jsonschema:"the query to search for, while using ANN model, it can be a sentence, for BM25 model, use space to separate keywords for AND logic and use OR to separate keywords for OR logic"`
-	Count int    `json:"n" jsonschema:"the number of results to return for each model"`
+	Model    string `json:"model" jsonschema:"the name of the model for searching: bm25, hybrid (BM25 and all embedding models) or an embedding model"`
+	Query    string `json:"query" jsonschema:"the query in the Vestigo query language: words separated by spaces must all match, OR for alternatives, parentheses to group, \"quoted phrases\", word* for prefixes, -word to exclude, a NEAR/5 b for words within 5 words, filters title:word, data.<key>:value, metadata.<key>:value and created_at:2024-01-01..2024-06-30 (either end optional); for ANN models, the words and phrases are embedded as a sentence"`
+	Count    int    `json:"n" jsonschema:"the number of results to return for each model"`
+	Language string `json:"lang,omitempty" jsonschema:"optional language filter of text chunks, e.g. zh, ja or en, use auto for the language of the query"`
+	Rerank   string `json:"rerank,omitempty" jsonschema:"optional rerank or generation model to reorder the top candidates by relevance to the query"`
+	Rewrite  string `json:"rewrite,omitempty" jsonschema:"optional generation model to expand the query by keywords in other languages and a hypothetical answer"`
+	Ranking  string `json:"ranking,omitempty" jsonschema:"optional ranking profile of the server to adjust the scores by recency and boosts, none to disable the default one"`
 }
 
 type SearchOutput struct {
@@ -81,10 +85,23 @@ func (v VestigoMCP) getUrl(relativePath string, parameters map[string]string) (*
 }
 
 func (v VestigoMCP) SearchDocuments(ctx context.Context, req *mcp.CallToolRequest, input SearchInput) (*mcp.CallToolResult, SearchOutput, error) {
-	searchUrl, err := v.getUrl(fmt.Sprintf("/api/v1/search/%s", input.Model), map[string]string{
+	parameters := map[string]string{
 		"q": input.Query,
 		"n": strconv.Itoa(input.Count),
-	})
+	}
+	if input.Language != "" {
+		parameters["lang"] = input.Language
+	}
+	if input.Rerank != "" {
+		parameters["rerank"] = input.Rerank
+	}
+	if input.Rewrite != "" {
+		parameters["rewrite"] = input.Rewrite
+	}
+	if input.Ranking != "" {
+		parameters["ranking"] = input.Ranking
+	}
+	searchUrl, err := v.getUrl(fmt.Sprintf("/api/v1/search/%s", input.Model), parameters)
 	if err != nil {
 		return nil, SearchOutput{
 			CommonOutput: CommonOutput{
@@ -232,6 +249,59 @@ func (v VestigoMCP) GetDocument(ctx context.Context, req *mcp.CallToolRequest, i
 	return nil, GetDocumentOutput{Document: result, CommonOutput: commonOutput}, nil
 }
 
+type GetTextContextInput struct {
+	TextChunkID string `json:"text_chunk_id" jsonschema:"the ID of the text chunk, e.g. from the search results"`
+	Count       int    `json:"n" jsonschema:"the number of preceding and following text chunks to return"`
+}
+
+type GetTextContextOutput struct {
+	CommonOutput
+	controller.TextChunkContext
+}
+
+func (v VestigoMCP) GetTextContext(ctx context.Context, req *mcp.CallToolRequest, input GetTextContextInput) (*mcp.CallToolResult, GetTextContextOutput, error) {
+	contextUrl, err := v.getUrl("/api/v1/text/"+url.PathEscape(input.TextChunkID)+"/context", map[string]string{"n": strconv.Itoa(input.Count)})
+	if err != nil {
+		return nil, GetTextContextOutput{
+			CommonOutput: CommonOutput{
+				Status:  "error",
+				Message: fmt.Sprintf("failed to build get text context url: %v", err),
+			},
+		}, err
+	}
+	// Make request
+	resp, err := v.client.Do(&http.Request{
+		Method: http.MethodGet,
+		URL:    contextUrl,
+	})
+	if err != nil {
+		return nil, GetTextContextOutput{
+			CommonOutput: CommonOutput{
+				Status:  "error",
+				Message: fmt.Sprintf("failed to get text context: %v", err),
+			},
+		}, err
+	}
+	defer closeIoReadCloser(resp.Body)
+	commonOutput, err := errorResponseHandler(*resp)
+	if err != nil {
+		return nil, GetTextContextOutput{
+			CommonOutput: commonOutput,
+		}, err
+	}
+	// Parse response
+	var result controller.TextChunkContext
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, GetTextContextOutput{
+			CommonOutput: CommonOutput{
+				Status:  "error",
+				Message: fmt.Sprintf("failed to parse get text context response: %v", err),
+			},
+		}, err
+	}
+	return nil, GetTextContextOutput{TextChunkContext: result, CommonOutput: commonOutput}, nil
+}
+
 func NewMcpCommand() *cobra.Command {
 	var vestigoEndpoint string
 
@@ -260,6 +330,11 @@ func NewMcpCommand() *cobra.Command {
 					"since it will generate sentence embedding",
 			}, v.ListModels)
 			mcp.AddTool(server, &mcp.Tool{Name: "get_document", Description: "Get full document with all text chunks by ID, with option to include text chunks"}, v.GetDocument)
+			mcp.AddTool(server, &mcp.Tool{
+				Name: "get_text_context",
+				Description: "Get the surrounding text chunks of a text chunk in its document, " +
+					"use it to expand a search hit into more context instead of fetching the full document",
+			}, v.GetTextContext)
 			if err := server.Run(cmd.Context(), &mcp.StdioTransport{}); err != nil {
 				logger.Fatal(err)
 			}
@@ -19,6 +19,7 @@ import (
 	"github.com/tsingjyujing/vestigo/config"
 	"github.com/tsingjyujing/vestigo/controller"
 	"github.com/tsingjyujing/vestigo/models"
+	"github.com/tsingjyujing/vestigo/text"
 	"github.com/tsingjyujing/vestigo/utils"
 	_ "modernc.org/sqlite"
 )
@@ -91,6 +92,43 @@ func loadGenerationModels(configs []config.GenerationModel) (map[string]models.G
 	return generationModels, nil
 }
 
+func loadRerankModels(configs []config.RerankModel) (map[string]models.RerankModel, error) {
+	rerankModels := make(map[string]models.RerankModel)
+	for _, modelConfig := range configs {
+		model, err := models.NewRerankModel(modelConfig.Type, modelConfig.Config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load rerank model %s: %w", modelConfig.ID, err)
+		}
+		if rerankModels[modelConfig.ID] != nil {
+			return nil, fmt.Errorf("duplicate rerank model: %s", modelConfig.ID)
+		}
+		rerankModels[modelConfig.ID] = model
+		logger.Debugf("Loaded rerank model %s successfully", modelConfig.ID)
+	}
+	return rerankModels, nil
+}
+
+func loadRankingProfiles(configs map[string]config.RankingProfile) (map[string]controller.RankingProfile, error) {
+	profiles := make(map[string]controller.RankingProfile, len(configs))
+	for name, profileConfig := range configs {
+		profile := controller.RankingProfile{
+			BoostField:  profileConfig.BoostField,
+			BoostWeight: profileConfig.BoostWeight,
+			Pins:        profileConfig.Pins,
+			Candidates:  profileConfig.Candidates,
+		}
+		if profileConfig.DecayHalfLife != "" {
+			halfLife, err := controller.ParseHalfLife(profileConfig.DecayHalfLife)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load ranking profile %s: %w", name, err)
+			}
+			profile.DecayHalfLife = halfLife
+		}
+		profiles[name] = profile
+	}
+	return profiles, nil
+}
+
 func NewServerCommand() *cobra.Command {
 	serverCmd := &cobra.Command{
 		Use:   "server",
@@ -106,8 +144,8 @@ func NewServerCommand() *cobra.Command {
 				logger.WithError(err).Fatal("Failed to open database")
 			}
 			db.SetMaxOpenConns(1)
-			// create tables
-			if _, err := db.ExecContext(goCtx, controller.GetDDL()); err != nil {
+			// create & migrate tables
+			if err := controller.InitDatabase(goCtx, db); err != nil {
 				logger.WithError(err).Fatal("Failed to create tables")
 			}
 			// Load models
@@ -119,7 +157,48 @@ func NewServerCommand() *cobra.Command {
 			if err != nil {
 				logger.WithError(err).Fatal("Failed to load generation models")
 			}
-			c, err := controller.NewController(db, embeddingModels, viperInstance.GetString("embedding_save_path"), generationModels)
+			rerankModels, err := loadRerankModels(configStruct.RerankModels)
+			if err != nil {
+				logger.WithError(err).Fatal("Failed to load rerank models")
+			}
+			rankingProfiles, err := loadRankingProfiles(configStruct.Search.RankingProfiles)
+			if err != nil {
+				logger.WithError(err).Fatal("Failed to load ranking profiles")
+			}
+			analyzerLanguages := make(map[string]text.LanguageOptions, len(configStruct.Analyzer.Languages))
+			for language, languageConfig := range configStruct.Analyzer.Languages {
+				analyzerLanguages[language] = text.LanguageOptions{
+					Tokenizer:   languageConfig.Tokenizer,
+					Normalizers: languageConfig.Normalizers,
+					StopWords:   languageConfig.StopWords,
+				}
+			}
+			c, err := controller.NewController(
+				db,
+				embeddingModels,
+				viperInstance.GetString("embedding_save_path"),
+				generationModels,
+				controller.Options{
+					DedupePolicy:                configStruct.Ingest.Dedupe,
+					RejectNearDuplicateDistance: configStruct.Ingest.RejectNearDuplicateDistance,
+					Analyzer: text.AnalyzerOptions{
+						Tokenizer:       configStruct.Analyzer.Tokenizer,
+						Normalizers:     configStruct.Analyzer.Normalizers,
+						Stemming:        configStruct.Analyzer.Stemming,
+						Transliterate:   configStruct.Analyzer.Transliterate,
+						KeepStopWords:   configStruct.Analyzer.FilterStopWords != nil && !*configStruct.Analyzer.FilterStopWords,
+						Dictionaries:    configStruct.Analyzer.Dictionaries,
+						StopWords:       configStruct.Analyzer.StopWords,
+						Synonyms:        configStruct.Analyzer.Synonyms,
+						DetectLanguages: configStruct.Analyzer.DetectLanguages,
+						Languages:       analyzerLanguages,
+					},
+					RerankModels:    rerankModels,
+					FacetWindow:     configStruct.Search.FacetWindow,
+					RankingProfiles: rankingProfiles,
+					DefaultRanking:  configStruct.Search.DefaultRanking,
+				},
+			)
 			if err != nil {
 				logger.WithError(err).Fatal("Failed to create controller")
 			}
@@ -150,15 +229,37 @@ func NewServerCommand() *cobra.Command {
 			documentGroup.GET("/:doc_id", c.GetDocument)
 			documentGroup.DELETE("/:doc_id", c.DeleteDocument)
 			documentGroup.POST("/:doc_id/text", c.NewTextChunk)
+			documentGroup.GET("/:doc_id/near_duplicates", c.ListDocumentNearDuplicates)
+			documentGroup.POST("/:doc_id/generated", c.RegenerateTexts)
+			documentGroup.DELETE("/:doc_id/generated", c.DropGeneratedTexts)
 
 			// Text Chunk
 			textGroup := apiGroup.Group("/text")
 			textGroup.GET("/:text_id", c.GetTextChunk)
+			textGroup.PUT("/:text_id", c.UpdateTextChunk)
 			textGroup.DELETE("/:text_id", c.DeleteTextChunk)
+			textGroup.GET("/:text_id/context", c.GetTextChunkContext)
 
 			// Query API
 			apiGroup.GET("/models", c.ListEmbeddingModels)
 			apiGroup.GET("/search/:model_id", c.Search)
+			apiGroup.GET("/suggest", c.Suggest)
+			apiGroup.POST("/ask", c.Ask)
+			apiGroup.POST("/ask/stream", c.AskStream)
+
+			// Admin API
+			adminGroup := apiGroup.Group("/admin")
+			adminGroup.GET("/duplicates", c.ListDuplicates)
+			adminGroup.GET("/near_duplicates", c.ListNearDuplicates)
+			adminGroup.POST("/analyzer/reload", c.ReloadAnalyzer)
+			adminGroup.POST("/generated", c.RegenerateTexts)
+			adminGroup.DELETE("/generated", c.DropGeneratedTexts)
+			adminGroup.POST("/jobs", c.StartJob)
+			adminGroup.GET("/jobs", c.ListJobs)
+			adminGroup.GET("/jobs/:job_id", c.GetJob)
+			adminGroup.POST("/jobs/:job_id/pause", c.PauseJob)
+			adminGroup.POST("/jobs/:job_id/resume", c.ResumeJob)
+			adminGroup.POST("/jobs/:job_id/cancel", c.CancelJob)
 
 			// Start server in a goroutine
 			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
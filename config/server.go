@@ -12,6 +12,10 @@ type Envelope struct {
 	EmbeddingSavePath string            `yaml:"embedding_save_path"`
 	EmbeddingModels   []EmbeddingModel  `yaml:"embedding_models"`
 	GenerationModels  []GenerationModel `yaml:"generation_models"`
+	RerankModels      []RerankModel     `yaml:"rerank_models"`
+	Ingest            Ingest            `yaml:"ingest"`
+	Analyzer          Analyzer          `yaml:"analyzer"`
+	Search            Search            `yaml:"search"`
 }
 type Server struct {
 	Address  string   `yaml:"address"`
@@ -31,6 +35,78 @@ type GenerationModel struct {
 	Config map[string]interface{} `yaml:"config"`
 }
 
+// RerankModel is a cross-encoder served by a rerank API, type is tei or jina
+type RerankModel struct {
+	ID     string                 `yaml:"id"`
+	Type   string                 `yaml:"type"`
+	Config map[string]interface{} `yaml:"config"`
+}
+
+type Ingest struct {
+	// Dedupe is the default policy for text chunks with existing content: allow (default), skip or link
+	Dedupe string `yaml:"dedupe"`
+	// RejectNearDuplicateDistance rejects documents whose SimHash is within the Hamming distance of an existing one,
+	// 0 (default) disables it
+	RejectNearDuplicateDistance int `yaml:"reject_near_duplicate_distance"`
+}
+
+type Search struct {
+	// FacetWindow is the number of nearest text chunks of each embedding model counted by facets, 1000 by default
+	FacetWindow int `yaml:"facet_window"`
+	// RankingProfiles are the named profiles to adjust the scores of search results by `ranking=<name>`
+	RankingProfiles map[string]RankingProfile `yaml:"ranking_profiles"`
+	// DefaultRanking is the name of the ranking profile of requests without `ranking`, none if it's empty
+	DefaultRanking string `yaml:"default_ranking"`
+}
+
+// RankingProfile scores search results by the time decay, a numeric boost and pinned documents
+type RankingProfile struct {
+	// DecayHalfLife is the age of documents whose scores are halved, e.g. 30d or 72h, empty disables the decay
+	DecayHalfLife string `yaml:"decay_half_life"`
+	// BoostField is a numeric key of the data of documents, e.g. rating, scores are multiplied by 1 + boost_weight * value
+	BoostField  string  `yaml:"boost_field"`
+	BoostWeight float64 `yaml:"boost_weight"`
+	// Pins are the IDs of documents moved to the top of results
+	Pins []string `yaml:"pins"`
+	// Candidates is the number of top results to be scored, 50 by default
+	Candidates int `yaml:"candidates"`
+}
+
+// Analyzer configures how texts are tokenized and normalized for full text search,
+// changing it rebuilds the indexes of existing texts on the next start
+type Analyzer struct {
+	// Tokenizer is gse (default), whitespace, unicode (split by Unicode letters and numbers) or ngram (bigrams for Hangul etc.)
+	Tokenizer string `yaml:"tokenizer"`
+	// Normalizers are the steps applied to tokens in order: nfkc, jp2t, t2s, lowercase, stem.
+	// Default is nfkc, t2s, lowercase
+	Normalizers []string `yaml:"normalizers"`
+	// Stemming appends the Snowball stemmer step (stem) to all chains, for Latin-script tokens only
+	Stemming bool `yaml:"stemming"`
+	// Transliterate indexes the pinyin of Hanzi and romaji of kana, so texts can be found by queries in Latin letters
+	Transliterate bool `yaml:"transliterate"`
+	// FilterStopWords removes stop words from tokens, default is true
+	FilterStopWords *bool `yaml:"filter_stop_words"`
+	// Dictionaries are paths of GSE user dictionary files
+	Dictionaries []string `yaml:"dictionaries"`
+	// StopWords are paths of extra stop word files
+	StopWords []string `yaml:"stop_words"`
+	// Synonyms are paths of synonym files, one group of comma separated terms per line
+	Synonyms []string `yaml:"synonyms"`
+	// DetectLanguages are ISO 639-1 codes of languages to be detected, default is zh, ja, en
+	DetectLanguages []string `yaml:"detect_languages"`
+	// Languages overrides the analysis of texts in detected languages by ISO 639-1 code, e.g. ja
+	Languages map[string]AnalyzerLanguage `yaml:"languages"`
+}
+
+type AnalyzerLanguage struct {
+	// Tokenizer is the tokenizer for the language, the default one is used if empty
+	Tokenizer string `yaml:"tokenizer"`
+	// Normalizers are the steps for the language, the default ones are used if empty
+	Normalizers []string `yaml:"normalizers"`
+	// StopWords are paths of stop word files for the language
+	StopWords []string `yaml:"stop_words"`
+}
+
 func LoadConfigFromFile(path string) (*Envelope, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v5"
+	"github.com/samber/lo"
+	"github.com/tsingjyujing/vestigo/controller/dao"
+	"github.com/tsingjyujing/vestigo/text"
+	"github.com/tsingjyujing/vestigo/utils"
+)
+
+const analyzerFingerprintKey = "analyzer_fingerprint"
+
+// checkAnalyzer rebuilds the analysis results of all text chunks if they were built by another analyzer
+func (c *Controller) checkAnalyzer(ctx context.Context, fingerprint string) error {
+	stored, err := c.queries.GetMeta(ctx, analyzerFingerprintKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Unknown analyzer of databases created by older versions, it's cheap to rebuild a fresh database anyway
+		stored = ""
+	} else if err != nil {
+		return err
+	}
+	if stored != fingerprint {
+		logger.Warnf("Analyzer changed from '%s' to '%s', rebuilding the indexes of text chunks", stored, fingerprint)
+		count, err := c.rebuildAnalysis(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Infof("Rebuilt the indexes of %d text chunks", count)
+	}
+	return c.queries.SetMeta(ctx, dao.SetMetaParams{Key: analyzerFingerprintKey, Value: fingerprint})
+}
+
+// rebuildAnalysis recomputes everything depends on the analyzer: seg_content, FTS, content hashes and SimHash,
+// the language is detected again unless it was given, since the languages to detect may change
+func (c *Controller) rebuildAnalysis(ctx context.Context) (int, error) {
+	return c.reanalyzeTextChunks(ctx, nil)
+}
+
+// reanalyzeTextChunks rebuilds the analysis of text chunks whose content is affected, or all of them if affected is nil
+func (c *Controller) reanalyzeTextChunks(ctx context.Context, affected func(content string) bool) (int, error) {
+	return utils.WithTx(
+		ctx,
+		c.db,
+		nil,
+		func(tx *sql.Tx) (int, error) {
+			queries := dao.New(tx)
+			rows, err := queries.ListAllTextChunkContents(ctx)
+			if err != nil {
+				return 0, err
+			}
+			ids := make([]string, 0)
+			docIds := make(map[string]bool)
+			for _, row := range rows {
+				if affected != nil && !affected(row.Content) {
+					continue
+				}
+				language := c.detectLanguage(TextInput{Content: row.Content, Language: lo.Ternary(row.LanguageDetected, "", row.Language)})
+				segContent := c.segmentText(row.Content, language)
+				if err := queries.UpdateTextChunkAnalysis(ctx, dao.UpdateTextChunkAnalysisParams{
+					SegContent:  segContent,
+					ContentHash: c.contentHash(row.Content),
+					SimHash:     segSimHash(segContent),
+					Language:    language,
+					ID:          row.ID,
+				}); err != nil {
+					return 0, err
+				}
+				ids = append(ids, row.ID)
+				docIds[row.DocumentID] = true
+			}
+			// The id of the FTS table is not indexed, the rows are replaced in bulk rather than updated one by one
+			if err := reindexTextChunkFTS(ctx, queries, lo.Ternary(affected == nil, nil, ids)); err != nil {
+				return 0, err
+			}
+			// Content hashes may change with the analyzer, duplicates are no longer linked to originals with other hashes
+			if err := queries.ClearStaleDuplicateOf(ctx); err != nil {
+				return 0, err
+			}
+			for docId := range docIds {
+				if err := refreshDocumentSimHash(ctx, queries, docId); err != nil {
+					return 0, err
+				}
+				if err := queries.ClearStaleDocumentDuplicateOf(ctx, docId); err != nil {
+					return 0, err
+				}
+			}
+			return len(ids), nil
+		},
+	)
+}
+
+// reindexTextChunkFTS replaces the FTS rows of text chunks by their seg_content, or all rows if ids is nil
+func reindexTextChunkFTS(ctx context.Context, queries *dao.Queries, ids []string) error {
+	if ids == nil {
+		if err := queries.DeleteAllTextChunkFTS(ctx); err != nil {
+			return err
+		}
+		return queries.InsertAllTextChunkFTS(ctx)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	idsJSON, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	if err := queries.DeleteTextChunkFTSByIDs(ctx, string(idsJSON)); err != nil {
+		return err
+	}
+	return queries.InsertTextChunkFTSByIDs(ctx, string(idsJSON))
+}
+
+// AnalyzerReloadReport is the result of reloading the user files of the analyzer
+type AnalyzerReloadReport struct {
+	Fingerprint string `json:"fingerprint"`
+	// ChangedWords are the words added, removed or changed in dictionaries, stop words and synonyms
+	ChangedWords []string `json:"changed_words"`
+	// ReanalyzedTextChunks is the number of text chunks containing the changed words
+	ReanalyzedTextChunks int `json:"reanalyzed_text_chunks"`
+}
+
+// ReloadAnalyzer reloads dictionaries, stop words and synonyms from files,
+// and analyzes the text chunks containing the changed words again
+func (c *Controller) ReloadAnalyzer(echoCtx *echo.Context) error {
+	ctx := echoCtx.Request().Context()
+	c.reloadLock.Lock()
+	defer c.reloadLock.Unlock()
+	analyzer, err := text.NewAnalyzer(c.options.Analyzer)
+	if err != nil {
+		return utils.EchoHandleGenericError(echoCtx, fmt.Errorf("failed to create analyzer: %w", err), http.StatusBadRequest)
+	}
+	previous := c.analyzer.Swap(analyzer)
+	report := AnalyzerReloadReport{Fingerprint: analyzer.Fingerprint(), ChangedWords: analyzer.ChangedWords(previous)}
+	if len(report.ChangedWords) > 0 {
+		normalizedWords := make([]string, 0, len(report.ChangedWords))
+		for _, word := range report.ChangedWords {
+			if normalized, err := analyzer.Normalizer.Normalize(word); err == nil {
+				normalizedWords = append(normalizedWords, normalized)
+			}
+		}
+		report.ReanalyzedTextChunks, err = c.reanalyzeTextChunks(ctx, func(content string) bool {
+			lowerContent := strings.ToLower(content)
+			if lo.SomeBy(report.ChangedWords, func(word string) bool { return strings.Contains(lowerContent, strings.ToLower(word)) }) {
+				return true
+			}
+			normalizedContent, err := analyzer.Normalizer.Normalize(content)
+			if err != nil {
+				return true
+			}
+			return lo.SomeBy(normalizedWords, func(word string) bool { return strings.Contains(normalizedContent, word) })
+		})
+		if err != nil {
+			// Keep the indexes consistent with the analyzer
+			c.analyzer.Store(previous)
+			return utils.EchoHandleInternalError(echoCtx, err)
+		}
+		if err := c.loadSuggestionTerms(ctx); err != nil {
+			return utils.EchoHandleInternalError(echoCtx, err)
+		}
+	}
+	if err := c.queries.SetMeta(ctx, dao.SetMetaParams{Key: analyzerFingerprintKey, Value: report.Fingerprint}); err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	logger.Infof("Analyzer reloaded with %d changed words, %d text chunks analyzed again", len(report.ChangedWords), report.ReanalyzedTextChunks)
+	return utils.EchoJsonResponse(echoCtx, report, http.StatusOK)
+}
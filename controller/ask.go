@@ -0,0 +1,334 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v5"
+	"github.com/samber/lo"
+	"github.com/tsingjyujing/vestigo/models"
+	"github.com/tsingjyujing/vestigo/utils"
+)
+
+const (
+	// RetrievalBM25 retrieves text chunks by the full text index, the other methods are the IDs of embedding models
+	RetrievalBM25 = "bm25"
+	// RetrievalHybrid fuses the results of BM25 and all embedding models by reciprocal rank fusion
+	RetrievalHybrid = "hybrid"
+	// reciprocalRankFusionK reduces the weight of top ranks in the fusion, 60 is the value of the original paper
+	reciprocalRankFusionK = 60
+	defaultAskTextChunks  = 5
+	// defaultAskContextLength is the max number of characters of the retrieved texts in the prompt
+	defaultAskContextLength = 6000
+)
+
+// The events of streamed answers
+const (
+	SSEEventCitations = "citations"
+	SSEEventDelta     = "delta"
+	SSEEventDone      = "done"
+	SSEEventError     = "error"
+)
+
+const askSystemPrompt = `You answer questions only with the numbered context passages given by the user.
+Cite the passages supporting each statement by their numbers in square brackets, e.g. [1] or [1][3].
+If the context doesn't contain the answer, say that you don't know. Answer in the language of the question.`
+
+// citationPattern matches the citations like [1] in answers
+var citationPattern = regexp.MustCompile(`\[(\d+)]`)
+
+type AskParams struct {
+	Question         string `json:"question" jsonschema:"the question to answer"`
+	Model            string `json:"model,omitempty" jsonschema:"the ID of the generation model, optional if there is only one"`
+	Retrieval        string `json:"retrieval,omitempty" jsonschema:"how to retrieve the context: bm25 (default), hybrid or the ID of an embedding model"`
+	Query            string `json:"query,omitempty" jsonschema:"the query in the query language to retrieve the context instead of the question, e.g. to filter by fields"`
+	N                int    `json:"n,omitempty" jsonschema:"the max number of text chunks in the context, 5 by default"`
+	MaxContextLength int    `json:"max_context_length,omitempty" jsonschema:"the max number of characters of the context, 6000 by default"`
+	Rewrite          string `json:"rewrite,omitempty" jsonschema:"the ID of a generation model to expand the question by keywords and a hypothetical answer before retrieving"`
+}
+
+type Citation struct {
+	Index       int    `json:"index" jsonschema:"the number of the text chunk in the context, cited like [1] in the answer"`
+	TextChunkID string `json:"text_chunk_id" jsonschema:"the ID of the text chunk"`
+	DocumentID  string `json:"document_id" jsonschema:"the ID of the document"`
+	Title       string `json:"title" jsonschema:"the title of the document"`
+	Content     string `json:"content" jsonschema:"the content of the text chunk in the context, it may be truncated"`
+	Cited       bool   `json:"cited" jsonschema:"whether the answer cites the text chunk"`
+}
+
+type AskResponse struct {
+	Answer    string        `json:"answer" jsonschema:"the answer of the question"`
+	Model     string        `json:"model" jsonschema:"the ID of the generation model"`
+	Citations []Citation    `json:"citations" jsonschema:"the text chunks in the context, the ones cited by the answer have cited set"`
+	Rewrite   *QueryRewrite `json:"rewrite,omitempty" jsonschema:"the variants of the question searched besides it"`
+}
+
+// retrieve searches text chunks by BM25, an embedding model or both, ftsQuery is for BM25 and text is for embedding models
+func (c *Controller) retrieve(ctx context.Context, embeddings queryEmbeddings, method string, ftsQuery string, text string, nDoc int, filter *searchFilter) ([]SearchResultItem, error) {
+	switch strings.ToLower(method) {
+	case RetrievalBM25:
+		return c.searchWithBM25(ctx, expandQuery(c.analyzer.Load(), ftsQuery), nDoc, filter)
+	case RetrievalHybrid:
+		rankings := make([][]SearchResultItem, 0, len(c.embeddingIndexes)+1)
+		if ftsQuery != "" {
+			bm25Results, err := c.searchWithBM25(ctx, expandQuery(c.analyzer.Load(), ftsQuery), nDoc, filter)
+			if err != nil {
+				return nil, err
+			}
+			rankings = append(rankings, bm25Results)
+		}
+		for modelId := range c.embeddingIndexes {
+			results, err := c.searchWithEmbeddingModel(ctx, embeddings, modelId, text, nDoc, filter)
+			if err != nil {
+				return nil, err
+			}
+			rankings = append(rankings, results)
+		}
+		return fuseRankings(rankings, nDoc), nil
+	}
+	return c.searchWithEmbeddingModel(ctx, embeddings, method, text, nDoc, filter)
+}
+
+// validateRetrieval checks the retrieval method is bm25, hybrid or an embedding model with an index
+func (c *Controller) validateRetrieval(method string) error {
+	if lo.Contains([]string{RetrievalBM25, RetrievalHybrid}, strings.ToLower(method)) {
+		return nil
+	}
+	_, okModel := c.embeddingModels[method]
+	_, okAnnIndex := c.embeddingIndexes[method]
+	if !okModel || !okAnnIndex {
+		return fmt.Errorf("model '%s' not found", method)
+	}
+	return nil
+}
+
+// fuseRankings merges rankings by reciprocal rank fusion, the scores are the sums of 1/(k+rank)
+func fuseRankings(rankings [][]SearchResultItem, nDoc int) []SearchResultItem {
+	fused := make(map[string]*SearchResultItem)
+	for _, ranking := range rankings {
+		for rank, item := range ranking {
+			if _, ok := fused[item.TextChunkID]; !ok {
+				item.Score = 0
+				fused[item.TextChunkID] = &item
+			}
+			fused[item.TextChunkID].Score += 1 / float64(reciprocalRankFusionK+rank+1)
+		}
+	}
+	results := make([]SearchResultItem, 0, len(fused))
+	for _, item := range fused {
+		results = append(results, *item)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].TextChunkID < results[j].TextChunkID
+	})
+	return lo.Slice(results, 0, nDoc)
+}
+
+// questionQuery builds the FTS5 query of questions in natural language: any of their words, ranked by BM25
+func (c *Controller) questionQuery(questions ...string) string {
+	analyzer := c.analyzer.Load()
+	terms := make([]string, 0)
+	for _, question := range questions {
+		words := analyzer.TokenizerOf(analyzer.DetectLanguage(question)).Tokenize(question)
+		terms = append(terms, lo.FilterMap(words, func(word string, index int) (string, bool) {
+			if strings.TrimSpace(word) == "" || !strings.ContainsFunc(word, func(r rune) bool { return !isNotBarewordRune(r) }) {
+				return "", false
+			}
+			return fts5Term(queryToken{kind: queryTokenWord, text: word}), true
+		})...)
+	}
+	return strings.Join(lo.Uniq(terms), " OR ")
+}
+
+// buildAskPrompt numbers the retrieved texts in the context until the max length, the last one may be truncated
+func buildAskPrompt(question string, results []SearchResultItem, maxContextLength int) (string, []Citation) {
+	citations := make([]Citation, 0, len(results))
+	var builder strings.Builder
+	builder.WriteString("Context:\n")
+	remaining := maxContextLength
+	for _, item := range results {
+		if remaining <= 0 {
+			break
+		}
+		content := item.Content
+		if utf8.RuneCountInString(content) > remaining {
+			content = string([]rune(content)[:remaining])
+		}
+		remaining -= utf8.RuneCountInString(content)
+		citation := Citation{
+			Index:       len(citations) + 1,
+			TextChunkID: item.TextChunkID,
+			DocumentID:  item.DocumentID,
+			Title:       item.Title,
+			Content:     content,
+		}
+		citations = append(citations, citation)
+		builder.WriteString(fmt.Sprintf("\n[%d] %s\n%s\n", citation.Index, citation.Title, citation.Content))
+	}
+	if len(citations) == 0 {
+		builder.WriteString("\n(no passages found)\n")
+	}
+	builder.WriteString("\nQuestion: ")
+	builder.WriteString(question)
+	return builder.String(), citations
+}
+
+// markCitations sets Cited of the text chunks cited by the answer
+func markCitations(answer string, citations []Citation) {
+	for _, match := range citationPattern.FindAllStringSubmatch(answer, -1) {
+		index, err := strconv.Atoi(match[1])
+		if err == nil && index >= 1 && index <= len(citations) {
+			citations[index-1].Cited = true
+		}
+	}
+}
+
+// getGenerationModel returns the generation model by ID, the only model is the default
+func (c *Controller) getGenerationModel(modelId string) (string, models.GenerationModel, error) {
+	if modelId == "" {
+		if len(c.generationModels) != 1 {
+			return "", nil, fmt.Errorf("parameter 'model' is required when there are %d generation models", len(c.generationModels))
+		}
+		for id, model := range c.generationModels {
+			return id, model, nil
+		}
+	}
+	model, ok := c.generationModels[modelId]
+	if !ok {
+		return "", nil, fmt.Errorf("generation model '%s' not found", modelId)
+	}
+	return modelId, model, nil
+}
+
+// askRequest is a validated request of asking
+type askRequest struct {
+	AskParams
+	modelId      string
+	model        models.GenerationModel
+	ftsQuery     string
+	text         string
+	filter       *searchFilter
+	rewriteModel models.GenerationModel
+	rewrite      *QueryRewrite
+}
+
+// parseAskParams binds and validates the parameters of asking, the errors are bad requests
+func (c *Controller) parseAskParams(echoCtx *echo.Context) (*askRequest, error) {
+	request := &askRequest{filter: &searchFilter{}}
+	if err := echoCtx.Bind(&request.AskParams); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(request.Question) == "" {
+		return nil, fmt.Errorf("parameter 'question' is required")
+	}
+	if request.N <= 0 {
+		request.N = defaultAskTextChunks
+	}
+	if request.MaxContextLength <= 0 {
+		request.MaxContextLength = defaultAskContextLength
+	}
+	if request.Retrieval == "" {
+		request.Retrieval = RetrievalBM25
+	}
+	var err error
+	if request.modelId, request.model, err = c.getGenerationModel(request.Model); err != nil {
+		return nil, err
+	}
+	if err := c.validateRetrieval(request.Retrieval); err != nil {
+		return nil, err
+	}
+	if request.Rewrite != "" {
+		if _, request.rewriteModel, err = c.getGenerationModel(request.Rewrite); err != nil {
+			return nil, err
+		}
+	}
+	request.ftsQuery, request.text = c.questionQuery(request.Question), request.Question
+	if request.Query != "" {
+		parsed, err := parseQuery(request.Query)
+		if err != nil {
+			return nil, err
+		}
+		if parsed.text != "" {
+			request.ftsQuery, request.text = parsed.fts5, parsed.text
+		}
+		request.filter = parsed.filter
+	}
+	return request, nil
+}
+
+// buildAskContext retrieves the text chunks for the question and builds the prompt with them
+func (c *Controller) buildAskContext(ctx context.Context, request *askRequest) (string, []Citation, error) {
+	if request.rewriteModel != nil {
+		var err error
+		if request.rewrite, err = c.rewriteQuery(ctx, request.Rewrite, request.rewriteModel, request.Question); err != nil {
+			return "", nil, err
+		}
+	}
+	results, err := c.retrieveRewritten(ctx, make(queryEmbeddings), request.Retrieval, request.ftsQuery, request.text, request.N, request.filter, request.rewrite)
+	if err != nil {
+		return "", nil, err
+	}
+	prompt, citations := buildAskPrompt(request.Question, results, request.MaxContextLength)
+	return prompt, citations, nil
+}
+
+// Ask answers the question by a generation model with the retrieved text chunks, citing them in the answer
+func (c *Controller) Ask(echoCtx *echo.Context) error {
+	ctx := echoCtx.Request().Context()
+	request, err := c.parseAskParams(echoCtx)
+	if err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	prompt, citations, err := c.buildAskContext(ctx, request)
+	if err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	answer, err := request.model.Chat(ctx, askSystemPrompt, prompt)
+	if err != nil {
+		return utils.EchoHandleInternalError(echoCtx, fmt.Errorf("failed to generate the answer by %s: %w", request.modelId, err))
+	}
+	markCitations(answer, citations)
+	return utils.EchoJsonResponse(echoCtx, AskResponse{Answer: answer, Model: request.modelId, Citations: citations, Rewrite: request.rewrite}, http.StatusOK)
+}
+
+// AskDelta is a piece of the answer streamed by AskStream
+type AskDelta struct {
+	Text string `json:"text"`
+}
+
+// AskStream answers like Ask as Server-Sent Events: a "citations" event with the text chunks in the context,
+// "delta" events with the pieces of the answer as they are generated, and a "done" event with the AskResponse,
+// or an "error" event if the generation fails
+func (c *Controller) AskStream(echoCtx *echo.Context) error {
+	ctx := echoCtx.Request().Context()
+	request, err := c.parseAskParams(echoCtx)
+	if err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	prompt, citations, err := c.buildAskContext(ctx, request)
+	if err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	send := utils.EchoSSE(echoCtx)
+	if err := send(SSEEventCitations, citations); err != nil {
+		return err
+	}
+	answer, err := request.model.ChatStream(ctx, askSystemPrompt, prompt, func(delta string) error {
+		return send(SSEEventDelta, AskDelta{Text: delta})
+	})
+	if err != nil {
+		logger.WithError(err).WithField("model", request.modelId).Error("failed to stream the answer")
+		return send(SSEEventError, map[string]string{"status": fmt.Sprintf("failed to generate the answer by %s: %v", request.modelId, err)})
+	}
+	markCitations(answer, citations)
+	return send(SSEEventDone, AskResponse{Answer: answer, Model: request.modelId, Citations: citations, Rewrite: request.rewrite})
+}
@@ -5,14 +5,20 @@ import (
 	"database/sql"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/coder/hnsw"
 	"github.com/google/uuid"
@@ -36,33 +42,85 @@ var logger = utils.Logger
 type Controller struct {
 	db                *sql.DB
 	queries           dao.Queries
-	tokenizer         text.Tokenizer
-	normalizer        text.Normalizer
+	analyzer          atomic.Pointer[text.Analyzer]
+	reloadLock        sync.Mutex // serializes reloading the analyzer
 	embeddingModels   map[string]models.BaseEmbeddingModel
 	embeddingIndexes  map[string]*hnsw.SavedGraph[string]
 	generationModels  map[string]models.GenerationModel
+	suggester         *suggester
+	jobs              *jobRunner
 	embeddingSavePath string
+	options           Options
+}
+
+// Options are the optional settings of the controller, the zero value keeps the default behaviors
+type Options struct {
+	// DedupePolicy is the default policy for text chunks with existing content, see DedupePolicyAllow etc.
+	DedupePolicy string
+	// RejectNearDuplicateDistance rejects new documents whose SimHash is within the distance of an existing one,
+	// 0 disables the check
+	RejectNearDuplicateDistance int
+	// Analyzer configures the tokenizer and normalizer, changing it rebuilds the indexes of existing text chunks
+	Analyzer text.AnalyzerOptions
+	// RerankModels are the cross-encoders to rerank search results by `rerank=<model_id>`, generation models can also rerank
+	RerankModels map[string]models.RerankModel
+	// FacetWindow is the number of nearest text chunks of each embedding model counted by facets, 1000 by default
+	FacetWindow int
+	// RankingProfiles are the named profiles to adjust the scores of search results by `ranking=<name>`
+	RankingProfiles map[string]RankingProfile
+	// DefaultRanking is the name of the ranking profile of requests without `ranking`, none if it's empty
+	DefaultRanking string
 }
 
 // NewController creates a new Controller instance with the given database connection and models
-func NewController(db *sql.DB, embeddingModels map[string]models.BaseEmbeddingModel, embeddingSavePath string, generationModels map[string]models.GenerationModel) (*Controller, error) {
-	tokenizer, err := text.NewGSETokenizer(true)
-	if err != nil {
+func NewController(db *sql.DB, embeddingModels map[string]models.BaseEmbeddingModel, embeddingSavePath string, generationModels map[string]models.GenerationModel, options Options) (*Controller, error) {
+	if options.DedupePolicy != "" {
+		if err := validateDedupePolicy(options.DedupePolicy); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateNearDuplicateDistance(options.RejectNearDuplicateDistance); err != nil {
 		return nil, err
 	}
-	normalizer, err := text.NewCJKNormalizer(false, true) // TODO make configurable
+	for name, profile := range options.RankingProfiles {
+		if err := profile.validate(); err != nil {
+			return nil, fmt.Errorf("invalid ranking profile %s: %w", name, err)
+		}
+	}
+	if _, ok := options.RankingProfiles[options.DefaultRanking]; options.DefaultRanking != "" && !ok {
+		return nil, fmt.Errorf("default ranking profile '%s' not found", options.DefaultRanking)
+	}
+	analyzer, err := text.NewAnalyzer(options.Analyzer)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to create analyzer: %w", err)
+	}
+	suggester, err := newSuggester()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create suggester: %w", err)
 	}
 	embeddingIndexes := make(map[string]*hnsw.SavedGraph[string])
 	controller := &Controller{
 		queries:           *dao.New(db),
 		db:                db,
-		tokenizer:         tokenizer,
-		normalizer:        normalizer,
 		embeddingModels:   embeddingModels,
 		generationModels:  generationModels,
+		suggester:         suggester,
+		jobs:              newJobRunner(),
 		embeddingSavePath: embeddingSavePath,
+		options:           options,
+	}
+	controller.analyzer.Store(analyzer)
+	if err := controller.checkAnalyzer(context.Background(), analyzer.Fingerprint()); err != nil {
+		return nil, fmt.Errorf("failed to check analyzer: %w", err)
+	}
+	if err := controller.backfillContentHashes(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to compute content hashes: %w", err)
+	}
+	if err := controller.backfillSimHashes(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to compute SimHash: %w", err)
+	}
+	if err := controller.loadSuggestions(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to load suggestions: %w", err)
 	}
 	for modeName := range embeddingModels {
 		if graph, err := controller.loadEmbeddingModel(context.Background(), modeName); err != nil {
@@ -72,11 +130,15 @@ func NewController(db *sql.DB, embeddingModels map[string]models.BaseEmbeddingMo
 		}
 	}
 	controller.embeddingIndexes = embeddingIndexes
+	if err := controller.resumeJobs(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to resume jobs: %w", err)
+	}
 	return controller, nil
 }
 
 // Close closes all resources held by the controller
 func (c *Controller) Close() error {
+	c.jobs.close()
 	for modelId, graph := range c.embeddingIndexes {
 		err := graph.Save()
 		if err != nil {
@@ -209,12 +271,70 @@ func (c *Controller) regenerateMissingEmbeddings(ctx context.Context, modelId st
 	return emittedCount, nil
 }
 
+// TextInput is a text chunk to be created, it can be given as a plain string or an object with metadata
+type TextInput struct {
+	Content  string         `json:"content"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+	// Language is the ISO 639-1 code of the content, it's detected if not given
+	Language string `json:"language,omitempty"`
+	// origin is set for texts generated by models, it can't be given by requests
+	origin *TextOrigin
+}
+
+func (t *TextInput) UnmarshalJSON(data []byte) error {
+	var content string
+	if err := json.Unmarshal(data, &content); err == nil {
+		*t = TextInput{Content: content}
+		return nil
+	}
+	type textInput TextInput // avoid recursion
+	var input textInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		return err
+	}
+	*t = TextInput(input)
+	return nil
+}
+
 type NewDocumentParams struct {
 	ID          string                 `json:"id"`
 	Title       string                 `json:"title"`
 	Description string                 `json:"description"`
 	Data        map[string]interface{} `json:"data"`
-	Texts       []string               `json:"texts"`
+	Texts       []TextInput            `json:"texts"`
+}
+
+// NewDocumentResponse reports the text chunks deduplicated by the dedupe policy
+type NewDocumentResponse struct {
+	// Status is ok if the document is created, or skipped if it's a duplicate of an existing one
+	Status string `json:"status"`
+	// DuplicateOf is the existing document with the same texts
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	// Skipped are the IDs of the existing text chunks whose duplicates are not stored
+	Skipped []string `json:"skipped,omitempty"`
+	// Linked are the IDs of the new text chunks linked to existing ones
+	Linked []string `json:"linked,omitempty"`
+}
+
+// marshalJSONObject converts a map to JSON string, default to empty object
+func marshalJSONObject(data map[string]any) (string, error) {
+	if len(data) == 0 {
+		return "{}", nil
+	}
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+// unmarshalJSONObject converts a JSON string stored in database to map
+func unmarshalJSONObject(data string) map[string]any {
+	dataMap := make(map[string]any)
+	if err := json.Unmarshal([]byte(data), &dataMap); err != nil {
+		logger.WithError(err).Errorf("Failed to parse JSON object: %s", data)
+	}
+	return dataMap
 }
 
 func (c *Controller) NewDocument(echoCtx *echo.Context) error {
@@ -229,33 +349,68 @@ func (c *Controller) NewDocument(echoCtx *echo.Context) error {
 	overwrite := (*echoCtx).QueryParam("overwrite")
 	shouldOverwrite := overwrite == "true" || overwrite == "1"
 
-	// AI generation enabled?
-	aiGen := (*echoCtx).QueryParam("ai_gen")
-	aiGenerateEnabled := aiGen == "true" || aiGen == "1"
-
-	// If enabled, summarize the texts and append to texts
-	if aiGenerateEnabled && len(param.Texts) > 0 {
-		generatedTexts := make([]string, 0)
-		for modelId, model := range c.generationModels {
-			generatedText, err := model.Generate(ctx, param.Texts)
+	dedupePolicy, err := c.getDedupePolicy(echoCtx)
+	if err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	// A document with the same texts as an existing one is skipped or linked as a whole
+	duplicateOf := ""
+	if dedupePolicy != DedupePolicyAllow {
+		if duplicateOf, err = c.findDuplicateDocument(ctx, param.ID, param.Texts); err != nil {
+			return utils.EchoHandleInternalError(echoCtx, err)
+		}
+		if duplicateOf != "" && dedupePolicy == DedupePolicySkip {
+			rows, err := c.queries.ListTextChunksByDocumentID(ctx, duplicateOf)
 			if err != nil {
-				logger.WithError(err).WithField("model", modelId).Error("failed to generate text from document")
-			} else {
-				generatedTexts = append(generatedTexts, generatedText)
-				logger.WithField("texts", generatedText).WithField("model", modelId).Debug("generated text")
+				return utils.EchoHandleInternalError(echoCtx, err)
 			}
+			logger.WithField("document_id", param.ID).WithField("duplicate_of", duplicateOf).Info("Skipped duplicated document")
+			return utils.EchoJsonResponse(echoCtx, NewDocumentResponse{
+				Status:      "skipped",
+				DuplicateOf: duplicateOf,
+				Skipped:     lo.FilterMap(rows, func(item dao.TextChunk, index int) (string, bool) { return item.ID, item.GeneratedBy == "" }),
+			}, http.StatusOK)
+		}
+	}
+	rejectDistance, err := c.getRejectNearDuplicateDistance(echoCtx)
+	if err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	if rejectDistance > 0 {
+		// The document to be overwritten is not a duplicate of itself
+		nearDuplicate, err := c.findNearDuplicateDocument(ctx, param.Texts, param.ID, rejectDistance)
+		if err != nil {
+			return utils.EchoHandleInternalError(echoCtx, err)
 		}
-		if len(generatedTexts) > 0 {
-			param.Texts = append(param.Texts, generatedTexts...)
+		if nearDuplicate != "" {
+			return utils.EchoHandleGenericError(echoCtx, fmt.Errorf("document is a near duplicate of %s", nearDuplicate), http.StatusConflict)
 		}
 	}
 
-	insertCount, err := utils.WithTx(
+	// AI generation by `ai_gen=true` for all models or `ai_gen=<model_id>,<model_id>`
+	generationModelIds, err := c.parseGenerationModels(echoCtx)
+	if err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+
+	// If enabled, summarize the texts and append to texts, and merge the extracted fields into data
+	if len(generationModelIds) > 0 && len(param.Texts) > 0 {
+		sourceTexts := lo.Map(param.Texts, func(item TextInput, index int) string { return item.Content })
+		document := models.GenerationDocument{Title: param.Title, Description: param.Description, Data: param.Data}
+		generatedTexts, fields, _ := c.generateTexts(ctx, document, sourceTexts, generationModelIds)
+		param.Texts = append(param.Texts, generatedTexts...)
+		param.Data = mergeGeneratedFields(param.Data, fields)
+	}
+
+	deltas := &suggestionDeltas{}
+	insertCount := 0
+	response, err := utils.WithTx(
 		ctx,
 		c.db,
 		nil,
-		func(tx *sql.Tx) (int, error) {
+		func(tx *sql.Tx) (NewDocumentResponse, error) {
 			queries := dao.New(tx)
+			response := NewDocumentResponse{Status: "ok", DuplicateOf: duplicateOf}
 
 			// If overwrite is enabled, delete existing document first
 			if shouldOverwrite {
@@ -263,8 +418,8 @@ func (c *Controller) NewDocument(echoCtx *echo.Context) error {
 				_, err := queries.GetDocument(ctx, param.ID)
 				if err == nil {
 					// Document exists, delete it using the shared internal function
-					if err := c.deleteDocumentInternal(ctx, queries, param.ID); err != nil {
-						return 0, err
+					if err := c.deleteDocumentInternal(ctx, queries, param.ID, deltas); err != nil {
+						return response, err
 					}
 					logger.WithField("document_id", param.ID).Info("Deleted existing document for overwrite")
 				}
@@ -272,39 +427,51 @@ func (c *Controller) NewDocument(echoCtx *echo.Context) error {
 			}
 
 			// Convert data map to JSON string, default to empty object
-			dataJSON := "{}"
-			if len(param.Data) > 0 {
-				jsonBytes, err := json.Marshal(param.Data)
-				if err != nil {
-					return 0, err
-				}
-				dataJSON = string(jsonBytes)
+			dataJSON, err := marshalJSONObject(param.Data)
+			if err != nil {
+				return response, err
 			}
-			err := queries.NewDocument(ctx, dao.NewDocumentParams{
+			err = queries.NewDocument(ctx, dao.NewDocumentParams{
 				ID:          param.ID,
 				Title:       param.Title,
 				Description: param.Description,
 				Data:        dataJSON,
+				DuplicateOf: duplicateOf,
 			})
 			if err != nil {
-				return 0, err
+				return response, err
 			}
+			deltas.updateTitle(param.Title, 1)
 			textChunks := make([]*dao.TextChunk, 0, len(param.Texts))
 			for _, t := range param.Texts {
-				tc, err := c.createTextChunks(ctx, param.ID, queries, t)
+				tc, created, err := c.createTextChunks(ctx, param.ID, queries, t, int64(len(textChunks)), dedupePolicy, deltas)
 				if err != nil {
-					return 0, err
+					return response, err
+				}
+				switch {
+				case !created:
+					logger.WithField("duplicate_of", tc.ID).Debug("Skipped duplicated text chunk")
+					response.Skipped = append(response.Skipped, tc.ID)
+				case tc.DuplicateOf != "":
+					textChunks = append(textChunks, tc)
+					response.Linked = append(response.Linked, tc.ID)
+				default:
+					textChunks = append(textChunks, tc)
 				}
-				textChunks = append(textChunks, tc)
 			}
-			return len(textChunks), nil
+			if err := refreshDocumentSimHash(ctx, queries, param.ID); err != nil {
+				return response, err
+			}
+			insertCount = len(textChunks)
+			return response, nil
 		},
 	)
 	if err != nil {
 		return utils.EchoHandleSQLError(echoCtx, err)
 	}
+	c.suggester.apply(c.analyzer.Load(), deltas)
 	logger.WithField("inserted_text_chunks", insertCount).Debug("Inserted text chunks for new document")
-	return (*echoCtx).JSON(http.StatusCreated, map[string]string{"status": "ok"})
+	return utils.EchoJsonResponse(echoCtx, response, http.StatusCreated)
 }
 
 type Document struct {
@@ -312,12 +479,14 @@ type Document struct {
 	Title       string         `json:"title"`
 	Description string         `json:"description"`
 	Data        map[string]any `json:"data"`
+	SimHash     string         `json:"simhash"`
+	DuplicateOf string         `json:"duplicate_of,omitempty"`
 	CreatedAt   int64          `json:"created_at"`
 }
 
 type DocumentWithChunks struct {
 	Document
-	Texts []dao.TextChunk `json:"texts,omitempty"`
+	Texts []TextChunk `json:"texts,omitempty"`
 }
 
 func (c *Controller) GetDocument(echoCtx *echo.Context) error {
@@ -343,6 +512,8 @@ func (c *Controller) GetDocument(echoCtx *echo.Context) error {
 		Title:       row.Title,
 		Description: row.Description,
 		Data:        dataMap,
+		SimHash:     formatSimHash(row.SimHash),
+		DuplicateOf: row.DuplicateOf,
 		CreatedAt:   row.CreatedAt,
 	}
 
@@ -358,7 +529,7 @@ func (c *Controller) GetDocument(echoCtx *echo.Context) error {
 		// Return document with chunks
 		documentWithChunks := DocumentWithChunks{
 			Document: document,
-			Texts:    texts,
+			Texts:    lo.Map(texts, func(item dao.TextChunk, index int) TextChunk { return newTextChunk(item) }),
 		}
 		return utils.EchoJsonResponse(echoCtx, documentWithChunks, http.StatusOK)
 	}
@@ -367,9 +538,16 @@ func (c *Controller) GetDocument(echoCtx *echo.Context) error {
 }
 
 // deleteDocumentInternal deletes a document and all related text chunks / embeddings
-// This is an internal helper function used by both DeleteDocument and NewDocument (with overwrite)
-func (c *Controller) deleteDocumentInternal(ctx context.Context, queries *dao.Queries, docId string) error {
-	textChunkIds, err := queries.ListTextChunkIdByDocumentID(ctx, docId)
+// This is an internal helper function used by both DeleteDocument and NewDocument (with overwrite),
+// the changes of suggestions are collected into deltas to be applied after commit
+func (c *Controller) deleteDocumentInternal(ctx context.Context, queries *dao.Queries, docId string, deltas *suggestionDeltas) error {
+	document, err := queries.GetDocument(ctx, docId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	textChunks, err := queries.ListTextChunksByDocumentID(ctx, docId)
 	if err != nil {
 		return err
 	}
@@ -381,6 +559,10 @@ func (c *Controller) deleteDocumentInternal(ctx context.Context, queries *dao.Qu
 	if err := queries.DeleteTextChunkFTSByDocumentID(ctx, docId); err != nil {
 		return err
 	}
+	// Text chunks in other documents are no longer duplicates of the deleted ones
+	if err := queries.ClearDuplicateOfByDocumentID(ctx, docId); err != nil {
+		return err
+	}
 	// Delete text chunks
 	if err := queries.DeleteTextChunksByDocumentID(ctx, docId); err != nil {
 		return err
@@ -389,9 +571,15 @@ func (c *Controller) deleteDocumentInternal(ctx context.Context, queries *dao.Qu
 	if err := queries.DeleteDocument(ctx, docId); err != nil {
 		return err
 	}
-	for _, textChunkId := range textChunkIds {
-		c.deleteTextChunkFromIndex(textChunkId)
+	// Documents linked to the deleted one are no longer duplicates
+	if err := queries.ClearStaleDocumentDuplicateOf(ctx, docId); err != nil {
+		return err
+	}
+	for _, textChunk := range textChunks {
+		c.deleteTextChunkFromIndex(textChunk.ID)
+		deltas.addSegContent(textChunk.SegContent, -1)
 	}
+	deltas.updateTitle(document.Title, -1)
 	return nil
 }
 
@@ -399,12 +587,13 @@ func (c *Controller) deleteDocumentInternal(ctx context.Context, queries *dao.Qu
 func (c *Controller) DeleteDocument(echoCtx *echo.Context) error {
 	ctx := echoCtx.Request().Context()
 	docId := echoCtx.Param("doc_id")
+	deltas := &suggestionDeltas{}
 	_, err := utils.WithTx(
 		ctx,
 		c.db,
 		nil,
 		func(tx *sql.Tx) (any, error) {
-			if err := c.deleteDocumentInternal(ctx, dao.New(tx), docId); err != nil {
+			if err := c.deleteDocumentInternal(ctx, dao.New(tx), docId, deltas); err != nil {
 				return nil, err
 			}
 			return nil, nil
@@ -413,15 +602,40 @@ func (c *Controller) DeleteDocument(echoCtx *echo.Context) error {
 	if err != nil {
 		return utils.EchoHandleInternalError(echoCtx, err)
 	}
+	c.suggester.apply(c.analyzer.Load(), deltas)
 	return echoCtx.JSON(http.StatusOK, map[string]string{"status": "ok"})
 }
 
 type TextChunk struct {
-	ID         string `json:"id"`
-	DocumentID string `json:"document_id"`
-	Content    string `json:"content"`
-	SegContent string `json:"seg_content"`
-	CreatedAt  int64  `json:"created_at"`
+	ID          string         `json:"id"`
+	DocumentID  string         `json:"document_id"`
+	Content     string         `json:"content"`
+	SegContent  string         `json:"seg_content"`
+	Position    int64          `json:"position"`
+	Metadata    map[string]any `json:"metadata"`
+	ContentHash string         `json:"content_hash"`
+	DuplicateOf string         `json:"duplicate_of,omitempty"`
+	SimHash     string         `json:"simhash"`
+	Language    string         `json:"language"`
+	Origin      *TextOrigin    `json:"origin,omitempty"`
+	CreatedAt   int64          `json:"created_at"`
+}
+
+func newTextChunk(row dao.TextChunk) TextChunk {
+	return TextChunk{
+		ID:          row.ID,
+		DocumentID:  row.DocumentID,
+		Content:     row.Content,
+		SegContent:  row.SegContent,
+		Position:    row.Position,
+		Metadata:    unmarshalJSONObject(row.Metadata),
+		ContentHash: row.ContentHash,
+		DuplicateOf: row.DuplicateOf,
+		SimHash:     formatSimHash(row.SimHash),
+		Language:    row.Language,
+		Origin:      newTextOrigin(row),
+		CreatedAt:   row.CreatedAt,
+	}
 }
 
 func (c *Controller) NewTextChunk(echoCtx *echo.Context) error {
@@ -431,88 +645,191 @@ func (c *Controller) NewTextChunk(echoCtx *echo.Context) error {
 	if _, err := c.queries.GetDocument(ctx, docId); err != nil {
 		return utils.EchoHandleSQLError(echoCtx, err)
 	}
-	param := &struct {
-		Content string `json:"content"`
-	}{}
+	param := TextInput{}
 	if err := echoCtx.Bind(&param); err != nil {
 		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
 	}
+	dedupePolicy, err := c.getDedupePolicy(echoCtx)
+	if err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	created := false
+	deltas := &suggestionDeltas{}
 	row, err := utils.WithTx(
 		ctx,
 		c.db,
 		nil,
 		func(tx *sql.Tx) (*dao.TextChunk, error) {
-			return c.createTextChunks(ctx, docId, dao.New(tx), param.Content)
+			queries := dao.New(tx)
+			// Append to the end of the document
+			position, err := queries.GetNextTextChunkPosition(ctx, docId)
+			if err != nil {
+				return nil, err
+			}
+			var row *dao.TextChunk
+			row, created, err = c.createTextChunks(ctx, docId, queries, param, position, dedupePolicy, deltas)
+			if err != nil || !created {
+				return row, err
+			}
+			if err := refreshDocumentSimHash(ctx, queries, docId); err != nil {
+				return nil, err
+			}
+			return row, queries.ClearStaleDocumentDuplicateOf(ctx, docId)
 		},
 	)
 	if err != nil {
 		return utils.EchoHandleSQLError(echoCtx, err)
 	}
-	return echoCtx.JSON(http.StatusCreated, TextChunk{
-		ID:         row.ID,
-		DocumentID: row.DocumentID,
-		Content:    row.Content,
-		SegContent: row.SegContent,
-		CreatedAt:  row.CreatedAt,
-	})
+	c.suggester.apply(c.analyzer.Load(), deltas)
+	if !created {
+		// skipped by dedupe policy, return the existing one
+		return echoCtx.JSON(http.StatusOK, newTextChunk(*row))
+	}
+	return echoCtx.JSON(http.StatusCreated, newTextChunk(*row))
 }
 
-func (c *Controller) createTextChunks(ctx context.Context, docId string, queries *dao.Queries, text string) (*dao.TextChunk, error) {
-	newUUID, uuidErr := uuid.NewRandom()
-	if uuidErr != nil {
-		return nil, uuidErr
+// detectLanguage returns the language given by the input or detects it from the content
+func (c *Controller) detectLanguage(input TextInput) string {
+	if input.Language != "" {
+		return strings.ToLower(input.Language)
 	}
-	tokenizedText := c.tokenizer.Tokenize(text)
+	return c.analyzer.Load().DetectLanguage(input.Content)
+}
+
+// segmentText tokenizes the text and appends the normalized tokens by the chain of the language,
+// their transliterations and synonyms, the result is indexed by FTS5
+func (c *Controller) segmentText(text string, language string) string {
+	analyzer := c.analyzer.Load()
+	normalizer := analyzer.NormalizerOf(language)
+	tokenizedText := analyzer.TokenizerOf(language).Tokenize(text)
 	tokenizedNormalizedText := lo.Map(tokenizedText, func(item string, index int) string {
-		normText, err := c.normalizer.Normalize(item)
+		normText, err := normalizer.Normalize(item)
 		if err != nil {
 			logger.WithError(err).Error("Failed to normalize text")
 			return item
 		}
 		return normText
 	})
-	segContent := strings.Join(append(tokenizedText, tokenizedNormalizedText...), " ")
+	transliteratedText := analyzer.Transliterate(tokenizedNormalizedText, language)
+	segContent := strings.Join(slices.Concat(tokenizedText, tokenizedNormalizedText, transliteratedText, synonymTokens(analyzer, tokenizedText)), " ")
 	logger.Debugf("New segment content: %s", segContent)
+	return segContent
+}
+
+// embedText generates the embedding of the text for every embedding model which has an index
+func (c *Controller) embedText(ctx context.Context, text string) (map[string][]float32, error) {
+	embeddings := make(map[string][]float32, len(c.embeddingIndexes))
+	for modelId := range c.embeddingIndexes {
+		model := c.embeddingModels[modelId]
+		vectors, err := model.Embed(ctx, []string{text})
+		if err != nil {
+			return nil, err
+		}
+		if len(vectors) != 1 {
+			return nil, fmt.Errorf("embedding model returned unexpected number of embeddings: %d", len(vectors))
+		}
+		embeddings[modelId] = vectors[0]
+	}
+	return embeddings, nil
+}
+
+// createTextChunks creates a text chunk with the dedupe policy, if the text chunk is skipped,
+// the existing one is returned and created is false. The terms of the text chunk are added to deltas.
+func (c *Controller) createTextChunks(ctx context.Context, docId string, queries *dao.Queries, input TextInput, position int64, dedupePolicy string, deltas *suggestionDeltas) (textChunk *dao.TextChunk, created bool, err error) {
+	contentHash := c.contentHash(input.Content)
+	var original *dao.TextChunk
+	if dedupePolicy != DedupePolicyAllow {
+		if original, err = findOriginalTextChunk(ctx, queries, contentHash); err != nil {
+			return nil, false, err
+		}
+		if original != nil && dedupePolicy == DedupePolicySkip {
+			return original, false, nil
+		}
+	}
+	newUUID, uuidErr := uuid.NewRandom()
+	if uuidErr != nil {
+		return nil, false, uuidErr
+	}
+	metadataJSON, err := marshalJSONObject(input.Metadata)
+	if err != nil {
+		return nil, false, err
+	}
+	language := c.detectLanguage(input)
+	segContent := c.segmentText(input.Content, language)
 	requestParam := dao.NewTextChunkParams{
-		DocumentID: docId,
-		Content:    text,
-		ID:         newUUID.String(),
-		SegContent: segContent,
+		DocumentID:       docId,
+		Content:          input.Content,
+		ID:               newUUID.String(),
+		SegContent:       segContent,
+		Position:         position,
+		Metadata:         metadataJSON,
+		ContentHash:      contentHash,
+		SimHash:          segSimHash(segContent),
+		Language:         language,
+		LanguageDetected: input.Language == "",
+	}
+	if input.origin != nil {
+		requestParam.GeneratedBy = input.origin.Model
+		requestParam.PromptHash = input.origin.PromptHash
+		requestParam.GeneratedAt = input.origin.GeneratedAt
+	}
+	if original != nil {
+		requestParam.DuplicateOf = original.ID
 	}
 	newText, err := queries.NewTextChunk(ctx, requestParam)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	// Add to FTS5 table using generated method
 	if err := queries.InsertTextChunkFTS(ctx, dao.InsertTextChunkFTSParams{
 		ID:         newText.ID,
 		SegContent: newText.SegContent,
 	}); err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	for modelId, graph := range c.embeddingIndexes {
-		model := c.embeddingModels[modelId]
-		embeddings, err := model.Embed(ctx, []string{text})
-		if err != nil {
-			return nil, err
+	deltas.addSegContent(newText.SegContent, 1)
+	var embeddings map[string][]float32
+	if original != nil {
+		// Reuse the embeddings of the original text chunk instead of calling models again
+		if embeddings, err = c.getStoredEmbeddings(ctx, queries, original.ID); err != nil {
+			return nil, false, err
 		}
-		if len(embeddings) != 1 {
-			return nil, fmt.Errorf("embedding model returned unexpected number of embeddings: %d", len(embeddings))
+	}
+	if len(embeddings) != len(c.embeddingIndexes) {
+		if embeddings, err = c.embedText(ctx, input.Content); err != nil {
+			return nil, false, err
 		}
-		graph.Add(hnsw.Node[string]{
+	}
+	for modelId, embedding := range embeddings {
+		c.embeddingIndexes[modelId].Add(hnsw.Node[string]{
 			Key:   newText.ID,
-			Value: embeddings[0],
+			Value: embedding,
 		})
 		err = queries.NewTextEmbedding(ctx, dao.NewTextEmbeddingParams{
 			TextChunkID: newText.ID,
 			ModelID:     modelId,
-			Vector:      utils.ConvertFloat32ArrayToBytes(embeddings[0]),
+			Vector:      utils.ConvertFloat32ArrayToBytes(embedding),
 		})
 		if err != nil {
-			return nil, err
+			return nil, false, err
+		}
+	}
+	return &newText, true, nil
+}
+
+// getStoredEmbeddings loads the embeddings of a text chunk for the embedding models which have an index
+func (c *Controller) getStoredEmbeddings(ctx context.Context, queries *dao.Queries, textId string) (map[string][]float32, error) {
+	rows, err := queries.ListTextEmbeddingsByTextChunkID(ctx, textId)
+	if err != nil {
+		return nil, err
+	}
+	embeddings := make(map[string][]float32, len(rows))
+	for _, row := range rows {
+		if _, ok := c.embeddingIndexes[row.ModelID]; ok {
+			embeddings[row.ModelID] = utils.ConvertBytesToFloat32Array(row.Vector)
 		}
 	}
-	return &newText, nil
+	return embeddings, nil
 }
 
 func (c *Controller) GetTextChunk(echoCtx *echo.Context) error {
@@ -522,13 +839,157 @@ func (c *Controller) GetTextChunk(echoCtx *echo.Context) error {
 	if err != nil {
 		return utils.EchoHandleSQLError(echoCtx, err)
 	}
-	return echoCtx.JSON(http.StatusOK, TextChunk{
-		ID:         row.ID,
+	return echoCtx.JSON(http.StatusOK, newTextChunk(row))
+}
+
+type TextChunkContext struct {
+	TextChunkID string      `json:"text_chunk_id" jsonschema:"the ID of the text chunk in the center"`
+	DocumentID  string      `json:"document_id" jsonschema:"the ID of the document"`
+	Texts       []TextChunk `json:"texts" jsonschema:"the text chunks around the center one (included) ordered by position"`
+}
+
+// GetTextChunkContext returns the text chunk with its preceding and following text chunks in the same document
+func (c *Controller) GetTextChunkContext(echoCtx *echo.Context) error {
+	ctx := echoCtx.Request().Context()
+	textId := echoCtx.Param("text_id")
+	n, err := strconv.Atoi(echoCtx.QueryParamOr("n", "1"))
+	if err != nil || n < 0 {
+		return utils.EchoHandleGenericError(echoCtx, fmt.Errorf("invalid parameter 'n': %s", echoCtx.QueryParam("n")), http.StatusBadRequest)
+	}
+	// before & after override n for each direction
+	before, err := strconv.Atoi(echoCtx.QueryParamOr("before", strconv.Itoa(n)))
+	if err != nil || before < 0 {
+		return utils.EchoHandleGenericError(echoCtx, fmt.Errorf("invalid parameter 'before': %s", echoCtx.QueryParam("before")), http.StatusBadRequest)
+	}
+	after, err := strconv.Atoi(echoCtx.QueryParamOr("after", strconv.Itoa(n)))
+	if err != nil || after < 0 {
+		return utils.EchoHandleGenericError(echoCtx, fmt.Errorf("invalid parameter 'after': %s", echoCtx.QueryParam("after")), http.StatusBadRequest)
+	}
+	row, err := c.queries.GetTextChunk(ctx, textId)
+	if err != nil {
+		return utils.EchoHandleSQLError(echoCtx, err)
+	}
+	precedingRows, err := c.queries.ListTextChunksBeforePosition(ctx, dao.ListTextChunksBeforePositionParams{
 		DocumentID: row.DocumentID,
-		Content:    row.Content,
-		SegContent: row.SegContent,
-		CreatedAt:  row.CreatedAt,
+		Position:   row.Position,
+		Limit:      int64(before),
 	})
+	if err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	followingRows, err := c.queries.ListTextChunksAfterPosition(ctx, dao.ListTextChunksAfterPositionParams{
+		DocumentID: row.DocumentID,
+		Position:   row.Position,
+		Limit:      int64(after),
+	})
+	if err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	texts := make([]TextChunk, 0, len(precedingRows)+1+len(followingRows))
+	// preceding rows are in descending order
+	for i := len(precedingRows) - 1; i >= 0; i-- {
+		texts = append(texts, newTextChunk(precedingRows[i]))
+	}
+	texts = append(texts, newTextChunk(row))
+	for _, item := range followingRows {
+		texts = append(texts, newTextChunk(item))
+	}
+	return utils.EchoJsonResponse(echoCtx, TextChunkContext{
+		TextChunkID: row.ID,
+		DocumentID:  row.DocumentID,
+		Texts:       texts,
+	}, http.StatusOK)
+}
+
+// UpdateTextChunk replaces the content of a text chunk in place, so the text chunk ID stays the same
+func (c *Controller) UpdateTextChunk(echoCtx *echo.Context) error {
+	ctx := echoCtx.Request().Context()
+	textId := echoCtx.Param("text_id")
+	param := TextInput{}
+	if err := echoCtx.Bind(&param); err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	existing, err := c.queries.GetTextChunk(ctx, textId)
+	if err != nil {
+		return utils.EchoHandleSQLError(echoCtx, err)
+	}
+	// Keep the existing metadata if it's not given
+	metadataJSON := existing.Metadata
+	if param.Metadata != nil {
+		if metadataJSON, err = marshalJSONObject(param.Metadata); err != nil {
+			return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+		}
+	}
+	// Embed before opening the transaction, the ANN indexes are only touched after commit
+	embeddings, err := c.embedText(ctx, param.Content)
+	if err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	row, err := utils.WithTx(
+		ctx,
+		c.db,
+		nil,
+		func(tx *sql.Tx) (*dao.TextChunk, error) {
+			queries := dao.New(tx)
+			language := c.detectLanguage(param)
+			segContent := c.segmentText(param.Content, language)
+			updated, err := queries.UpdateTextChunk(ctx, dao.UpdateTextChunkParams{
+				Content:          param.Content,
+				SegContent:       segContent,
+				Metadata:         metadataJSON,
+				ContentHash:      c.contentHash(param.Content),
+				SimHash:          segSimHash(segContent),
+				Language:         language,
+				LanguageDetected: param.Language == "",
+				ID:               textId,
+			})
+			if err != nil {
+				return nil, err
+			}
+			// Text chunks linked to the old content are no longer duplicates of this one
+			if err := queries.ClearDuplicateOfByTextChunkID(ctx, updated.ID); err != nil {
+				return nil, err
+			}
+			if err := refreshDocumentSimHash(ctx, queries, updated.DocumentID); err != nil {
+				return nil, err
+			}
+			if err := queries.ClearStaleDocumentDuplicateOf(ctx, updated.DocumentID); err != nil {
+				return nil, err
+			}
+			if err := queries.UpdateTextChunkFTS(ctx, dao.UpdateTextChunkFTSParams{
+				SegContent: updated.SegContent,
+				ID:         updated.ID,
+			}); err != nil {
+				return nil, err
+			}
+			if err := queries.DeleteTextEmbeddingsByTextChunkID(ctx, updated.ID); err != nil {
+				return nil, err
+			}
+			for modelId, embedding := range embeddings {
+				if err := queries.NewTextEmbedding(ctx, dao.NewTextEmbeddingParams{
+					TextChunkID: updated.ID,
+					ModelID:     modelId,
+					Vector:      utils.ConvertFloat32ArrayToBytes(embedding),
+				}); err != nil {
+					return nil, err
+				}
+			}
+			return &updated, nil
+		},
+	)
+	if err != nil {
+		return utils.EchoHandleSQLError(echoCtx, err)
+	}
+	c.suggester.addSegContent(existing.SegContent, -1)
+	c.suggester.addSegContent(row.SegContent, 1)
+	// Adding a node with an existing key replaces it in the graph
+	for modelId, embedding := range embeddings {
+		c.embeddingIndexes[modelId].Add(hnsw.Node[string]{
+			Key:   row.ID,
+			Value: embedding,
+		})
+	}
+	return echoCtx.JSON(http.StatusOK, newTextChunk(*row))
 }
 
 func (c *Controller) deleteTextChunkFromIndex(id string) {
@@ -539,62 +1000,101 @@ func (c *Controller) deleteTextChunkFromIndex(id string) {
 	}
 }
 
+// deleteTextChunkInternal deletes a text chunk with its embeddings and FTS entry,
+// the SimHash of the document should be refreshed by the caller and deltas applied after commit
+func (c *Controller) deleteTextChunkInternal(ctx context.Context, queries *dao.Queries, textChunk dao.TextChunk, deltas *suggestionDeltas) error {
+	// Delete text embeddings
+	if err := queries.DeleteTextEmbeddingsByTextChunkID(ctx, textChunk.ID); err != nil {
+		return err
+	}
+	// Delete FTS entry
+	if err := queries.DeleteTextChunkFTSByID(ctx, textChunk.ID); err != nil {
+		return err
+	}
+	if err := queries.ClearDuplicateOfByTextChunkID(ctx, textChunk.ID); err != nil {
+		return err
+	}
+	// Delete text chunk
+	if err := queries.DeleteTextChunk(ctx, textChunk.ID); err != nil {
+		return err
+	}
+	c.deleteTextChunkFromIndex(textChunk.ID)
+	deltas.addSegContent(textChunk.SegContent, -1)
+	return nil
+}
+
 func (c *Controller) DeleteTextChunk(echoCtx *echo.Context) error {
 	ctx := echoCtx.Request().Context()
 	textId := echoCtx.Param("text_id")
+	deltas := &suggestionDeltas{}
 	_, err := utils.WithTx(
 		ctx,
 		c.db,
 		nil,
 		func(tx *sql.Tx) (any, error) {
 			queries := dao.New(tx)
-			// Delete text embeddings
-			if err := queries.DeleteTextEmbeddingsByTextChunkID(ctx, textId); err != nil {
+			textChunk, err := queries.GetTextChunk(ctx, textId)
+			if err != nil {
 				return nil, err
 			}
-			// Delete FTS entry
-			if err := queries.DeleteTextChunkFTSByID(ctx, textId); err != nil {
+			if err := c.deleteTextChunkInternal(ctx, queries, textChunk, deltas); err != nil {
 				return nil, err
 			}
-			// Delete text chunk
-			if err := queries.DeleteTextChunk(ctx, textId); err != nil {
+			if err := refreshDocumentSimHash(ctx, queries, textChunk.DocumentID); err != nil {
 				return nil, err
 			}
-			c.deleteTextChunkFromIndex(textId)
-			return nil, nil
+			return nil, queries.ClearStaleDocumentDuplicateOf(ctx, textChunk.DocumentID)
 		},
 	)
 	if err != nil {
-		return utils.EchoHandleInternalError(echoCtx, err)
+		return utils.EchoHandleSQLError(echoCtx, err)
 	}
+	c.suggester.apply(c.analyzer.Load(), deltas)
 	return echoCtx.JSON(http.StatusOK, map[string]string{"status": "ok"})
 }
 
 type SearchResultItem struct {
-	TextChunkID string  `json:"text_chunk_id" jsonschema:"the ID of the text chunk"`
-	Content     string  `json:"content" jsonschema:"the content of the text chunk"`
-	DocumentID  string  `json:"document_id" jsonschema:"the ID of the document"`
-	Title       string  `json:"title" jsonschema:"the title of the document"`
-	Description string  `json:"description" jsonschema:"the description of the document"`
-	Score       float64 `json:"score" jsonschema:"the score score of the search result"`
+	TextChunkID string         `json:"text_chunk_id" jsonschema:"the ID of the text chunk"`
+	Content     string         `json:"content" jsonschema:"the content of the text chunk"`
+	DocumentID  string         `json:"document_id" jsonschema:"the ID of the document"`
+	Position    int64          `json:"position" jsonschema:"the position of the text chunk in the document"`
+	Metadata    map[string]any `json:"metadata,omitempty" jsonschema:"the metadata of the text chunk, e.g. page number or section"`
+	Title       string         `json:"title" jsonschema:"the title of the document"`
+	Description string         `json:"description" jsonschema:"the description of the document"`
+	Language    string         `json:"language,omitempty" jsonschema:"the detected language of the text chunk, e.g. zh, ja or en"`
+	GeneratedBy string         `json:"generated_by,omitempty" jsonschema:"the ID of the generation model if the text chunk is generated instead of taken from the document"`
+	Score       float64        `json:"score" jsonschema:"the score score of the search result"`
+	contentHash string
 }
 
-func (c *Controller) searchWithBM25(ctx context.Context, query string, nDoc int) ([]SearchResultItem, error) {
+func (c *Controller) searchWithBM25(ctx context.Context, query string, nDoc int, filter *searchFilter) ([]SearchResultItem, error) {
+	limit := nDoc
+	if filter.collapseDuplicates {
+		limit = nDoc * filterOversampling
+	}
+	args := []any{query}
+	args = append(args, filter.args...)
+	args = append(args, limit)
 	rows, err := c.db.QueryContext(ctx, `
 		SELECT 
 			tc.id,
 			tc.content,
 			tc.document_id,
+			tc.position,
+			tc.metadata,
+			tc.content_hash,
+			tc.language,
+			tc.generated_by,
 			d.title,
 			d.description,
 			fts.rank
 		FROM text_chunk_fts fts
 		JOIN text_chunk tc ON tc.id = fts.id
 		JOIN document d ON d.id = tc.document_id
-		WHERE fts.seg_content MATCH ?
+		WHERE fts.seg_content MATCH ?`+filter.where()+`
 		ORDER BY fts.rank
 		LIMIT ?
-	`, query, nDoc)
+	`, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -608,29 +1108,56 @@ func (c *Controller) searchWithBM25(ctx context.Context, query string, nDoc int)
 	results := make([]SearchResultItem, 0)
 	for rows.Next() {
 		var item SearchResultItem
-		if err := rows.Scan(&item.TextChunkID, &item.Content, &item.DocumentID, &item.Title, &item.Description, &item.Score); err != nil {
+		var metadata string
+		if err := rows.Scan(&item.TextChunkID, &item.Content, &item.DocumentID, &item.Position, &metadata, &item.contentHash, &item.Language, &item.GeneratedBy, &item.Title, &item.Description, &item.Score); err != nil {
 			return nil, err
 		}
+		item.Metadata = unmarshalJSONObject(metadata)
 		results = append(results, item)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	return results, nil
+	return filter.postFilter(results, nDoc), nil
 }
 
-func (c *Controller) searchWithEmbeddingModel(ctx context.Context, modelId, query string, nDoc int) ([]SearchResultItem, error) {
-	index := c.embeddingIndexes[modelId]
-	model := c.embeddingModels[modelId]
-	queryEmbedding, err := model.Embed(ctx, []string{query})
+type queryEmbeddingKey struct {
+	modelId string
+	text    string
+}
+
+// queryEmbeddings caches the embeddings of query texts in a request, so retrieval and facets embed a query once
+type queryEmbeddings map[queryEmbeddingKey][]float32
+
+// embedQuery returns the embedding of the query text by the model, from the cache if it's embedded already
+func (c *Controller) embedQuery(ctx context.Context, embeddings queryEmbeddings, modelId string, text string) ([]float32, error) {
+	key := queryEmbeddingKey{modelId: modelId, text: text}
+	if embedding, ok := embeddings[key]; ok {
+		return embedding, nil
+	}
+	queryEmbedding, err := c.embeddingModels[modelId].Embed(ctx, []string{text})
 	if err != nil {
 		return nil, err
 	}
 	if len(queryEmbedding) != 1 {
 		return nil, fmt.Errorf("embedding model returned unexpected number of embeddings: %d", len(queryEmbedding))
 	}
-	searchResult := index.SearchWithDistance(queryEmbedding[0], nDoc)
+	embeddings[key] = queryEmbedding[0]
+	return queryEmbedding[0], nil
+}
+
+func (c *Controller) searchWithEmbeddingModel(ctx context.Context, embeddings queryEmbeddings, modelId, query string, nDoc int, filter *searchFilter) ([]SearchResultItem, error) {
+	index := c.embeddingIndexes[modelId]
+	queryEmbedding, err := c.embedQuery(ctx, embeddings, modelId, query)
+	if err != nil {
+		return nil, err
+	}
+	nCandidates := nDoc
+	if filter.oversampled() {
+		nCandidates = nDoc * filterOversampling
+	}
+	searchResult := index.SearchWithDistance(queryEmbedding, nCandidates)
 	ids := lo.Map(searchResult, func(item hnsw.SearchResult[string], index int) any {
 		return item.Key
 	})
@@ -643,19 +1170,25 @@ func (c *Controller) searchWithEmbeddingModel(ctx context.Context, modelId, quer
 				tc.id,
 				tc.content,
 				tc.document_id,
+				tc.position,
+				tc.metadata,
+				tc.content_hash,
+				tc.language,
+				tc.generated_by,
 				d.title,
 				d.description
 			FROM text_chunk tc
 			JOIN document d ON d.id = tc.document_id
-			WHERE tc.id IN (%s)
+			WHERE tc.id IN (%s)%s
 			`, strings.Join(
 			lo.Map(ids, func(item any, index int) string {
 				return "?"
 			}),
 			",",
 		),
+		filter.where(),
 	)
-	rows, err := c.db.QueryContext(ctx, sqlStat, ids...)
+	rows, err := c.db.QueryContext(ctx, sqlStat, append(ids, filter.args...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -668,9 +1201,11 @@ func (c *Controller) searchWithEmbeddingModel(ctx context.Context, modelId, quer
 	results := make([]SearchResultItem, 0)
 	for rows.Next() {
 		var item SearchResultItem
-		if err := rows.Scan(&item.TextChunkID, &item.Content, &item.DocumentID, &item.Title, &item.Description); err != nil {
+		var metadata string
+		if err := rows.Scan(&item.TextChunkID, &item.Content, &item.DocumentID, &item.Position, &metadata, &item.contentHash, &item.Language, &item.GeneratedBy, &item.Title, &item.Description); err != nil {
 			return nil, err
 		}
+		item.Metadata = unmarshalJSONObject(metadata)
 		item.Score = -float64(distanceMap[item.TextChunkID])
 		results = append(results, item)
 	}
@@ -680,11 +1215,19 @@ func (c *Controller) searchWithEmbeddingModel(ctx context.Context, modelId, quer
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	return results, nil
+	return filter.postFilter(results, nDoc), nil
 }
 
 type SearchResponse struct {
 	Results []SearchResultItem `json:"results"`
+	// QueryLanguage is the detected language of the query when it's filtered by `lang=auto`
+	QueryLanguage string `json:"query_language,omitempty"`
+	// Suggestion is the "did you mean" query with misspelled terms corrected, BM25 only
+	Suggestion string `json:"suggestion,omitempty"`
+	// Rewrite is the variants of the query searched besides it when it's rewritten by `rewrite=<model_id>`
+	Rewrite *QueryRewrite `json:"rewrite,omitempty"`
+	// Facets are the counts of matched documents requested by `facets=<key>,<key>` and `histogram=<interval>`
+	Facets *Facets `json:"facets,omitempty"`
 }
 
 func (c *Controller) Search(echoCtx *echo.Context) error {
@@ -699,25 +1242,110 @@ func (c *Controller) Search(echoCtx *echo.Context) error {
 	if err != nil || nDoc <= 0 {
 		nDoc = 10
 	}
-	var results []SearchResultItem
-	if strings.ToLower(modelId) == "bm25" {
-		results, err = c.searchWithBM25(ctx, query, nDoc)
-		if err != nil {
+	params := echoCtx.QueryParams()
+	queryLanguage := ""
+	if lo.Contains(parseLanguages(params), "auto") {
+		// Filter by the language of the query, no language filter if it's not detected
+		queryLanguage = c.analyzer.Load().DetectLanguage(query)
+		params = maps.Clone(params)
+		params["lang"] = lo.Filter(parseLanguages(params), func(item string, index int) bool { return item != "auto" })
+		if queryLanguage != "" {
+			params["lang"] = append(params["lang"], queryLanguage)
+		}
+	}
+	filter, err := parseSearchFilter(params)
+	if err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	parsed, err := parseQuery(query)
+	if err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	if parsed.text == "" {
+		return utils.EchoHandleGenericError(echoCtx, invalidQueryError("at least one search term is required besides field filters"), http.StatusBadRequest)
+	}
+	filter.merge(parsed.filter)
+	if err := c.validateRetrieval(modelId); err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	rerankModel, rerankK, err := c.parseRerankParams(echoCtx)
+	if err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	facetParams, err := c.parseFacetParams(echoCtx)
+	if err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	rankingProfile, err := c.parseRankingProfile(echoCtx)
+	if err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	// `rewrite=<model_id>` expands the query by a generation model
+	var rewriteModel models.GenerationModel
+	rewriteModelId := echoCtx.QueryParam("rewrite")
+	if rewriteModelId != "" {
+		if _, rewriteModel, err = c.getGenerationModel(rewriteModelId); err != nil {
+			return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+		}
+	}
+	bm25Query := parsed.fts5
+	suggestion := ""
+	// `fuzzy=false` disables correcting misspelled terms
+	if lo.Contains([]string{RetrievalBM25, RetrievalHybrid}, strings.ToLower(modelId)) {
+		if fuzzy, err := strconv.ParseBool(echoCtx.QueryParamOr("fuzzy", "true")); err != nil || fuzzy {
+			var corrections map[string]string
+			if bm25Query, corrections, err = c.correctQuery(ctx, bm25Query); err != nil {
+				return utils.EchoHandleInternalError(echoCtx, err)
+			}
+			if len(corrections) > 0 {
+				suggestion = parsed.replaceWords(corrections)
+			}
+		}
+	}
+	var rewrite *QueryRewrite
+	if rewriteModel != nil {
+		if rewrite, err = c.rewriteQuery(ctx, rewriteModelId, rewriteModel, parsed.text); err != nil {
+			return utils.EchoHandleInternalError(echoCtx, err)
+		}
+	}
+	// Top-K candidates are retrieved for reranking and ranking, then cut to n
+	nCandidates := max(nDoc, rerankK)
+	if rankingProfile != nil {
+		nCandidates = max(nCandidates, rankingProfile.Candidates)
+	}
+	// The embeddings of the query are shared by retrieval and facets
+	embeddings := make(queryEmbeddings)
+	results, err := c.retrieveRewritten(ctx, embeddings, modelId, bm25Query, parsed.text, nCandidates, filter, rewrite)
+	if err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	if rerankModel != nil {
+		if results, err = rerank(ctx, rerankModel, parsed.text, results); err != nil {
 			return utils.EchoHandleInternalError(echoCtx, err)
 		}
-	} else {
-		// Check if model exists
-		_, okModel := c.embeddingModels[modelId]
-		_, okAnnIndex := c.embeddingIndexes[modelId]
-		if !okModel || !okAnnIndex {
-			return utils.EchoHandleGenericError(echoCtx, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("model '%s' not found", modelId)), http.StatusBadRequest)
+	}
+	if rankingProfile != nil {
+		if results, err = c.rank(ctx, rankingProfile, results, time.Now()); err != nil {
+			return utils.EchoHandleInternalError(echoCtx, err)
 		}
-		results, err = c.searchWithEmbeddingModel(ctx, modelId, query, nDoc)
-		if err != nil {
+	}
+	results = lo.Slice(results, 0, nDoc)
+	var facets *Facets
+	if facetParams != nil {
+		// Facets count the matches of the query, not the variants of the rewrite
+		if facets, err = c.computeFacets(ctx, embeddings, modelId, bm25Query, parsed.text, filter, facetParams); err != nil {
 			return utils.EchoHandleInternalError(echoCtx, err)
 		}
 	}
-	return utils.EchoJsonResponse(echoCtx, SearchResponse{Results: results}, http.StatusOK)
+	if len(results) > 0 {
+		// Queries with results are suggested by autocomplete
+		if err := c.queries.RecordSearchQuery(ctx, query); err != nil {
+			logger.WithError(err).Error("Failed to record the search query")
+		} else {
+			c.suggester.addQuery(query)
+		}
+	}
+	return utils.EchoJsonResponse(echoCtx, SearchResponse{Results: results, QueryLanguage: queryLanguage, Suggestion: suggestion, Rewrite: rewrite, Facets: facets}, http.StatusOK)
 }
 
 func (c *Controller) ListEmbeddingModels(echoCtx *echo.Context) error {
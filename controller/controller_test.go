@@ -2,16 +2,31 @@ package controller
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"maps"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/google/jsonschema-go/jsonschema"
 	"github.com/labstack/echo/v5"
+	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tsingjyujing/vestigo/controller/dao"
 	"github.com/tsingjyujing/vestigo/models"
+	"github.com/tsingjyujing/vestigo/text"
 	_ "modernc.org/sqlite"
 )
 
@@ -26,12 +41,47 @@ func setupTestController(t *testing.T) (*Controller, *sql.DB) {
 	require.NoError(t, err, "Failed to create tables")
 
 	// Create controller with empty embedding models (not needed for basic tests)
-	controller, err := NewController(db, make(map[string]models.BaseEmbeddingModel), t.TempDir(), nil)
+	controller, err := NewController(db, make(map[string]models.BaseEmbeddingModel), t.TempDir(), nil, Options{})
 	require.NoError(t, err, "Failed to create controller")
 
 	return controller, db
 }
 
+// postDocument creates a document by the API
+func postDocument(t *testing.T, c *Controller, params NewDocumentParams) {
+	t.Helper()
+	reqBody, err := json.Marshal(params)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/doc/", bytes.NewReader(reqBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	require.NoError(t, c.NewDocument(echo.New().NewContext(req, rec)))
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+}
+
+// searchBM25Status searches by BM25 with the query string, e.g. q=taxes&n=1, and returns the status code with the response
+func searchBM25Status(t *testing.T, c *Controller, query string) (int, SearchResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/search/bm25?"+query, nil)
+	rec := httptest.NewRecorder()
+	echoCtx := echo.New().NewContext(req, rec)
+	echoCtx.SetPathValues(echo.PathValues{{Name: "model_id", Value: "bm25"}})
+	require.NoError(t, c.Search(echoCtx))
+	var response SearchResponse
+	if rec.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	}
+	return rec.Code, response
+}
+
+// searchBM25 searches by BM25 with the query string, the search should succeed
+func searchBM25(t *testing.T, c *Controller, query string) SearchResponse {
+	t.Helper()
+	code, response := searchBM25Status(t, c, query)
+	require.Equal(t, http.StatusOK, code)
+	return response
+}
+
 // TestDocumentCRUDAndSearch tests creating, searching, and deleting documents
 func TestDocumentCRUDAndSearch(t *testing.T) {
 	controller, db := setupTestController(t)
@@ -107,7 +157,7 @@ func TestDocumentCRUDAndSearch(t *testing.T) {
 				Title:       doc.Title,
 				Description: doc.Description,
 				Data:        doc.Data,
-				Texts:       doc.Texts,
+				Texts:       plainTexts(doc.Texts...),
 			})
 			require.NoError(t, err)
 
@@ -262,6 +312,11 @@ func TestDocumentCRUDAndSearch(t *testing.T) {
 	})
 }
 
+// plainTexts converts strings to text inputs without metadata
+func plainTexts(texts ...string) []TextInput {
+	return lo.Map(texts, func(item string, index int) TextInput { return TextInput{Content: item} })
+}
+
 // Helper function to check if text contains a substring
 func containsText(text, substr string) bool {
 	return len(text) > 0 && len(substr) > 0 && (text == substr || bytes.Contains([]byte(text), []byte(substr)))
@@ -281,7 +336,7 @@ func TestSearchWithLimitParameter(t *testing.T) {
 			Title:       "测试文档",
 			Description: "测试限制参数",
 			Data:        map[string]interface{}{"index": i},
-			Texts:       []string{"这是一个包含测试关键词的文档内容"},
+			Texts:       plainTexts("这是一个包含测试关键词的文档内容"),
 		})
 		require.NoError(t, err)
 
@@ -433,7 +488,7 @@ func TestDocumentOverwrite(t *testing.T) {
 		Title:       "初始文档",
 		Description: "这是原始文档",
 		Data:        map[string]interface{}{"version": 1},
-		Texts:       []string{"初始内容"},
+		Texts:       plainTexts("初始内容"),
 	}
 
 	reqBody, err := json.Marshal(initialDoc)
@@ -470,7 +525,7 @@ func TestDocumentOverwrite(t *testing.T) {
 			Title:       "更新后的文档",
 			Description: "这是更新后的文档",
 			Data:        map[string]interface{}{"version": 2, "updated": true},
-			Texts:       []string{"更新后的内容", "新增的内容"},
+			Texts:       plainTexts("更新后的内容", "新增的内容"),
 		}
 
 		reqBody, err := json.Marshal(updatedDoc)
@@ -516,7 +571,7 @@ func TestDocumentOverwrite(t *testing.T) {
 			Title:       "第三版文档",
 			Description: "使用overwrite=1更新",
 			Data:        map[string]interface{}{"version": 3},
-			Texts:       []string{"第三版内容"},
+			Texts:       plainTexts("第三版内容"),
 		}
 
 		reqBody, err := json.Marshal(updatedDoc)
@@ -563,7 +618,7 @@ func TestDocumentWithEmptyData(t *testing.T) {
 		Title:       "测试空数据",
 		Description: "测试data字段为空时的默认值",
 		Data:        nil,
-		Texts:       []string{"这是一个测试文本"},
+		Texts:       plainTexts("这是一个测试文本"),
 	})
 	require.NoError(t, err)
 
@@ -596,3 +651,2083 @@ func TestDocumentWithEmptyData(t *testing.T) {
 	require.True(t, ok, "data should be a JSON object")
 	assert.Equal(t, 0, len(dataMap), "Data field should default to empty JSON object")
 }
+
+// TestUpdateTextChunk tests editing the content of a text chunk in place
+func TestUpdateTextChunk(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+
+	e := echo.New()
+
+	postDocument(t, controller, NewDocumentParams{
+		ID:          "doc-update-text",
+		Title:       "更新文本",
+		Description: "测试修改文本块内容",
+		Texts:       plainTexts("星际贸易协定促进了经济交流"),
+	})
+
+	chunks, err := controller.queries.ListTextChunksByDocumentID(t.Context(), "doc-update-text")
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	textId := chunks[0].ID
+
+	t.Run("UpdateContent", func(t *testing.T) {
+		reqBody, err := json.Marshal(map[string]string{"content": "和平维护机制解决了争端"})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/text/"+textId, bytes.NewReader(reqBody))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPathValues([]echo.PathValue{{Name: "text_id", Value: textId}})
+
+		require.NoError(t, controller.UpdateTextChunk(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var tc TextChunk
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tc))
+		assert.Equal(t, textId, tc.ID, "Text chunk ID should not change")
+		assert.Equal(t, "和平维护机制解决了争端", tc.Content)
+	})
+
+	t.Run("SearchReflectsUpdate", func(t *testing.T) {
+		results := searchBM25(t, controller, "q=和平").Results
+		require.Len(t, results, 1)
+		assert.Equal(t, textId, results[0].TextChunkID)
+		assert.Empty(t, searchBM25(t, controller, "q=贸易").Results, "Old content should no longer be searchable")
+	})
+
+	t.Run("UpdateMissingTextChunk", func(t *testing.T) {
+		reqBody, err := json.Marshal(map[string]string{"content": "不存在"})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/text/missing", bytes.NewReader(reqBody))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPathValues([]echo.PathValue{{Name: "text_id", Value: "missing"}})
+
+		require.NoError(t, controller.UpdateTextChunk(c))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+// TestTextChunkOrderAndContext tests the persisted chunk position and neighbor context retrieval
+func TestTextChunkOrderAndContext(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+
+	e := echo.New()
+
+	texts := []string{"第一段", "第二段", "第三段", "第四段", "第五段", "第六段", "第七段", "第八段"}
+	postDocument(t, controller, NewDocumentParams{
+		ID:    "doc-ordered",
+		Title: "有序文档",
+		Texts: plainTexts(texts[:7]...),
+	})
+
+	// Append one more text chunk, it should be placed at the end
+	reqBody, err := json.Marshal(map[string]string{"content": texts[7]})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/doc/doc-ordered/text", bytes.NewReader(reqBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPathValues([]echo.PathValue{{Name: "doc_id", Value: "doc-ordered"}})
+	require.NoError(t, controller.NewTextChunk(c))
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	var document DocumentWithChunks
+	t.Run("DocumentTextsInOrder", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/doc/doc-ordered?with_texts=true", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPathValues([]echo.PathValue{{Name: "doc_id", Value: "doc-ordered"}})
+		require.NoError(t, controller.GetDocument(c))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &document))
+		require.Len(t, document.Texts, len(texts))
+		for i, tc := range document.Texts {
+			assert.Equal(t, texts[i], tc.Content)
+			assert.Equal(t, int64(i), tc.Position)
+		}
+	})
+
+	getContext := func(textId, query string) (int, TextChunkContext) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/text/"+textId+"/context"+query, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPathValues([]echo.PathValue{{Name: "text_id", Value: textId}})
+		require.NoError(t, controller.GetTextChunkContext(c))
+		var result TextChunkContext
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+		}
+		return rec.Code, result
+	}
+	contents := func(result TextChunkContext) []string {
+		return lo.Map(result.Texts, func(item TextChunk, index int) string { return item.Content })
+	}
+
+	t.Run("ContextInTheMiddle", func(t *testing.T) {
+		code, result := getContext(document.Texts[4].ID, "?n=2")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, document.Texts[4].ID, result.TextChunkID)
+		assert.Equal(t, "doc-ordered", result.DocumentID)
+		assert.Equal(t, texts[2:7], contents(result))
+	})
+
+	t.Run("ContextAtTheBeginning", func(t *testing.T) {
+		code, result := getContext(document.Texts[0].ID, "?n=2")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, texts[0:3], contents(result))
+	})
+
+	t.Run("ContextWithBeforeAndAfter", func(t *testing.T) {
+		code, result := getContext(document.Texts[6].ID, "?before=3&after=0")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, texts[3:7], contents(result))
+	})
+
+	t.Run("ContextWithInvalidParameter", func(t *testing.T) {
+		code, _ := getContext(document.Texts[0].ID, "?n=-1")
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("ContextOfMissingTextChunk", func(t *testing.T) {
+		code, _ := getContext("missing", "")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+}
+
+// TestTextChunkMetadata tests storing, returning and filtering metadata of text chunks
+func TestTextChunkMetadata(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+
+	e := echo.New()
+
+	// Texts can be mixed with plain strings and objects with metadata
+	reqBody := `{
+		"id": "doc-metadata",
+		"title": "联邦宪法",
+		"texts": [
+			{"content": "联邦宪法第一章总则", "metadata": {"page": 1, "heading": "总则"}},
+			{"content": "联邦宪法第二章公民权利", "metadata": {"page": 2, "heading": "公民权利"}},
+			"联邦宪法附录"
+		]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/doc/", bytes.NewReader([]byte(reqBody)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	require.NoError(t, controller.NewDocument(e.NewContext(req, rec)))
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	t.Run("AppendTextWithMetadata", func(t *testing.T) {
+		reqBody := `{"content": "联邦宪法第三章修正案", "metadata": {"page": 3, "heading": "修正案"}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/doc/doc-metadata/text", bytes.NewReader([]byte(reqBody)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPathValues([]echo.PathValue{{Name: "doc_id", Value: "doc-metadata"}})
+		require.NoError(t, controller.NewTextChunk(c))
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		var tc TextChunk
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tc))
+		assert.Equal(t, map[string]any{"page": float64(3), "heading": "修正案"}, tc.Metadata)
+	})
+
+	t.Run("GetDocumentWithMetadata", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/doc/doc-metadata?with_texts=true", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPathValues([]echo.PathValue{{Name: "doc_id", Value: "doc-metadata"}})
+		require.NoError(t, controller.GetDocument(c))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var document DocumentWithChunks
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &document))
+		require.Len(t, document.Texts, 4)
+		assert.Equal(t, map[string]any{"page": float64(1), "heading": "总则"}, document.Texts[0].Metadata)
+		assert.Equal(t, map[string]any{}, document.Texts[2].Metadata, "Plain text should have empty metadata")
+	})
+
+	t.Run("SearchReturnsMetadata", func(t *testing.T) {
+		results := searchBM25(t, controller, "q=宪法").Results
+		require.Len(t, results, 4)
+		pages := lo.FilterMap(results, func(item SearchResultItem, index int) (any, bool) {
+			page, ok := item.Metadata["page"]
+			return page, ok
+		})
+		assert.ElementsMatch(t, []any{float64(1), float64(2), float64(3)}, pages)
+	})
+
+	t.Run("SearchFilteredByMetadata", func(t *testing.T) {
+		results := searchBM25(t, controller, "q=宪法&metadata.page=2").Results
+		require.Len(t, results, 1)
+		assert.Equal(t, "联邦宪法第二章公民权利", results[0].Content)
+		assert.Equal(t, "公民权利", results[0].Metadata["heading"])
+
+		results = searchBM25(t, controller, "q=宪法&metadata.heading=总则&metadata.heading=修正案").Results
+		assert.Len(t, results, 2)
+
+		results = searchBM25(t, controller, "q=宪法&metadata.page=1&metadata.heading=修正案").Results
+		assert.Empty(t, results)
+	})
+
+	t.Run("SearchWithInvalidMetadataFilter", func(t *testing.T) {
+		code, _ := searchBM25Status(t, controller, "q=宪法&metadata.=1")
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("SearchFilteredByBooleanMetadata", func(t *testing.T) {
+		reqBody := `{"content": "联邦宪法草案", "metadata": {"draft": true, "page": 1}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/doc/doc-metadata/text", bytes.NewReader([]byte(reqBody)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPathValues([]echo.PathValue{{Name: "doc_id", Value: "doc-metadata"}})
+		require.NoError(t, controller.NewTextChunk(c))
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		results := searchBM25(t, controller, "q=宪法&metadata.draft=true").Results
+		require.Len(t, results, 1)
+		assert.Equal(t, "联邦宪法草案", results[0].Content)
+		// Booleans are not numbers
+		results = searchBM25(t, controller, "q=宪法&metadata.draft=1").Results
+		assert.Empty(t, results)
+		results = searchBM25(t, controller, "q=宪法&metadata.draft=false").Results
+		assert.Empty(t, results)
+		results = searchBM25(t, controller, "q=宪法&metadata.page=1").Results
+		assert.Len(t, results, 2)
+
+		// The query language compares the values like the parameters
+		results = searchBM25(t, controller, "q="+url.QueryEscape("宪法 metadata.draft:true")).Results
+		require.Len(t, results, 1)
+		assert.Equal(t, "联邦宪法草案", results[0].Content)
+		results = searchBM25(t, controller, "q="+url.QueryEscape("宪法 metadata.draft:1")).Results
+		assert.Empty(t, results)
+
+		postDocument(t, controller, NewDocumentParams{ID: "doc-published", Title: "联邦公报", Data: map[string]any{"published": true}, Texts: plainTexts("联邦公报第一号")})
+		for _, query := range []string{"q=公报&data.published=true", "q=" + url.QueryEscape("公报 data.published:true")} {
+			results = searchBM25(t, controller, query).Results
+			assert.Len(t, results, 1, query)
+		}
+		for _, query := range []string{"q=公报&data.published=1", "q=" + url.QueryEscape("公报 data.published:1")} {
+			results = searchBM25(t, controller, query).Results
+			assert.Empty(t, results, query)
+		}
+	})
+}
+
+func TestTextChunkDedupe(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+
+	e := echo.New()
+
+	// Documents skipped as duplicates are 200 rather than 201
+	newDocument := func(id string, dedupe string, texts ...string) NewDocumentResponse {
+		reqBody, err := json.Marshal(NewDocumentParams{ID: id, Title: id, Texts: plainTexts(texts...)})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/doc/?dedupe="+dedupe, bytes.NewReader(reqBody))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.NoError(t, controller.NewDocument(e.NewContext(req, rec)))
+		require.Contains(t, []int{http.StatusCreated, http.StatusOK}, rec.Code, rec.Body.String())
+		var response NewDocumentResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		return response
+	}
+	getDocument := func(id string) (int, DocumentWithChunks) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/doc/"+id+"?with_texts=true", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPathValues([]echo.PathValue{{Name: "doc_id", Value: id}})
+		require.NoError(t, controller.GetDocument(c))
+		var document DocumentWithChunks
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &document))
+		}
+		return rec.Code, document
+	}
+	getTexts := func(id string) []TextChunk {
+		code, document := getDocument(id)
+		require.Equal(t, http.StatusOK, code)
+		return document.Texts
+	}
+
+	newDocument("doc-original", "allow", "联邦宪法第一章总则", "联邦宪法附录")
+	original := getTexts("doc-original")
+	require.Len(t, original, 2)
+
+	t.Run("ContentHashIsNormalized", func(t *testing.T) {
+		assert.NotEmpty(t, original[0].ContentHash)
+		assert.Equal(t, original[0].ContentHash, controller.contentHash("  联邦宪法第一章总则 "))
+		assert.Equal(t, controller.contentHash("Hello World"), controller.contentHash("hello   world"))
+		assert.NotEqual(t, original[0].ContentHash, original[1].ContentHash)
+	})
+
+	t.Run("Skip", func(t *testing.T) {
+		response := newDocument("doc-skip", "skip", "联邦宪法第一章总则", "联邦宪法第二章公民权利")
+		assert.Equal(t, "ok", response.Status)
+		assert.Empty(t, response.DuplicateOf)
+		assert.Equal(t, []string{original[0].ID}, response.Skipped)
+		texts := getTexts("doc-skip")
+		require.Len(t, texts, 1)
+		assert.Equal(t, "联邦宪法第二章公民权利", texts[0].Content)
+		assert.Equal(t, int64(0), texts[0].Position)
+
+		// Appending an existing text returns the original one
+		reqBody := `{"content": "联邦宪法附录"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/doc/doc-skip/text?dedupe=skip", bytes.NewReader([]byte(reqBody)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPathValues([]echo.PathValue{{Name: "doc_id", Value: "doc-skip"}})
+		require.NoError(t, controller.NewTextChunk(c))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var tc TextChunk
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tc))
+		assert.Equal(t, original[1].ID, tc.ID)
+		assert.Len(t, getTexts("doc-skip"), 1)
+	})
+
+	t.Run("Link", func(t *testing.T) {
+		response := newDocument("doc-link", "link", "联邦宪法第一章总则")
+		texts := getTexts("doc-link")
+		require.Len(t, texts, 1)
+		assert.Equal(t, original[0].ID, texts[0].DuplicateOf)
+		assert.Equal(t, []string{texts[0].ID}, response.Linked)
+		// Only some texts of doc-original are the same
+		assert.Empty(t, response.DuplicateOf)
+	})
+
+	t.Run("InvalidPolicy", func(t *testing.T) {
+		reqBody, err := json.Marshal(NewDocumentParams{ID: "doc-invalid", Title: "invalid", Texts: plainTexts("联邦宪法")})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/doc/?dedupe=unknown", bytes.NewReader(reqBody))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.NoError(t, controller.NewDocument(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("SearchCollapse", func(t *testing.T) {
+		// 总则 exists in doc-original and doc-link
+		assert.Len(t, searchBM25(t, controller, "q=总则").Results, 2)
+		assert.Len(t, searchBM25(t, controller, "q=总则&collapse=true").Results, 1)
+	})
+
+	t.Run("ListDuplicates", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/duplicates", nil)
+		rec := httptest.NewRecorder()
+		require.NoError(t, controller.ListDuplicates(e.NewContext(req, rec)))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var report DuplicateReport
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		require.Len(t, report.Clusters, 1)
+		cluster := report.Clusters[0]
+		assert.Equal(t, original[0].ContentHash, cluster.ContentHash)
+		assert.Equal(t, int64(2), cluster.ChunkCount)
+		assert.Equal(t, int64(2), cluster.DocumentCount)
+		assert.ElementsMatch(
+			t,
+			[]string{"doc-original", "doc-link"},
+			lo.Map(cluster.Texts, func(item DuplicateTextChunk, index int) string { return item.DocumentID }),
+		)
+	})
+
+	t.Run("DuplicateDocument", func(t *testing.T) {
+		// The same texts of doc-original in another order and format
+		response := newDocument("doc-copy-skipped", "skip", "联邦宪法附录", " 联邦宪法第一章总则 ")
+		assert.Equal(t, "skipped", response.Status)
+		assert.Equal(t, "doc-original", response.DuplicateOf)
+		assert.ElementsMatch(t, []string{original[0].ID, original[1].ID}, response.Skipped)
+		code, _ := getDocument("doc-copy-skipped")
+		assert.Equal(t, http.StatusNotFound, code)
+
+		response = newDocument("doc-copy", "link", "联邦宪法第一章总则", "联邦宪法附录")
+		assert.Equal(t, "ok", response.Status)
+		assert.Equal(t, "doc-original", response.DuplicateOf)
+		assert.Len(t, response.Linked, 2)
+		_, document := getDocument("doc-copy")
+		assert.Equal(t, "doc-original", document.DuplicateOf)
+
+		// A document with more texts isn't a duplicate
+		response = newDocument("doc-copy-extended", "link", "联邦宪法第一章总则", "联邦宪法附录", "联邦宪法第四章司法")
+		assert.Empty(t, response.DuplicateOf)
+		assert.Len(t, response.Linked, 2)
+	})
+
+	t.Run("UpdateOriginalClearsLinks", func(t *testing.T) {
+		newDocument("doc-edited", "allow", "联邦宪法第三章选举")
+		newDocument("doc-edited-link", "link", "联邦宪法第三章选举")
+		edited := getTexts("doc-edited")
+		require.Len(t, edited, 1)
+		require.Equal(t, edited[0].ID, getTexts("doc-edited-link")[0].DuplicateOf)
+		_, document := getDocument("doc-edited-link")
+		require.Equal(t, "doc-edited", document.DuplicateOf)
+
+		reqBody := `{"content": "联邦宪法第三章议会"}`
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/text/"+edited[0].ID, bytes.NewReader([]byte(reqBody)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPathValues([]echo.PathValue{{Name: "text_id", Value: edited[0].ID}})
+		require.NoError(t, controller.UpdateTextChunk(c))
+		require.Equal(t, http.StatusOK, rec.Code)
+		// The duplicate no longer has the same content as the edited original
+		texts := getTexts("doc-edited-link")
+		require.Len(t, texts, 1)
+		assert.Empty(t, texts[0].DuplicateOf)
+		_, document = getDocument("doc-edited-link")
+		assert.Empty(t, document.DuplicateOf)
+	})
+
+	t.Run("DeleteOriginalClearsLinks", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/doc/doc-original", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPathValues([]echo.PathValue{{Name: "doc_id", Value: "doc-original"}})
+		require.NoError(t, controller.DeleteDocument(c))
+		texts := getTexts("doc-link")
+		require.Len(t, texts, 1)
+		assert.Empty(t, texts[0].DuplicateOf)
+		_, document := getDocument("doc-copy")
+		assert.Empty(t, document.DuplicateOf)
+	})
+}
+
+func TestNearDuplicates(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+
+	e := echo.New()
+
+	article := []string{
+		"山达尔星联邦共和国联邦政府是一个强大的政治实体，它由多个星球组成，共同致力于维护和平与繁荣",
+		"星际贸易协定促进了各星球之间的经济交流，山达尔星作为贸易中心，吸引了大量商业活动",
+		"联邦政府大力投资科技研发项目，先进的空间跳跃技术使得星际旅行更加便捷",
+		"能源革命为各星球提供了清洁可持续的动力，联邦建立了完善的和平维护机制",
+	}
+	newDocument := func(id string, query string, texts ...string) int {
+		reqBody, err := json.Marshal(NewDocumentParams{ID: id, Title: id, Texts: plainTexts(texts...)})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/doc/?"+query, bytes.NewReader(reqBody))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.NoError(t, controller.NewDocument(e.NewContext(req, rec)))
+		return rec.Code
+	}
+	require.Equal(t, http.StatusCreated, newDocument("page-1", "", article...))
+	// The same page archived later with a timestamp, the distance is larger for such short texts
+	require.Equal(t, http.StatusCreated, newDocument("page-2", "", append([]string{"2024年1月1日"}, article...)...))
+	require.Equal(t, http.StatusCreated, newDocument("other", "", "军事力量仅用于防御外部威胁", "各成员星球通过民主协商解决争端"))
+
+	t.Run("SimHashStored", func(t *testing.T) {
+		row, err := controller.queries.GetDocument(t.Context(), "page-1")
+		require.NoError(t, err)
+		assert.NotZero(t, row.SimHash)
+		texts, err := controller.queries.ListTextChunksByDocumentID(t.Context(), "page-1")
+		require.NoError(t, err)
+		for _, tc := range texts {
+			assert.NotZero(t, tc.SimHash)
+		}
+	})
+
+	t.Run("Corpus", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/near_duplicates?level=document&distance=12", nil)
+		rec := httptest.NewRecorder()
+		require.NoError(t, controller.ListNearDuplicates(e.NewContext(req, rec)))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var report NearDuplicateReport
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		require.Len(t, report.Groups, 1)
+		assert.Equal(
+			t,
+			[]string{"page-1", "page-2"},
+			lo.Map(report.Groups[0].Items, func(item NearDuplicateItem, index int) string { return item.ID }),
+		)
+	})
+
+	t.Run("Document", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/doc/page-2/near_duplicates?level=text&distance=0", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPathValues([]echo.PathValue{{Name: "doc_id", Value: "page-2"}})
+		require.NoError(t, controller.ListDocumentNearDuplicates(c))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var report NearDuplicateReport
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		// Every text chunk of the article matches the one in page-1
+		require.Len(t, report.Groups, len(article))
+		for _, group := range report.Groups {
+			require.Len(t, group.Items, 2)
+			assert.Equal(t, "page-2", group.Items[0].DocumentID)
+			assert.Equal(t, "page-1", group.Items[1].DocumentID)
+		}
+	})
+
+	t.Run("InvalidParameters", func(t *testing.T) {
+		for _, query := range []string{"level=unknown", "distance=-1", "distance=100", "distance=abc"} {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/near_duplicates?"+query, nil)
+			rec := httptest.NewRecorder()
+			require.NoError(t, controller.ListNearDuplicates(e.NewContext(req, rec)))
+			assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+		}
+	})
+
+	t.Run("RejectAtIngest", func(t *testing.T) {
+		assert.Equal(t, http.StatusConflict, newDocument("page-3", "near_duplicate_distance=6", append(article, "广告")...))
+		// Overwriting a document is not rejected by itself
+		assert.Equal(t, http.StatusCreated, newDocument("other", "overwrite=true&near_duplicate_distance=6", "军事力量仅用于防御外部威胁"))
+		assert.Equal(t, http.StatusCreated, newDocument("page-3", "", append(article, "广告")...))
+	})
+}
+
+func TestAnalyzerChangeRebuildsIndex(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+
+	e := echo.New()
+
+	postDocument(t, controller, NewDocumentParams{ID: "doc-analyzer", Title: "國際", Texts: plainTexts("國際貿易協定")})
+
+	// Traditional Chinese is converted to Simplified Chinese by default
+	require.Len(t, searchBM25(t, controller, "q=国际").Results, 1)
+
+	// Duplicates by the normalized content hash
+	newDocument := func(id string, dedupe string, content string) {
+		reqBody, err := json.Marshal(NewDocumentParams{ID: id, Title: id, Texts: plainTexts(content)})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/doc/?dedupe="+dedupe, bytes.NewReader(reqBody))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.NoError(t, controller.NewDocument(e.NewContext(req, rec)))
+		require.Equal(t, http.StatusCreated, rec.Code)
+	}
+	duplicateOf := func(c *Controller, docId string) string {
+		texts, err := c.queries.ListTextChunksByDocumentID(t.Context(), docId)
+		require.NoError(t, err)
+		require.Len(t, texts, 1)
+		return texts[0].DuplicateOf
+	}
+	newDocument("doc-trade", DedupePolicyAllow, "貿易協定")
+	newDocument("doc-trade-simplified", DedupePolicyLink, "贸易协定")
+	newDocument("doc-trade-copy", DedupePolicyLink, "貿易協定")
+	original, err := controller.queries.ListTextChunksByDocumentID(t.Context(), "doc-trade")
+	require.NoError(t, err)
+	require.Equal(t, original[0].ID, duplicateOf(controller, "doc-trade-simplified"))
+	require.Equal(t, original[0].ID, duplicateOf(controller, "doc-trade-copy"))
+
+	fingerprint, err := controller.queries.GetMeta(t.Context(), analyzerFingerprintKey)
+	require.NoError(t, err)
+	defaultFingerprint, err := text.AnalyzerOptions{}.Fingerprint()
+	require.NoError(t, err)
+	assert.Equal(t, defaultFingerprint, fingerprint)
+
+	// Restart without t2s
+	options := Options{Analyzer: text.AnalyzerOptions{Normalizers: []string{text.NormalizerNFKC, text.NormalizerLowercase}}}
+	restarted, err := NewController(db, make(map[string]models.BaseEmbeddingModel), t.TempDir(), nil, options)
+	require.NoError(t, err)
+
+	assert.Empty(t, searchBM25(t, restarted, "q=国际").Results, "Index should be rebuilt without t2s")
+	assert.Len(t, searchBM25(t, restarted, "q=國際").Results, 1)
+	var ftsRows int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM text_chunk_fts`).Scan(&ftsRows))
+	assert.Equal(t, 4, ftsRows, "FTS rows should be replaced rather than duplicated")
+	// The content hashes differ without t2s
+	assert.Empty(t, duplicateOf(restarted, "doc-trade-simplified"))
+	assert.Equal(t, original[0].ID, duplicateOf(restarted, "doc-trade-copy"))
+	fingerprint, err = restarted.queries.GetMeta(t.Context(), analyzerFingerprintKey)
+	require.NoError(t, err)
+	assert.NotEqual(t, defaultFingerprint, fingerprint)
+}
+
+func TestLanguageAwareAnalysis(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+
+	e := echo.New()
+
+	reqBody := `{
+		"id": "doc-languages",
+		"title": "多言語",
+		"texts": [
+			"山达尔星联邦共和国的國際貿易非常发达",
+			"これは日本語の文章です。國際貿易について説明します。",
+			"International trade of the federation is prosperous",
+			{"content": "國際", "language": "ja"}
+		]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/doc/", bytes.NewReader([]byte(reqBody)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	require.NoError(t, controller.NewDocument(e.NewContext(req, rec)))
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	texts, err := controller.queries.ListTextChunksByDocumentID(t.Context(), "doc-languages")
+	require.NoError(t, err)
+	require.Len(t, texts, 4)
+
+	t.Run("DetectedAtIngestion", func(t *testing.T) {
+		assert.Equal(t, []string{"zh", "ja", "en", "ja"}, lo.Map(texts, func(item dao.TextChunk, index int) string { return item.Language }))
+	})
+
+	t.Run("LanguageSpecificChain", func(t *testing.T) {
+		// Chinese text is converted to simplified Chinese, and Japanese kanji are converted to traditional kanji first
+		assert.Contains(t, texts[0].SegContent, "国际")
+		assert.Contains(t, texts[1].SegContent, "国际")
+		assert.Contains(t, texts[3].SegContent, "国际")
+		assert.Contains(t, strings.Fields(texts[3].SegContent), "國際", "Surface forms should be kept")
+	})
+
+	t.Run("FilterByLanguage", func(t *testing.T) {
+		results := searchBM25(t, controller, "q=國際貿易&lang=ja").Results
+		require.Len(t, results, 1)
+		assert.Equal(t, "ja", results[0].Language)
+		assert.Len(t, searchBM25(t, controller, "q=國際貿易&lang=zh,ja").Results, 2)
+		assert.Len(t, searchBM25(t, controller, "q=國際貿易&lang=zh&lang=ja").Results, 2)
+		assert.Empty(t, searchBM25(t, controller, "q=國際貿易&lang=en").Results)
+	})
+
+	t.Run("JapaneseShinjitai", func(t *testing.T) {
+		reqBody := `{"id": "doc-shinjitai", "title": "駅", "texts": ["駅の近くで情報を検索する方法について説明します。"]}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/doc/", bytes.NewReader([]byte(reqBody)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.NoError(t, controller.NewDocument(e.NewContext(req, rec)))
+		require.Equal(t, http.StatusCreated, rec.Code)
+		// Shinjitai 駅 matches both simplified 驿 and traditional 驛
+		for _, query := range []string{"驿", "驛", "駅"} {
+			results := searchBM25(t, controller, "q="+url.QueryEscape(query)+"&lang=ja").Results
+			require.Len(t, results, 1, query)
+			assert.Equal(t, "doc-shinjitai", results[0].DocumentID)
+		}
+	})
+
+	t.Run("QueryLanguageDetection", func(t *testing.T) {
+		response := searchBM25(t, controller, "q=trade&lang=auto")
+		assert.Equal(t, "en", response.QueryLanguage)
+		require.Len(t, response.Results, 1)
+		assert.Equal(t, "en", response.Results[0].Language)
+	})
+}
+
+func TestWiderLanguageSupport(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(GetDDL())
+	require.NoError(t, err)
+	options := Options{Analyzer: text.AnalyzerOptions{
+		DetectLanguages: []string{"zh", "ja", "en", "ko", "de", "fr", "th"},
+		Languages:       map[string]text.LanguageOptions{"th": {Tokenizer: text.TokenizerNGram}},
+	}}
+	controller, err := NewController(db, make(map[string]models.BaseEmbeddingModel), t.TempDir(), nil, options)
+	require.NoError(t, err)
+
+	postDocument(t, controller, NewDocumentParams{
+		ID:    "doc-wider-languages",
+		Title: "Languages",
+		Texts: plainTexts(
+			"한국어는 대한민국의 공용어입니다",
+			"Die Bundesrepublik Deutschland ist ein Bundesstaat in Mitteleuropa",
+			"ภาษาไทยเป็นภาษาราชการของประเทศไทย",
+		),
+	})
+
+	texts, err := controller.queries.ListTextChunksByDocumentID(t.Context(), "doc-wider-languages")
+	require.NoError(t, err)
+	require.Len(t, texts, 3)
+	assert.Equal(t, "ko", texts[0].Language)
+	assert.Equal(t, "de", texts[1].Language)
+	assert.Equal(t, "th", texts[2].Language)
+	assert.NotContains(t, strings.Fields(texts[1].SegContent), "ist", "German stop words should be removed")
+
+	// Korean words with particles are matched by bigrams
+	assert.Len(t, searchBM25(t, controller, "q=한국").Results, 1)
+	assert.Len(t, searchBM25(t, controller, "q=deutschland").Results, 1)
+	// Longer query words are tokenized into bigrams too, and match the phrases of them
+	assert.Len(t, searchBM25(t, controller, "q=한국어").Results, 1)
+	assert.Len(t, searchBM25(t, controller, "q=대한민국").Results, 1)
+	assert.Len(t, searchBM25(t, controller, "q=공용어").Results, 1)
+	assert.Len(t, searchBM25(t, controller, "q="+url.QueryEscape("대한민국 공용어")).Results, 1)
+	assert.Empty(t, searchBM25(t, controller, "q=한국민").Results, "the bigrams should be adjacent")
+	assert.Len(t, searchBM25(t, controller, "q=ประเทศไทย").Results, 1)
+	assert.Len(t, searchBM25(t, controller, "q=ภาษาราชการ").Results, 1)
+}
+
+func TestDetectLanguagesChange(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+
+	postDocument(t, controller, NewDocumentParams{
+		ID:    "doc-languages",
+		Title: "Languages",
+		Texts: []TextInput{
+			{Content: "Die Bundesrepublik Deutschland ist ein Bundesstaat in Mitteleuropa"},
+			{Content: "Der Bundestag ist das Parlament", Language: "en"},
+			{Content: "한국어는 대한민국의 공용어입니다"},
+		},
+	})
+	texts, err := controller.queries.ListTextChunksByDocumentID(t.Context(), "doc-languages")
+	require.NoError(t, err)
+	require.Len(t, texts, 3)
+	require.Equal(t, "en", texts[0].Language, "German is detected as English by default")
+
+	// Restart detecting German and Korean
+	options := Options{Analyzer: text.AnalyzerOptions{DetectLanguages: []string{"zh", "ja", "en", "de", "ko"}}}
+	restarted, err := NewController(db, make(map[string]models.BaseEmbeddingModel), t.TempDir(), nil, options)
+	require.NoError(t, err)
+
+	texts, err = restarted.queries.ListTextChunksByDocumentID(t.Context(), "doc-languages")
+	require.NoError(t, err)
+	require.Len(t, texts, 3)
+	assert.Equal(t, "de", texts[0].Language, "detected languages should be detected again")
+	assert.NotContains(t, strings.Fields(texts[0].SegContent), "ist", "German stop words should be removed")
+	assert.Equal(t, "en", texts[1].Language, "given languages should be kept")
+	assert.Equal(t, "ko", texts[2].Language)
+	// Words with particles are matched by the bigrams of Korean
+	assert.Len(t, searchBM25(t, restarted, "q=한국").Results, 1)
+}
+
+func TestSynonymsAndAnalyzerReload(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(GetDDL())
+	require.NoError(t, err)
+	synonymPath := filepath.Join(t.TempDir(), "synonyms.txt")
+	require.NoError(t, os.WriteFile(synonymPath, []byte("山达尔星, 山星\n"), 0o644))
+	options := Options{Analyzer: text.AnalyzerOptions{Synonyms: []string{synonymPath}}}
+	controller, err := NewController(db, make(map[string]models.BaseEmbeddingModel), t.TempDir(), nil, options)
+	require.NoError(t, err)
+
+	e := echo.New()
+	postDocument(t, controller, NewDocumentParams{
+		ID:    "doc-synonyms",
+		Title: "Planets",
+		Texts: plainTexts("山达尔星是联邦的首都", "山星的卫星很多", "银河系有很多恒星"),
+	})
+
+	// Both terms of the group match texts containing either of them
+	assert.Len(t, searchBM25(t, controller, "q=山达尔星").Results, 2)
+	assert.Len(t, searchBM25(t, controller, "q=山星").Results, 2)
+
+	// Add a group whose canonical term is not in the texts, only the affected text chunk is analyzed again
+	require.NoError(t, os.WriteFile(synonymPath, []byte("山达尔星, 山星\n联合体, 联邦\n"), 0o644))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/analyzer/reload", nil)
+	rec := httptest.NewRecorder()
+	require.NoError(t, controller.ReloadAnalyzer(e.NewContext(req, rec)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var report AnalyzerReloadReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Equal(t, []string{"联合体", "联邦"}, report.ChangedWords)
+	assert.Equal(t, 1, report.ReanalyzedTextChunks)
+
+	texts, err := controller.queries.ListTextChunksByDocumentID(t.Context(), "doc-synonyms")
+	require.NoError(t, err)
+	require.Len(t, texts, 3)
+	assert.Contains(t, strings.Fields(texts[0].SegContent), "联合体")
+	assert.Len(t, searchBM25(t, controller, "q=联合体").Results, 1)
+
+	// The reloaded analyzer is recorded, so restarting doesn't rebuild the indexes
+	fingerprint, err := controller.queries.GetMeta(t.Context(), analyzerFingerprintKey)
+	require.NoError(t, err)
+	assert.Equal(t, report.Fingerprint, fingerprint)
+}
+
+func TestStemming(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(GetDDL())
+	require.NoError(t, err)
+	options := Options{Analyzer: text.AnalyzerOptions{Stemming: true}}
+	controller, err := NewController(db, make(map[string]models.BaseEmbeddingModel), t.TempDir(), nil, options)
+	require.NoError(t, err)
+
+	postDocument(t, controller, NewDocumentParams{
+		ID:    "doc-stemming",
+		Title: "Running",
+		Texts: plainTexts("The athletes were running along the river", "She runs every morning", "Swimming in the lake"),
+	})
+
+	texts, err := controller.queries.ListTextChunksByDocumentID(t.Context(), "doc-stemming")
+	require.NoError(t, err)
+	require.Len(t, texts, 3)
+	// Both surface and stemmed forms are indexed
+	assert.Subset(t, strings.Fields(texts[0].SegContent), []string{"running", "run", "athletes", "athlet"})
+
+	assert.Len(t, searchBM25(t, controller, "q=run").Results, 2)
+	// Queries are stemmed too
+	assert.Len(t, searchBM25(t, controller, "q=running").Results, 2)
+	assert.Len(t, searchBM25(t, controller, "q=swim").Results, 1)
+}
+
+func TestTransliteration(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(GetDDL())
+	require.NoError(t, err)
+	options := Options{Analyzer: text.AnalyzerOptions{Transliterate: true}}
+	controller, err := NewController(db, make(map[string]models.BaseEmbeddingModel), t.TempDir(), nil, options)
+	require.NoError(t, err)
+
+	postDocument(t, controller, NewDocumentParams{
+		ID:    "doc-transliteration",
+		Title: "Transliteration",
+		Texts: plainTexts("联邦政府位于首都", "コンピューターを使う"),
+	})
+
+	results := searchBM25(t, controller, "q=lianbang").Results
+	require.Len(t, results, 1)
+	assert.Equal(t, "联邦政府位于首都", results[0].Content)
+	results = searchBM25(t, controller, "q=konpyuutaa").Results
+	require.Len(t, results, 1)
+	assert.Equal(t, "コンピューターを使う", results[0].Content)
+}
+
+func TestTypoTolerantAndPrefixSearch(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+
+	postDocument(t, controller, NewDocumentParams{
+		ID:    "doc-typo",
+		Title: "Federation",
+		Texts: plainTexts("The galactic federation protects the planets", "Federal laws of the republic", "联邦政府位于首都"),
+	})
+
+	t.Run("Typo", func(t *testing.T) {
+		response := searchBM25(t, controller, "q="+url.QueryEscape("galactic fedaration"))
+		require.Len(t, response.Results, 1)
+		assert.Equal(t, "The galactic federation protects the planets", response.Results[0].Content)
+		assert.Equal(t, "galactic federation", response.Suggestion)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		response := searchBM25(t, controller, "q=fedaration&fuzzy=false")
+		assert.Empty(t, response.Results)
+		assert.Empty(t, response.Suggestion)
+	})
+
+	t.Run("CorrectQuery", func(t *testing.T) {
+		response := searchBM25(t, controller, "q=federation")
+		assert.Len(t, response.Results, 1)
+		assert.Empty(t, response.Suggestion)
+	})
+
+	t.Run("Prefix", func(t *testing.T) {
+		assert.Len(t, searchBM25(t, controller, "q="+url.QueryEscape("feder*")).Results, 2)
+		assert.Len(t, searchBM25(t, controller, "q="+url.QueryEscape("联邦*")).Results, 1)
+	})
+}
+
+func TestSuggest(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+
+	e := echo.New()
+	postDocument(t, controller, NewDocumentParams{
+		ID:    "doc-galaxy",
+		Title: "Galactic Federation Charter",
+		Texts: plainTexts("The galactic federation protects the planets", "Federal laws of the federation", "联邦政府位于首都"),
+	})
+	postDocument(t, controller, NewDocumentParams{
+		ID:    "doc-usa",
+		Title: "美国联邦政府",
+		Texts: plainTexts("联邦法律适用于各州"),
+	})
+
+	suggest := func(prefix string) []Suggestion {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/suggest?prefix="+url.QueryEscape(prefix), nil)
+		rec := httptest.NewRecorder()
+		require.NoError(t, controller.Suggest(e.NewContext(req, rec)))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var response SuggestResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		return response.Suggestions
+	}
+	find := func(suggestions []Suggestion, text string) *Suggestion {
+		suggestion, ok := lo.Find(suggestions, func(item Suggestion) bool { return item.Text == text })
+		if !ok {
+			return nil
+		}
+		return &suggestion
+	}
+
+	t.Run("Terms", func(t *testing.T) {
+		suggestions := suggest("Fed")
+		federation := find(suggestions, "federation")
+		require.NotNil(t, federation)
+		assert.Equal(t, []string{SuggestionSourceTerm}, federation.Sources)
+		assert.Equal(t, int64(2), federation.Count)
+		assert.NotNil(t, find(suggestions, "federal"))
+		// The most popular one first
+		assert.Equal(t, "federation", suggestions[0].Text)
+	})
+
+	t.Run("Titles", func(t *testing.T) {
+		// Titles are completed from any word
+		assert.NotNil(t, find(suggest("fed"), "Galactic Federation Charter"))
+		assert.NotNil(t, find(suggest("galactic fed"), "Galactic Federation Charter"))
+		assert.NotNil(t, find(suggest("联邦"), "美国联邦政府"))
+	})
+
+	t.Run("CompleteLastWord", func(t *testing.T) {
+		assert.NotNil(t, find(suggest("galactic fed"), "galactic federation"))
+	})
+
+	t.Run("CJK", func(t *testing.T) {
+		suggestions := suggest("联")
+		assert.NotNil(t, find(suggestions, "联邦"))
+		assert.NotNil(t, find(suggestions, "联邦政府"))
+		// Traditional Chinese prefixes complete simplified terms
+		assert.NotNil(t, find(suggest("聯"), "联邦"))
+	})
+
+	t.Run("Queries", func(t *testing.T) {
+		for _, query := range []string{"federal laws", "federal laws", "nothing matches"} {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/search/bm25?fuzzy=false&q="+url.QueryEscape(query), nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPathValues([]echo.PathValue{{Name: "model_id", Value: "bm25"}})
+			require.NoError(t, controller.Search(c))
+			require.Equal(t, http.StatusOK, rec.Code)
+		}
+		suggestion := find(suggest("federal l"), "federal laws")
+		require.NotNil(t, suggestion)
+		assert.Contains(t, suggestion.Sources, SuggestionSourceQuery)
+		// Queries without results are not suggested
+		assert.Empty(t, suggest("nothing"))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/doc/doc-usa", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPathValues([]echo.PathValue{{Name: "doc_id", Value: "doc-usa"}})
+		require.NoError(t, controller.DeleteDocument(c))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		suggestions := suggest("联")
+		assert.Nil(t, find(suggestions, "美国联邦政府"))
+		// "联邦" was only in the deleted document
+		assert.Nil(t, find(suggestions, "联邦"))
+		assert.NotNil(t, find(suggestions, "联邦政府"))
+	})
+
+	t.Run("ConsistentWithIndex", func(t *testing.T) {
+		incremental := lo.Map([]string{"f", "g", "联", "首"}, func(prefix string, index int) []Suggestion { return suggest(prefix) })
+		require.NoError(t, controller.loadSuggestions(context.Background()))
+		reloaded := lo.Map([]string{"f", "g", "联", "首"}, func(prefix string, index int) []Suggestion { return suggest(prefix) })
+		assert.Equal(t, reloaded, incremental)
+	})
+
+	t.Run("MissingPrefix", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/suggest", nil)
+		rec := httptest.NewRecorder()
+		_ = controller.Suggest(e.NewContext(req, rec))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+// failingEmbeddingModel fails every request, so the transactions embedding texts are rolled back
+type failingEmbeddingModel struct{}
+
+func (failingEmbeddingModel) Embed(ctx context.Context, text []string) ([][]float32, error) {
+	return nil, errors.New("embedding model is unavailable")
+}
+
+func TestSuggestRollback(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(GetDDL())
+	require.NoError(t, err)
+	embeddingModels := map[string]models.BaseEmbeddingModel{"failing": failingEmbeddingModel{}}
+	controller, err := NewController(db, embeddingModels, t.TempDir(), nil, Options{})
+	require.NoError(t, err)
+
+	e := echo.New()
+	reqBody, err := json.Marshal(NewDocumentParams{
+		ID:    "doc-rollback",
+		Title: "Galactic Federation Charter",
+		Texts: plainTexts("The galactic federation protects the planets"),
+	})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/doc/", bytes.NewReader(reqBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	require.NoError(t, controller.NewDocument(e.NewContext(req, rec)))
+	require.NotEqual(t, http.StatusCreated, rec.Code)
+
+	// Neither the title nor the terms of the rolled back document are suggested
+	assert.Empty(t, controller.suggest("galactic", 10))
+	assert.Empty(t, controller.suggest("fed", 10))
+}
+
+func TestQueryLanguage(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+
+	for _, param := range []NewDocumentParams{
+		{
+			ID:    "doc-charter",
+			Title: "Galactic Federation Charter",
+			Data:  map[string]any{"category": "law", "tags": []string{"charter", "federation"}},
+			Texts: plainTexts("The galactic federation protects the planets", "The federation of planets is galactic in many remote old sectors"),
+		},
+		{
+			ID:    "doc-news",
+			Title: "Empire News",
+			Data:  map[string]any{"category": "news"},
+			Texts: plainTexts("The galactic empire attacks the federation"),
+		},
+	} {
+		postDocument(t, controller, param)
+	}
+
+	contents := func(query string) []string {
+		response := searchBM25(t, controller, "q="+url.QueryEscape(query))
+		return lo.Map(response.Results, func(item SearchResultItem, index int) string { return item.Content })
+	}
+
+	assert.ElementsMatch(t, []string{"The galactic federation protects the planets"}, contents(`"galactic federation"`))
+	assert.ElementsMatch(t, []string{"The galactic federation protects the planets", "The galactic empire attacks the federation"}, contents("galactic NEAR/2 federation"))
+	assert.ElementsMatch(t, []string{"The galactic federation protects the planets", "The federation of planets is galactic in many remote old sectors"}, contents("federation -empire"))
+	assert.ElementsMatch(t, []string{"The galactic empire attacks the federation"}, contents("federation title:empire"))
+	assert.ElementsMatch(t, []string{"The galactic empire attacks the federation"}, contents("federation -title:federation"))
+	assert.ElementsMatch(t, []string{"The galactic empire attacks the federation"}, contents("galactic data.category:news"))
+	assert.Len(t, contents("galactic data.tags:charter"), 2)
+	assert.Len(t, contents("galactic created_at:2020-01-01.."), 3)
+	assert.Empty(t, contents("galactic created_at:..2020-01-01"))
+
+	t.Run("Suggestion", func(t *testing.T) {
+		response := searchBM25(t, controller, "q="+url.QueryEscape(`fedaration title:"galactic federation"`))
+		assert.Len(t, response.Results, 2)
+		assert.Equal(t, `federation title:"galactic federation"`, response.Suggestion)
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		for _, query := range []string{`"galactic federation`, "(galactic", "galactic OR", "-galactic", "author:tolkien", "title:federation", "galactic created_at:yesterday"} {
+			code, _ := searchBM25Status(t, controller, "q="+url.QueryEscape(query))
+			assert.Equal(t, http.StatusBadRequest, code, query)
+		}
+	})
+}
+
+// fakeGenerationModel answers with a fixed text and records the prompts
+type fakeGenerationModel struct {
+	answer       string
+	systemPrompt string
+	prompt       string
+	// configuredPrompt is the system prompt of Generate
+	configuredPrompt string
+	err              error
+	document         models.GenerationDocument
+	outputSchema     *jsonschema.Resolved
+}
+
+func (f *fakeGenerationModel) Generate(ctx context.Context, document models.GenerationDocument, texts []string) (string, error) {
+	f.document = document
+	return f.Chat(ctx, f.configuredPrompt, strings.Join(texts, "\n\n"))
+}
+
+func (f *fakeGenerationModel) SystemPrompt() string {
+	return f.configuredPrompt
+}
+
+func (f *fakeGenerationModel) OutputSchema() *jsonschema.Resolved {
+	return f.outputSchema
+}
+
+func (f *fakeGenerationModel) Chat(ctx context.Context, systemPrompt string, prompt string) (string, error) {
+	f.systemPrompt = systemPrompt
+	f.prompt = prompt
+	return f.answer, f.err
+}
+
+// ChatStream streams the answer word by word
+func (f *fakeGenerationModel) ChatStream(ctx context.Context, systemPrompt string, prompt string, onDelta func(delta string) error) (string, error) {
+	answer, err := f.Chat(ctx, systemPrompt, prompt)
+	if err != nil {
+		return "", err
+	}
+	for _, delta := range strings.SplitAfter(answer, " ") {
+		if err := onDelta(delta); err != nil {
+			return "", err
+		}
+	}
+	return answer, nil
+}
+
+func TestAsk(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+	model := &fakeGenerationModel{answer: "The federation protects the planets [1]."}
+	controller.generationModels = map[string]models.GenerationModel{"fake": model}
+
+	e := echo.New()
+	for _, param := range []NewDocumentParams{
+		{ID: "doc-charter", Title: "Galactic Federation Charter", Texts: plainTexts("The galactic federation protects the planets", "Members pay taxes to the federation")},
+		{ID: "doc-news", Title: "Empire News", Data: map[string]any{"category": "news"}, Texts: plainTexts("The galactic empire attacks the federation")},
+	} {
+		postDocument(t, controller, param)
+	}
+
+	ask := func(param AskParams) (int, AskResponse) {
+		reqBody, err := json.Marshal(param)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/ask", bytes.NewReader(reqBody))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.NoError(t, controller.Ask(e.NewContext(req, rec)))
+		var response AskResponse
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		}
+		return rec.Code, response
+	}
+
+	t.Run("Citations", func(t *testing.T) {
+		code, response := ask(AskParams{Question: "Who protects the planets?"})
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "fake", response.Model)
+		assert.Equal(t, model.answer, response.Answer)
+		require.NotEmpty(t, response.Citations)
+		first := response.Citations[0]
+		assert.Equal(t, 1, first.Index)
+		assert.Equal(t, "doc-charter", first.DocumentID)
+		assert.Equal(t, "The galactic federation protects the planets", first.Content)
+		assert.True(t, first.Cited)
+		assert.False(t, lo.SomeBy(response.Citations[1:], func(item Citation) bool { return item.Cited }))
+		// The prompt numbers the retrieved texts
+		assert.Equal(t, askSystemPrompt, model.systemPrompt)
+		assert.Contains(t, model.prompt, "[1] Galactic Federation Charter\nThe galactic federation protects the planets")
+		assert.Contains(t, model.prompt, "Question: Who protects the planets?")
+	})
+
+	t.Run("QueryAndLimits", func(t *testing.T) {
+		code, response := ask(AskParams{Question: "What happened?", Query: "galactic data.category:news", Retrieval: RetrievalHybrid, N: 3, MaxContextLength: 10})
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, response.Citations, 1)
+		assert.Equal(t, "doc-news", response.Citations[0].DocumentID)
+		assert.Equal(t, "The galact", response.Citations[0].Content)
+	})
+
+	t.Run("NoContext", func(t *testing.T) {
+		code, response := ask(AskParams{Question: "Unrelated?"})
+		require.Equal(t, http.StatusOK, code)
+		assert.Empty(t, response.Citations)
+		assert.Contains(t, model.prompt, "(no passages found)")
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		reqBody, err := json.Marshal(AskParams{Question: "Who protects the planets?"})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/ask/stream", bytes.NewReader(reqBody))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.NoError(t, controller.AskStream(e.NewContext(req, rec)))
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "text/event-stream", rec.Header().Get(echo.HeaderContentType))
+		assert.True(t, rec.Flushed)
+
+		events := make([]string, 0)
+		deltas := ""
+		var citations []Citation
+		var done AskResponse
+		for _, block := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n") {
+			event, data, ok := strings.Cut(block, "\ndata: ")
+			require.True(t, ok, block)
+			event = strings.TrimPrefix(event, "event: ")
+			events = append(events, event)
+			switch event {
+			case SSEEventCitations:
+				require.NoError(t, json.Unmarshal([]byte(data), &citations))
+			case SSEEventDelta:
+				var delta AskDelta
+				require.NoError(t, json.Unmarshal([]byte(data), &delta))
+				deltas += delta.Text
+			case SSEEventDone:
+				require.NoError(t, json.Unmarshal([]byte(data), &done))
+			}
+		}
+		assert.Equal(t, []string{SSEEventCitations, SSEEventDelta, SSEEventDelta, SSEEventDelta, SSEEventDelta, SSEEventDelta, SSEEventDelta, SSEEventDone}, events)
+		assert.Equal(t, model.answer, deltas)
+		require.NotEmpty(t, citations)
+		assert.False(t, citations[0].Cited)
+		assert.Equal(t, model.answer, done.Answer)
+		require.NotEmpty(t, done.Citations)
+		assert.True(t, done.Citations[0].Cited)
+	})
+
+	t.Run("BadRequests", func(t *testing.T) {
+		for _, param := range []AskParams{
+			{},
+			{Question: "Who?", Model: "missing"},
+			{Question: "Who?", Retrieval: "missing"},
+			{Question: "Who?", Query: `"unterminated`},
+		} {
+			code, _ := ask(param)
+			assert.Equal(t, http.StatusBadRequest, code, param)
+		}
+	})
+}
+
+func TestRerank(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+	// The cross-encoder prefers texts about taxes
+	teiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Query string   `json:"query"`
+			Texts []string `json:"texts"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Equal(t, "federation", request.Query)
+		results := make([]map[string]any, 0, len(request.Texts))
+		for i, text := range request.Texts {
+			score := 0.1
+			if strings.Contains(text, "taxes") {
+				score = 0.9
+			}
+			results = append(results, map[string]any{"index": i, "score": score})
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(results))
+	}))
+	defer teiServer.Close()
+	jinaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Model     string   `json:"model"`
+			Documents []string `json:"documents"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Equal(t, "jina-reranker", request.Model)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		results := make([]map[string]any, 0, len(request.Documents))
+		for i, text := range request.Documents {
+			results = append(results, map[string]any{"index": i, "relevance_score": float64(len(text))})
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]any{"results": results}))
+	}))
+	defer jinaServer.Close()
+	tei, err := models.NewRerankModel(models.RerankModelTypeTEI, map[string]interface{}{"endpoint": teiServer.URL + "/rerank"})
+	require.NoError(t, err)
+	jina, err := models.NewRerankModel(models.RerankModelTypeJina, map[string]interface{}{"endpoint": jinaServer.URL + "/v1/rerank", "model": "jina-reranker", "token": "secret"})
+	require.NoError(t, err)
+	controller.options.RerankModels = map[string]models.RerankModel{"tei": tei, "jina": jina}
+	model := &fakeGenerationModel{answer: "Scores: [2, 9, 5]"}
+	controller.generationModels = map[string]models.GenerationModel{"fake": model}
+
+	postDocument(t, controller, NewDocumentParams{
+		ID:    "doc-charter",
+		Title: "Galactic Federation Charter",
+		Texts: plainTexts("The federation protects the planets", "Members of the federation pay taxes", "The galactic federation of the free planets has a long charter"),
+	})
+
+	contents := func(response SearchResponse) []string {
+		return lo.Map(response.Results, func(item SearchResultItem, index int) string { return item.Content })
+	}
+
+	original := searchBM25(t, controller, "q=federation")
+	require.Len(t, original.Results, 3)
+
+	t.Run("TEI", func(t *testing.T) {
+		response := searchBM25(t, controller, "q=federation&n=1&rerank=tei")
+		require.Len(t, response.Results, 1)
+		assert.Equal(t, "Members of the federation pay taxes", response.Results[0].Content)
+		assert.Equal(t, 0.9, response.Results[0].Score)
+	})
+
+	t.Run("Jina", func(t *testing.T) {
+		response := searchBM25(t, controller, "q=federation&n=1&rerank=jina&rerank_k=2")
+		// Only the top 2 candidates are reranked, the longer one wins
+		expected := contents(original)[:2]
+		sort.Slice(expected, func(i, j int) bool { return len(expected[i]) > len(expected[j]) })
+		assert.Equal(t, expected[:1], contents(response))
+	})
+
+	t.Run("GenerationModel", func(t *testing.T) {
+		response := searchBM25(t, controller, "q=federation&rerank=fake")
+		expected := contents(original)
+		assert.Equal(t, []string{expected[1], expected[2], expected[0]}, contents(response))
+		assert.Equal(t, []float64{9, 5, 2}, lo.Map(response.Results, func(item SearchResultItem, index int) float64 { return item.Score }))
+		assert.Contains(t, model.prompt, "Query: federation")
+		assert.Contains(t, model.prompt, "[3] "+expected[2])
+	})
+
+	t.Run("BadRequests", func(t *testing.T) {
+		for _, query := range []string{"q=federation&rerank=missing", "q=federation&rerank=tei&rerank_k=0", "q=federation&rerank=tei&rerank_k=x"} {
+			code, _ := searchBM25Status(t, controller, query)
+			assert.Equal(t, http.StatusBadRequest, code, query)
+		}
+		// Malformed replies of generation models are server errors
+		model.answer = "[1, 2]"
+		code, _ := searchBM25Status(t, controller, "q=federation&rerank=fake")
+		assert.Equal(t, http.StatusInternalServerError, code)
+	})
+}
+
+func TestQueryRewrite(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+	rewriter := &fakeGenerationModel{answer: "```json\n{\"keywords\": [\"taxes\", \" 税金 \", \"taxes\", \"\"], \"hypothetical_answer\": \"Members pay taxes.\"}\n```"}
+	answerer := &fakeGenerationModel{answer: "Members pay taxes [1]."}
+	controller.generationModels = map[string]models.GenerationModel{"rewriter": rewriter, "answerer": answerer}
+
+	e := echo.New()
+	postDocument(t, controller, NewDocumentParams{
+		ID:    "doc-charter",
+		Title: "Galactic Federation Charter",
+		Texts: plainTexts("The federation protects the planets", "Members of the federation pay taxes", "联邦的成员缴纳税金"),
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		response := searchBM25(t, controller, "q=protects&fuzzy=false")
+		require.Len(t, response.Results, 1)
+		assert.Nil(t, response.Rewrite)
+
+		response = searchBM25(t, controller, "q=protects&fuzzy=false&rewrite=rewriter")
+		// The original query and the keywords in both languages are merged
+		assert.ElementsMatch(t,
+			[]string{"The federation protects the planets", "Members of the federation pay taxes", "联邦的成员缴纳税金"},
+			lo.Map(response.Results, func(item SearchResultItem, index int) string { return item.Content }),
+		)
+		require.NotNil(t, response.Rewrite)
+		assert.Equal(t, "rewriter", response.Rewrite.Model)
+		assert.Equal(t, []string{"taxes", "税金"}, response.Rewrite.Keywords)
+		assert.Equal(t, "Members pay taxes.", response.Rewrite.HypotheticalAnswer)
+		assert.Equal(t, "protects", rewriter.prompt)
+		assert.Contains(t, rewriter.systemPrompt, "zh, ja, en")
+	})
+
+	t.Run("Ask", func(t *testing.T) {
+		reqBody, err := json.Marshal(AskParams{Question: "Who pays money?", Model: "answerer", Rewrite: "rewriter"})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/ask", bytes.NewReader(reqBody))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.NoError(t, controller.Ask(e.NewContext(req, rec)))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var response AskResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		require.NotNil(t, response.Rewrite)
+		assert.Equal(t, "Who pays money?", rewriter.prompt)
+		// Only the keywords find the text chunks
+		assert.ElementsMatch(t,
+			[]string{"Members of the federation pay taxes", "联邦的成员缴纳税金"},
+			lo.Map(response.Citations, func(item Citation, index int) string { return item.Content }),
+		)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		code, _ := searchBM25Status(t, controller, "q=protects&rewrite=missing")
+		assert.Equal(t, http.StatusBadRequest, code)
+		rewriter.answer = "no idea"
+		code, _ = searchBM25Status(t, controller, "q=protects&rewrite=rewriter")
+		assert.Equal(t, http.StatusInternalServerError, code)
+	})
+}
+
+func TestGeneratedTexts(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+	summary := &fakeGenerationModel{answer: "A summary about taxes", configuredPrompt: "summarize v1"}
+	keywords := &fakeGenerationModel{answer: "taxes federation members", configuredPrompt: "keywords v1"}
+	controller.generationModels = map[string]models.GenerationModel{"summary": summary, "keywords": keywords}
+
+	e := echo.New()
+	reqBody, err := json.Marshal(NewDocumentParams{ID: "doc-taxes", Title: "Taxes", Texts: plainTexts("Members of the federation pay taxes")})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/doc/?ai_gen=true", bytes.NewReader(reqBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	require.NoError(t, controller.NewDocument(e.NewContext(req, rec)))
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	getTexts := func() []TextChunk {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/doc/doc-taxes?with_texts=true", nil)
+		rec := httptest.NewRecorder()
+		echoCtx := e.NewContext(req, rec)
+		echoCtx.SetPathValues(echo.PathValues{{Name: "doc_id", Value: "doc-taxes"}})
+		require.NoError(t, controller.GetDocument(echoCtx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var document DocumentWithChunks
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &document))
+		return document.Texts
+	}
+	refresh := func(method string, docId string, query string) (int, GeneratedTextsResponse) {
+		req := httptest.NewRequest(method, "/api/v1/generated?"+query, nil)
+		rec := httptest.NewRecorder()
+		echoCtx := e.NewContext(req, rec)
+		if docId != "" {
+			echoCtx.SetPathValues(echo.PathValues{{Name: "doc_id", Value: docId}})
+		}
+		if method == http.MethodPost {
+			require.NoError(t, controller.RegenerateTexts(echoCtx))
+		} else {
+			require.NoError(t, controller.DropGeneratedTexts(echoCtx))
+		}
+		var response GeneratedTextsResponse
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		}
+		return rec.Code, response
+	}
+	origins := func() map[string]string {
+		result := make(map[string]string)
+		for _, text := range getTexts() {
+			if text.Origin != nil {
+				result[text.Origin.Model] = text.Content
+			}
+		}
+		return result
+	}
+
+	t.Run("Origin", func(t *testing.T) {
+		texts := getTexts()
+		require.Len(t, texts, 3)
+		assert.Nil(t, texts[0].Origin)
+		// Models generate in the order of their IDs
+		require.NotNil(t, texts[1].Origin)
+		assert.Equal(t, "keywords", texts[1].Origin.Model)
+		assert.Equal(t, promptHash(keywords), texts[1].Origin.PromptHash)
+		assert.Positive(t, texts[1].Origin.GeneratedAt)
+		require.NotNil(t, texts[2].Origin)
+		assert.Equal(t, "summary", texts[2].Origin.Model)
+		assert.Equal(t, "summarize v1", summary.systemPrompt)
+		assert.Equal(t, "Members of the federation pay taxes", summary.prompt)
+	})
+
+	t.Run("Filter", func(t *testing.T) {
+		for query, expected := range map[string][]string{
+			"q=taxes&generated=false":                           {""},
+			"q=taxes&generated=true":                            {"keywords", "summary"},
+			"q=taxes&generated_by=summary":                      {"summary"},
+			"q=taxes&generated_by=summary&generated_by=missing": {"summary"},
+		} {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/search/bm25?"+query, nil)
+			rec := httptest.NewRecorder()
+			echoCtx := e.NewContext(req, rec)
+			echoCtx.SetPathValues(echo.PathValues{{Name: "model_id", Value: "bm25"}})
+			require.NoError(t, controller.Search(echoCtx))
+			require.Equal(t, http.StatusOK, rec.Code, query)
+			var response SearchResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+			assert.ElementsMatch(t, expected, lo.Map(response.Results, func(item SearchResultItem, index int) string { return item.GeneratedBy }), query)
+		}
+		_, err := parseSearchFilter(url.Values{"generated": {"maybe"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("RegenerateStale", func(t *testing.T) {
+		summary.configuredPrompt, summary.answer = "summarize v2", "A new summary about taxes"
+		code, response := refresh(http.MethodPost, "doc-taxes", "stale=true")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, GeneratedTextsResponse{Documents: 1, Dropped: 1, Generated: 1}, response)
+		assert.Equal(t, map[string]string{"keywords": "taxes federation members", "summary": "A new summary about taxes"}, origins())
+		// Nothing is stale any more
+		code, response = refresh(http.MethodPost, "", "stale=true")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, GeneratedTextsResponse{}, response)
+	})
+
+	t.Run("RegenerateWithFailures", func(t *testing.T) {
+		keywords.err = errors.New("model is down")
+		defer func() { keywords.err = nil }()
+		summary.answer = "The third summary"
+		code, response := refresh(http.MethodPost, "", "")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, GeneratedTextsResponse{Documents: 1, Dropped: 1, Generated: 1, Failed: 1}, response)
+		// The texts of the failed model are kept
+		assert.Equal(t, map[string]string{"keywords": "taxes federation members", "summary": "The third summary"}, origins())
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		code, response := refresh(http.MethodDelete, "doc-taxes", "model=keywords")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, GeneratedTextsResponse{Documents: 1, Dropped: 1}, response)
+		assert.Equal(t, map[string]string{"summary": "The third summary"}, origins())
+		// Texts of models no longer configured are stale
+		delete(controller.generationModels, "summary")
+		code, response = refresh(http.MethodDelete, "", "stale=true")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, GeneratedTextsResponse{Documents: 1, Dropped: 1}, response)
+		assert.Len(t, getTexts(), 1)
+	})
+
+	t.Run("BadRequests", func(t *testing.T) {
+		code, _ := refresh(http.MethodPost, "", "model=missing")
+		assert.Equal(t, http.StatusBadRequest, code)
+		code, _ = refresh(http.MethodDelete, "", "stale=maybe")
+		assert.Equal(t, http.StatusBadRequest, code)
+		code, _ = refresh(http.MethodDelete, "doc-missing", "")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+}
+
+func TestSelectGenerationModels(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+	summary := &fakeGenerationModel{answer: "A summary"}
+	keywords := &fakeGenerationModel{answer: "some keywords"}
+	controller.generationModels = map[string]models.GenerationModel{"summary": summary, "keywords": keywords}
+
+	e := echo.New()
+	newDocument := func(id string, aiGen string) int {
+		reqBody, err := json.Marshal(NewDocumentParams{ID: id, Title: "Taxes", Description: "About taxes", Data: map[string]any{"category": "law"}, Texts: plainTexts("Members pay taxes")})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/doc/?ai_gen="+url.QueryEscape(aiGen), bytes.NewReader(reqBody))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.NoError(t, controller.NewDocument(e.NewContext(req, rec)))
+		return rec.Code
+	}
+	generatedBy := func(id string) []string {
+		rows, err := controller.queries.ListTextChunksByDocumentID(t.Context(), id)
+		require.NoError(t, err)
+		return lo.Compact(lo.Map(rows, func(item dao.TextChunk, index int) string { return item.GeneratedBy }))
+	}
+
+	require.Equal(t, http.StatusCreated, newDocument("doc-keywords", "keywords"))
+	assert.Equal(t, []string{"keywords"}, generatedBy("doc-keywords"))
+	// The document is given to the system prompt template
+	assert.Equal(t, models.GenerationDocument{Title: "Taxes", Description: "About taxes", Data: map[string]any{"category": "law"}}, keywords.document)
+
+	require.Equal(t, http.StatusCreated, newDocument("doc-both", " summary, keywords ,"))
+	assert.Equal(t, []string{"keywords", "summary"}, generatedBy("doc-both"))
+	require.Equal(t, http.StatusCreated, newDocument("doc-all", "true"))
+	assert.Equal(t, []string{"keywords", "summary"}, generatedBy("doc-all"))
+	require.Equal(t, http.StatusCreated, newDocument("doc-none", "false"))
+	assert.Empty(t, generatedBy("doc-none"))
+	assert.Equal(t, http.StatusBadRequest, newDocument("doc-missing", "summary,missing"))
+}
+
+// gatedGenerationModel generates after the gate is closed, or fails when the context is cancelled.
+// With drain, the cancelled generations fail after it's closed, like a model slow to stop.
+type gatedGenerationModel struct {
+	*fakeGenerationModel
+	gate  chan struct{}
+	drain chan struct{}
+	lock  sync.Mutex
+	// running and generated are the numbers of generations of each document by the title
+	running    map[string]int
+	generated  map[string]int
+	overlapped bool
+}
+
+func (g *gatedGenerationModel) Generate(ctx context.Context, document models.GenerationDocument, texts []string) (string, error) {
+	g.lock.Lock()
+	if g.running == nil {
+		g.running, g.generated = make(map[string]int), make(map[string]int)
+	}
+	g.running[document.Title]++
+	g.overlapped = g.overlapped || g.running[document.Title] > 1
+	g.lock.Unlock()
+	defer func() {
+		g.lock.Lock()
+		g.running[document.Title]--
+		g.lock.Unlock()
+	}()
+	select {
+	case <-g.gate:
+		g.lock.Lock()
+		g.generated[document.Title]++
+		g.lock.Unlock()
+		return g.answer, nil
+	case <-ctx.Done():
+		if g.drain != nil {
+			<-g.drain
+		}
+		return "", ctx.Err()
+	}
+}
+
+// stats returns the number of running generations, the generations of each document and whether any document is
+// generated by more than one call at the same time
+func (g *gatedGenerationModel) stats() (int, map[string]int, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return lo.Sum(lo.Values(g.running)), maps.Clone(g.generated), g.overlapped
+}
+
+// reset clears the stats
+func (g *gatedGenerationModel) reset() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.running, g.generated, g.overlapped = make(map[string]int), make(map[string]int), false
+}
+
+func TestJobs(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+	// Connections to an in-memory database don't share it, the job workers must use the same one
+	db.SetMaxOpenConns(1)
+	gated := &gatedGenerationModel{fakeGenerationModel: &fakeGenerationModel{answer: "gated summary"}, gate: make(chan struct{})}
+	controller.generationModels = map[string]models.GenerationModel{
+		"summary": &fakeGenerationModel{answer: "A summary", configuredPrompt: "summarize"},
+		"broken":  &fakeGenerationModel{err: errors.New("model is down")},
+		"gated":   gated,
+	}
+
+	e := echo.New()
+	for _, id := range []string{"doc-1", "doc-2", "doc-3"} {
+		postDocument(t, controller, NewDocumentParams{ID: id, Title: id, Texts: plainTexts("Members of the federation pay taxes " + id)})
+	}
+
+	startJob := func(params JobParams) (int, Job) {
+		reqBody, err := json.Marshal(params)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/jobs", bytes.NewReader(reqBody))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.NoError(t, controller.StartJob(e.NewContext(req, rec)))
+		var job Job
+		if rec.Code == http.StatusAccepted {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &job))
+		}
+		return rec.Code, job
+	}
+	callJob := func(handler echo.HandlerFunc, jobId string) (int, Job) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/jobs/"+jobId, nil)
+		rec := httptest.NewRecorder()
+		echoCtx := e.NewContext(req, rec)
+		echoCtx.SetPathValues(echo.PathValues{{Name: "job_id", Value: jobId}})
+		require.NoError(t, handler(echoCtx))
+		var job Job
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &job))
+		}
+		return rec.Code, job
+	}
+	waitJob := func(jobId string, status string) Job {
+		var job Job
+		require.Eventually(t, func() bool {
+			code, current := callJob(controller.GetJob, jobId)
+			require.Equal(t, http.StatusOK, code)
+			job = current
+			return job.Status == status
+		}, 10*time.Second, 10*time.Millisecond)
+		return job
+	}
+	generatedBy := func(id string) []string {
+		rows, err := controller.queries.ListTextChunksByDocumentID(t.Context(), id)
+		require.NoError(t, err)
+		return lo.Compact(lo.Map(rows, func(item dao.TextChunk, index int) string { return item.GeneratedBy }))
+	}
+
+	t.Run("InvalidParams", func(t *testing.T) {
+		code, _ := startJob(JobParams{Models: []string{"missing"}})
+		assert.Equal(t, http.StatusBadRequest, code)
+		code, _ = startJob(JobParams{Concurrency: maxJobConcurrency + 1})
+		assert.Equal(t, http.StatusBadRequest, code)
+		code, _ = callJob(controller.GetJob, "missing")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("Complete", func(t *testing.T) {
+		code, job := startJob(JobParams{Models: []string{"summary"}})
+		require.Equal(t, http.StatusAccepted, code)
+		assert.Equal(t, JobKindRegenerate, job.Kind)
+		assert.Equal(t, int64(3), job.Total)
+		assert.Equal(t, defaultJobConcurrency, job.Params.Concurrency)
+		job = waitJob(job.ID, JobStatusCompleted)
+		assert.Equal(t, int64(3), job.Processed)
+		assert.Equal(t, int64(3), job.Generated)
+		assert.Zero(t, job.Failed)
+		assert.Empty(t, job.Failures)
+		assert.Equal(t, []string{"summary"}, generatedBy("doc-1"))
+
+		// The generated texts are replaced rather than added again
+		_, job = startJob(JobParams{Models: []string{"summary"}, Concurrency: 3})
+		job = waitJob(job.ID, JobStatusCompleted)
+		assert.Equal(t, int64(3), job.Dropped)
+		assert.Equal(t, int64(3), job.Generated)
+		assert.Equal(t, []string{"summary"}, generatedBy("doc-1"))
+	})
+
+	t.Run("Failures", func(t *testing.T) {
+		_, job := startJob(JobParams{Models: []string{"broken"}})
+		job = waitJob(job.ID, JobStatusCompleted)
+		assert.Equal(t, int64(3), job.Processed)
+		assert.Equal(t, int64(3), job.Failed)
+		require.Len(t, job.Failures, 3)
+		assert.Contains(t, job.Failures[0].Error, "model is down")
+		assert.Equal(t, []string{"summary"}, generatedBy("doc-1"))
+	})
+
+	t.Run("PauseAndResume", func(t *testing.T) {
+		_, job := startJob(JobParams{Models: []string{"gated"}, Concurrency: 1})
+		code, job := callJob(controller.PauseJob, job.ID)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, JobStatusPaused, job.Status)
+		code, _ = callJob(controller.PauseJob, job.ID)
+		assert.Equal(t, http.StatusConflict, code)
+		// The stopped generations are kept pending
+		time.Sleep(100 * time.Millisecond)
+		code, job = callJob(controller.GetJob, job.ID)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, JobStatusPaused, job.Status)
+		assert.Zero(t, job.Processed)
+
+		code, job = callJob(controller.ResumeJob, job.ID)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, JobStatusRunning, job.Status)
+		close(gated.gate)
+		job = waitJob(job.ID, JobStatusCompleted)
+		assert.Equal(t, int64(3), job.Processed)
+		assert.Zero(t, job.Failed)
+		assert.Equal(t, []string{"summary", "gated"}, generatedBy("doc-1"))
+		gated.gate = make(chan struct{})
+	})
+
+	t.Run("ResumeWhileStopping", func(t *testing.T) {
+		gated.reset()
+		gated.drain = make(chan struct{})
+		defer func() { gated.drain = nil }()
+		_, job := startJob(JobParams{Models: []string{"gated"}, Concurrency: 3})
+		require.Eventually(t, func() bool {
+			running, _, _ := gated.stats()
+			return running == 3
+		}, 10*time.Second, 10*time.Millisecond)
+		code, _ := callJob(controller.PauseJob, job.ID)
+		require.Equal(t, http.StatusOK, code)
+		// Resumed before the stopped generations exit, the new run waits for them
+		code, _ = callJob(controller.ResumeJob, job.ID)
+		require.Equal(t, http.StatusOK, code)
+		time.Sleep(100 * time.Millisecond)
+		close(gated.drain)
+		close(gated.gate)
+		job = waitJob(job.ID, JobStatusCompleted)
+		assert.Equal(t, int64(3), job.Processed)
+		assert.Equal(t, int64(3), job.Generated)
+		_, generated, overlapped := gated.stats()
+		assert.Equal(t, map[string]int{"doc-1": 1, "doc-2": 1, "doc-3": 1}, generated)
+		assert.False(t, overlapped)
+		assert.Equal(t, []string{"summary", "gated"}, generatedBy("doc-1"))
+		gated.gate = make(chan struct{})
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		_, job := startJob(JobParams{Models: []string{"gated"}})
+		code, job := callJob(controller.CancelJob, job.ID)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, JobStatusCancelled, job.Status)
+		code, _ = callJob(controller.ResumeJob, job.ID)
+		assert.Equal(t, http.StatusConflict, code)
+		code, _ = callJob(controller.CancelJob, job.ID)
+		assert.Equal(t, http.StatusConflict, code)
+	})
+
+	t.Run("ResumeAfterRestart", func(t *testing.T) {
+		_, job := startJob(JobParams{Models: []string{"gated"}})
+		// Shutting down stops the job and keeps it running to resume
+		controller.jobs.close()
+		row, err := controller.queries.GetJob(t.Context(), job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, JobStatusRunning, row.Status)
+		assert.Zero(t, row.Processed)
+
+		close(gated.gate)
+		require.NoError(t, controller.resumeJobs(t.Context()))
+		job = waitJob(job.ID, JobStatusCompleted)
+		assert.Equal(t, int64(3), job.Processed)
+		assert.Equal(t, int64(3), job.Dropped)
+		assert.Equal(t, int64(3), job.Generated)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/jobs", nil)
+		rec := httptest.NewRecorder()
+		require.NoError(t, controller.ListJobs(e.NewContext(req, rec)))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var jobs []Job
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &jobs))
+		assert.Len(t, jobs, 7)
+	})
+}
+
+func TestExtractFields(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+	outputSchema, err := models.NewOutputSchema(map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"category": map[string]any{"type": "string", "enum": []any{"law", "news"}},
+			"tags":     map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		},
+		"required": []any{"category", "tags"},
+	})
+	require.NoError(t, err)
+	classify := &fakeGenerationModel{answer: `{"category": "law", "tags": ["tax", "federation"]}`, outputSchema: outputSchema}
+	invalid := &fakeGenerationModel{answer: `{"category": "sports", "tags": []}`, outputSchema: outputSchema}
+	summary := &fakeGenerationModel{answer: "A summary"}
+	controller.generationModels = map[string]models.GenerationModel{"classify": classify, "invalid": invalid, "summary": summary}
+
+	e := echo.New()
+	newDocument := func(id string, aiGen string) {
+		reqBody, err := json.Marshal(NewDocumentParams{ID: id, Title: id, Data: map[string]any{"source": "web", "category": "unknown"}, Texts: plainTexts("Members of the federation pay taxes")})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/doc/?ai_gen="+aiGen, bytes.NewReader(reqBody))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.NoError(t, controller.NewDocument(e.NewContext(req, rec)))
+		require.Equal(t, http.StatusCreated, rec.Code)
+	}
+	getDocument := func(id string) DocumentWithChunks {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/doc/"+id+"?with_texts=true", nil)
+		rec := httptest.NewRecorder()
+		echoCtx := e.NewContext(req, rec)
+		echoCtx.SetPathValues(echo.PathValues{{Name: "doc_id", Value: id}})
+		require.NoError(t, controller.GetDocument(echoCtx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var document DocumentWithChunks
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &document))
+		return document
+	}
+
+	newDocument("doc-classified", "classify,summary")
+	newDocument("doc-invalid", "invalid")
+
+	t.Run("Merge", func(t *testing.T) {
+		document := getDocument("doc-classified")
+		assert.Equal(t, map[string]any{"source": "web", "category": "law", "tags": []any{"tax", "federation"}}, document.Data)
+		// The fields are not texts
+		require.Len(t, document.Texts, 2)
+		assert.Equal(t, "summary", document.Texts[1].Origin.Model)
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		document := getDocument("doc-invalid")
+		assert.Equal(t, map[string]any{"source": "web", "category": "unknown"}, document.Data)
+		assert.Len(t, document.Texts, 1)
+	})
+
+	t.Run("Filter", func(t *testing.T) {
+		for query, expected := range map[string][]string{
+			"q=taxes&data.tags=tax":                              {"doc-classified"},
+			"q=taxes&data.tags=law":                              {},
+			"q=taxes&data.category=law":                          {"doc-classified"},
+			"q=taxes&data.category=law&data.category=unknown":    {"doc-classified", "doc-invalid"},
+			"q=" + url.QueryEscape("taxes data.tags:federation"): {"doc-classified"},
+		} {
+			results := searchBM25(t, controller, query).Results
+			assert.ElementsMatch(t, expected, lo.Uniq(lo.Map(results, func(item SearchResultItem, index int) string { return item.DocumentID })), query)
+		}
+	})
+
+	t.Run("Regenerate", func(t *testing.T) {
+		classify.answer = `{"category": "news", "tags": ["tax"]}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/doc/doc-classified/generated?model=classify", nil)
+		rec := httptest.NewRecorder()
+		echoCtx := e.NewContext(req, rec)
+		echoCtx.SetPathValues(echo.PathValues{{Name: "doc_id", Value: "doc-classified"}})
+		require.NoError(t, controller.RegenerateTexts(echoCtx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var response GeneratedTextsResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		assert.Equal(t, GeneratedTextsResponse{Documents: 1, Extracted: 1}, response)
+		document := getDocument("doc-classified")
+		assert.Equal(t, map[string]any{"source": "web", "category": "news", "tags": []any{"tax"}}, document.Data)
+		assert.Len(t, document.Texts, 2)
+	})
+}
+
+func TestFacets(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+
+	for _, document := range []struct {
+		id        string
+		data      map[string]any
+		createdAt string
+	}{
+		{"doc-law-1", map[string]any{"category": "law", "tags": []any{"tax", "federation"}}, "2024-01-05"},
+		{"doc-law-2", map[string]any{"category": "law", "tags": []any{"tax"}}, "2024-01-20"},
+		{"doc-news", map[string]any{"category": "news", "tags": []any{"election"}, "rank": 1}, "2024-03-01"},
+		{"doc-empty", nil, "2024-03-02"},
+	} {
+		postDocument(t, controller, NewDocumentParams{ID: document.id, Title: document.id, Data: document.data, Texts: plainTexts("Members of the federation pay taxes", "Taxes are collected yearly")})
+		_, err := db.Exec("UPDATE document SET created_at = unixepoch(?) WHERE id = ?", document.createdAt, document.id)
+		require.NoError(t, err)
+	}
+
+	t.Run("Counts", func(t *testing.T) {
+		response := searchBM25(t, controller, "q=taxes&n=1&facets=category,tags&facets=rank&histogram=month")
+		// Facets count all matched documents rather than the returned results
+		assert.Len(t, response.Results, 1)
+		require.NotNil(t, response.Facets)
+		assert.Equal(t, map[string][]FacetCount{
+			"category": {{Value: "law", Count: 2}, {Value: "news", Count: 1}},
+			"tags":     {{Value: "tax", Count: 2}, {Value: "election", Count: 1}, {Value: "federation", Count: 1}},
+			"rank":     {{Value: "1", Count: 1}},
+		}, response.Facets.Data)
+		assert.Equal(t, []HistogramBucket{
+			{Start: 1704067200, Key: "2024-01-01", Count: 2},
+			{Start: 1709251200, Key: "2024-03-01", Count: 2},
+		}, response.Facets.CreatedAt)
+	})
+
+	t.Run("Filtered", func(t *testing.T) {
+		response := searchBM25(t, controller, "q=taxes&facets=tags&facet_size=1&data.category=law&histogram=week")
+		assert.Equal(t, map[string][]FacetCount{"tags": {{Value: "tax", Count: 2}}}, response.Facets.Data)
+		// Weeks start on Monday
+		assert.Equal(t, []HistogramBucket{
+			{Start: 1704067200, Key: "2024-01-01", Count: 1},
+			{Start: 1705276800, Key: "2024-01-15", Count: 1},
+		}, response.Facets.CreatedAt)
+
+		response = searchBM25(t, controller, "q=missing&facets=tags")
+		assert.Equal(t, map[string][]FacetCount{"tags": {}}, response.Facets.Data)
+	})
+
+	t.Run("NotRequested", func(t *testing.T) {
+		response := searchBM25(t, controller, "q=taxes")
+		assert.Nil(t, response.Facets)
+	})
+
+	t.Run("InvalidParams", func(t *testing.T) {
+		for _, query := range []string{"q=taxes&histogram=hour", "q=taxes&facets=tags&facet_size=0", "q=taxes&facets=tags&facet_window=-1", "q=taxes&facets=" + url.QueryEscape(`"tags`)} {
+			code, _ := searchBM25Status(t, controller, query)
+			assert.Equal(t, http.StatusBadRequest, code, query)
+		}
+	})
+}
+
+// countingEmbeddingModel embeds texts by their lengths and counts the embedded texts
+type countingEmbeddingModel struct {
+	embedded map[string]int
+}
+
+func (m *countingEmbeddingModel) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return lo.Map(texts, func(item string, index int) []float32 {
+		m.embedded[item]++
+		return []float32{1, float32(len(item) % 7), 0.5}
+	}), nil
+}
+
+func TestFacetsWithEmbeddingModel(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(GetDDL())
+	require.NoError(t, err)
+	model := &countingEmbeddingModel{embedded: make(map[string]int)}
+	controller, err := NewController(db, map[string]models.BaseEmbeddingModel{"counting": model}, t.TempDir(), nil, Options{})
+	require.NoError(t, err)
+
+	e := echo.New()
+	for _, document := range []struct {
+		id       string
+		category string
+	}{{"doc-law", "law"}, {"doc-news", "news"}} {
+		postDocument(t, controller, NewDocumentParams{ID: document.id, Title: document.id, Data: map[string]any{"category": document.category}, Texts: plainTexts("Members of the federation pay taxes")})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/search/counting?q=taxes&facets=category", nil)
+	rec := httptest.NewRecorder()
+	echoCtx := e.NewContext(req, rec)
+	echoCtx.SetPathValues(echo.PathValues{{Name: "model_id", Value: "counting"}})
+	require.NoError(t, controller.Search(echoCtx))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var response SearchResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	require.NotNil(t, response.Facets)
+	assert.Equal(t, map[string][]FacetCount{"category": {{Value: "law", Count: 1}, {Value: "news", Count: 1}}}, response.Facets.Data)
+	// The query embedding of retrieval is reused by facets
+	assert.Equal(t, 1, model.embedded["taxes"])
+}
+
+func TestRanking(t *testing.T) {
+	controller, db := setupTestController(t)
+	defer db.Close()
+
+	now := time.Now()
+	for _, document := range []struct {
+		id        string
+		data      map[string]any
+		createdAt time.Time
+	}{
+		{"doc-old", map[string]any{"rating": 5}, now.AddDate(-5, 0, 0)},
+		{"doc-new", map[string]any{"rating": 0}, now.AddDate(0, 0, -1)},
+		{"doc-mid", map[string]any{"rating": "high"}, now.AddDate(0, 0, -60)},
+	} {
+		postDocument(t, controller, NewDocumentParams{ID: document.id, Title: document.id, Data: document.data, Texts: plainTexts("Members of the federation pay taxes")})
+		_, err := db.Exec("UPDATE document SET created_at = ? WHERE id = ?", document.createdAt.Unix(), document.id)
+		require.NoError(t, err)
+	}
+	documentIDs := func(results []SearchResultItem) []string {
+		return lo.Map(results, func(item SearchResultItem, index int) string { return item.DocumentID })
+	}
+
+	t.Run("Decay", func(t *testing.T) {
+		results := searchBM25(t, controller, "q=taxes&decay=30d").Results
+		// The relevance of the same texts ties, recent documents win
+		assert.Equal(t, []string{"doc-new", "doc-mid", "doc-old"}, documentIDs(results))
+		assert.InDelta(t, math.Exp2(-1.0/30), results[0].Score, 0.001)
+		assert.InDelta(t, 0.25, results[1].Score, 0.001)
+	})
+
+	t.Run("Boost", func(t *testing.T) {
+		results := searchBM25(t, controller, "q=taxes&boost=rating&boost_weight=1").Results
+		require.Equal(t, "doc-old", results[0].DocumentID)
+		assert.InDelta(t, 6, results[0].Score, 0.001)
+		// Documents without a numeric value are not boosted
+		assert.InDelta(t, 1, results[1].Score, 0.001)
+		assert.InDelta(t, 1, results[2].Score, 0.001)
+
+		results = searchBM25(t, controller, "q=taxes&boost=rating&boost_weight=0.1&decay=30d&n=1").Results
+		assert.Equal(t, []string{"doc-new"}, documentIDs(results))
+	})
+
+	t.Run("Pin", func(t *testing.T) {
+		results := searchBM25(t, controller, "q=taxes&decay=30d&pin=doc-old,missing&pin=doc-mid").Results
+		assert.Equal(t, []string{"doc-old", "doc-mid", "doc-new"}, documentIDs(results))
+	})
+
+	t.Run("Profiles", func(t *testing.T) {
+		controller.options.RankingProfiles = map[string]RankingProfile{
+			"recent": {DecayHalfLife: 30 * 24 * time.Hour},
+			"rated":  {BoostField: "rating", BoostWeight: 1},
+		}
+		results := searchBM25(t, controller, "q=taxes&ranking=recent").Results
+		assert.Equal(t, []string{"doc-new", "doc-mid", "doc-old"}, documentIDs(results))
+		// Parameters override the profile
+		results = searchBM25(t, controller, "q=taxes&ranking=recent&pin=doc-old").Results
+		assert.Equal(t, []string{"doc-old", "doc-new", "doc-mid"}, documentIDs(results))
+
+		controller.options.DefaultRanking = "rated"
+		defer func() { controller.options.DefaultRanking = "" }()
+		results = searchBM25(t, controller, "q=taxes").Results
+		assert.Equal(t, "doc-old", results[0].DocumentID)
+		assert.InDelta(t, 6, results[0].Score, 0.001)
+		// The scores of BM25 are kept without ranking
+		results = searchBM25(t, controller, "q=taxes&ranking=none").Results
+		assert.Negative(t, results[0].Score)
+	})
+
+	t.Run("InvalidParams", func(t *testing.T) {
+		for _, query := range []string{"q=taxes&ranking=missing", "q=taxes&decay=soon", "q=taxes&decay=-1h", "q=taxes&boost=rating&boost_weight=x", "q=taxes&boost=" + url.QueryEscape(`"rating`)} {
+			code, _ := searchBM25Status(t, controller, query)
+			assert.Equal(t, http.StatusBadRequest, code, query)
+		}
+		_, err := NewController(db, make(map[string]models.BaseEmbeddingModel), t.TempDir(), nil, Options{DefaultRanking: "missing"})
+		assert.Error(t, err)
+		_, err = NewController(db, make(map[string]models.BaseEmbeddingModel), t.TempDir(), nil, Options{RankingProfiles: map[string]RankingProfile{"invalid": {Candidates: maxRerankCandidates + 1}}})
+		assert.Error(t, err)
+	})
+
+	t.Run("ParseHalfLife", func(t *testing.T) {
+		for value, expected := range map[string]time.Duration{"30d": 30 * 24 * time.Hour, "0.5d": 12 * time.Hour, "72h": 72 * time.Hour} {
+			halfLife, err := ParseHalfLife(value)
+			require.NoError(t, err)
+			assert.Equal(t, expected, halfLife)
+		}
+		_, err := ParseHalfLife("d")
+		assert.Error(t, err)
+	})
+}
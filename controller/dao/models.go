@@ -10,14 +10,59 @@ type Document struct {
 	Description string
 	Data        string
 	CreatedAt   int64
+	SimHash     int64
+	DuplicateOf string
 }
 
-type TextChunk struct {
-	ID         string
+type Job struct {
+	ID        string
+	Kind      string
+	Status    string
+	Params    string
+	Total     int64
+	Processed int64
+	Failed    int64
+	Dropped   int64
+	Generated int64
+	Error     string
+	CreatedAt int64
+	UpdatedAt int64
+}
+
+type JobDocument struct {
+	JobID      string
 	DocumentID string
-	Content    string
-	SegContent string
-	CreatedAt  int64
+	Status     string
+	Error      string
+}
+
+type Metum struct {
+	Key   string
+	Value string
+}
+
+type SearchQuery struct {
+	Query          string
+	Count          int64
+	LastSearchedAt int64
+}
+
+type TextChunk struct {
+	ID               string
+	DocumentID       string
+	Content          string
+	SegContent       string
+	CreatedAt        int64
+	Position         int64
+	Metadata         string
+	ContentHash      string
+	DuplicateOf      string
+	SimHash          int64
+	Language         string
+	LanguageDetected bool
+	GeneratedBy      string
+	PromptHash       string
+	GeneratedAt      int64
 }
 
 type TextChunkFt struct {
@@ -9,6 +9,113 @@ import (
 	"context"
 )
 
+const addJobProgress = `-- name: AddJobProgress :exec
+UPDATE job
+SET processed  = processed + ?,
+    failed     = failed + ?,
+    dropped    = dropped + ?,
+    generated  = generated + ?,
+    updated_at = strftime('%s', 'now')
+WHERE id = ?
+`
+
+type AddJobProgressParams struct {
+	Processed int64
+	Failed    int64
+	Dropped   int64
+	Generated int64
+	ID        string
+}
+
+func (q *Queries) AddJobProgress(ctx context.Context, arg AddJobProgressParams) error {
+	_, err := q.db.ExecContext(ctx, addJobProgress,
+		arg.Processed,
+		arg.Failed,
+		arg.Dropped,
+		arg.Generated,
+		arg.ID,
+	)
+	return err
+}
+
+const clearDuplicateOfByDocumentID = `-- name: ClearDuplicateOfByDocumentID :exec
+UPDATE text_chunk
+SET duplicate_of = ''
+WHERE duplicate_of IN (SELECT id
+                       FROM text_chunk tc
+                       WHERE tc.document_id = ?)
+`
+
+func (q *Queries) ClearDuplicateOfByDocumentID(ctx context.Context, documentID string) error {
+	_, err := q.db.ExecContext(ctx, clearDuplicateOfByDocumentID, documentID)
+	return err
+}
+
+const clearDuplicateOfByTextChunkID = `-- name: ClearDuplicateOfByTextChunkID :exec
+UPDATE text_chunk
+SET duplicate_of = ''
+WHERE duplicate_of = ?
+`
+
+func (q *Queries) ClearDuplicateOfByTextChunkID(ctx context.Context, duplicateOf string) error {
+	_, err := q.db.ExecContext(ctx, clearDuplicateOfByTextChunkID, duplicateOf)
+	return err
+}
+
+const clearStaleDocumentDuplicateOf = `-- name: ClearStaleDocumentDuplicateOf :exec
+UPDATE document
+SET duplicate_of = ''
+WHERE duplicate_of != ''
+  AND (id = ?1 OR duplicate_of = ?1)
+  AND (EXISTS (SELECT content_hash
+               FROM text_chunk
+               WHERE document_id = document.id
+                 AND generated_by = ''
+               EXCEPT
+               SELECT content_hash
+               FROM text_chunk
+               WHERE document_id = document.duplicate_of
+                 AND generated_by = '')
+    OR EXISTS (SELECT content_hash
+               FROM text_chunk
+               WHERE document_id = document.duplicate_of
+                 AND generated_by = ''
+               EXCEPT
+               SELECT content_hash
+               FROM text_chunk
+               WHERE document_id = document.id
+                 AND generated_by = ''))
+`
+
+func (q *Queries) ClearStaleDocumentDuplicateOf(ctx context.Context, id string) error {
+	_, err := q.db.ExecContext(ctx, clearStaleDocumentDuplicateOf, id)
+	return err
+}
+
+const clearStaleDuplicateOf = `-- name: ClearStaleDuplicateOf :exec
+UPDATE text_chunk
+SET duplicate_of = ''
+WHERE duplicate_of != ''
+  AND content_hash IS NOT (SELECT original.content_hash
+                           FROM text_chunk original
+                           WHERE original.id = text_chunk.duplicate_of)
+`
+
+func (q *Queries) ClearStaleDuplicateOf(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, clearStaleDuplicateOf)
+	return err
+}
+
+const deleteAllTextChunkFTS = `-- name: DeleteAllTextChunkFTS :exec
+DELETE
+FROM text_chunk_fts
+`
+
+func (q *Queries) DeleteAllTextChunkFTS(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteAllTextChunkFTS)
+	return err
+}
+
 const deleteDocument = `-- name: DeleteDocument :exec
 DELETE
 FROM document
@@ -55,6 +162,18 @@ func (q *Queries) DeleteTextChunkFTSByID(ctx context.Context, id string) error {
 	return err
 }
 
+const deleteTextChunkFTSByIDs = `-- name: DeleteTextChunkFTSByIDs :exec
+DELETE
+FROM text_chunk_fts
+WHERE id IN (SELECT value
+             FROM json_each(?))
+`
+
+func (q *Queries) DeleteTextChunkFTSByIDs(ctx context.Context, ids interface{}) error {
+	_, err := q.db.ExecContext(ctx, deleteTextChunkFTSByIDs, ids)
+	return err
+}
+
 const deleteTextChunksByDocumentID = `-- name: DeleteTextChunksByDocumentID :exec
 DELETE
 FROM text_chunk
@@ -90,6 +209,31 @@ func (q *Queries) DeleteTextEmbeddingsByTextChunkID(ctx context.Context, textChu
 	return err
 }
 
+const finishJobDocument = `-- name: FinishJobDocument :exec
+UPDATE job_document
+SET status = ?,
+    error  = ?
+WHERE job_id = ?
+  AND document_id = ?
+`
+
+type FinishJobDocumentParams struct {
+	Status     string
+	Error      string
+	JobID      string
+	DocumentID string
+}
+
+func (q *Queries) FinishJobDocument(ctx context.Context, arg FinishJobDocumentParams) error {
+	_, err := q.db.ExecContext(ctx, finishJobDocument,
+		arg.Status,
+		arg.Error,
+		arg.JobID,
+		arg.DocumentID,
+	)
+	return err
+}
+
 const getAllEmbeddingsByModelID = `-- name: GetAllEmbeddingsByModelID :many
 SELECT text_chunk_id, vector
 FROM text_embedding
@@ -125,7 +269,7 @@ func (q *Queries) GetAllEmbeddingsByModelID(ctx context.Context, modelID string)
 }
 
 const getDocument = `-- name: GetDocument :one
-SELECT id, title, description, data, created_at
+SELECT id, title, description, data, created_at, simhash, duplicate_of
 FROM document
 WHERE id = ? LIMIT 1
 `
@@ -139,12 +283,131 @@ func (q *Queries) GetDocument(ctx context.Context, id string) (Document, error)
 		&i.Description,
 		&i.Data,
 		&i.CreatedAt,
+		&i.SimHash,
+		&i.DuplicateOf,
+	)
+	return i, err
+}
+
+const getDuplicateDocumentID = `-- name: GetDuplicateDocumentID :one
+SELECT tc.document_id
+FROM text_chunk tc
+         JOIN document d ON d.id = tc.document_id
+WHERE tc.generated_by = ''
+  AND tc.document_id != ?
+  AND tc.document_id IN (SELECT document_id
+                         FROM text_chunk
+                         WHERE content_hash = ?)
+GROUP BY tc.document_id
+HAVING COUNT(DISTINCT tc.content_hash) = ?
+   AND SUM(tc.content_hash IN (SELECT value FROM json_each(?))) = COUNT(*)
+ORDER BY MAX(d.duplicate_of) != '', MIN(d.created_at), tc.document_id LIMIT 1
+`
+
+type GetDuplicateDocumentIDParams struct {
+	ID            string
+	ContentHash   string
+	HashCount     int64
+	ContentHashes interface{}
+}
+
+func (q *Queries) GetDuplicateDocumentID(ctx context.Context, arg GetDuplicateDocumentIDParams) (string, error) {
+	row := q.db.QueryRowContext(ctx, getDuplicateDocumentID,
+		arg.ID,
+		arg.ContentHash,
+		arg.HashCount,
+		arg.ContentHashes,
+	)
+	var document_id string
+	err := row.Scan(&document_id)
+	return document_id, err
+}
+
+const getJob = `-- name: GetJob :one
+SELECT id, kind, status, params, total, processed, failed, dropped, generated, error, created_at, updated_at
+FROM job
+WHERE id = ? LIMIT 1
+`
+
+func (q *Queries) GetJob(ctx context.Context, id string) (Job, error) {
+	row := q.db.QueryRowContext(ctx, getJob, id)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.Status,
+		&i.Params,
+		&i.Total,
+		&i.Processed,
+		&i.Failed,
+		&i.Dropped,
+		&i.Generated,
+		&i.Error,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getMeta = `-- name: GetMeta :one
+SELECT value
+FROM meta
+WHERE key = ?
+`
+
+func (q *Queries) GetMeta(ctx context.Context, key string) (string, error) {
+	row := q.db.QueryRowContext(ctx, getMeta, key)
+	var value string
+	err := row.Scan(&value)
+	return value, err
+}
+
+const getNextTextChunkPosition = `-- name: GetNextTextChunkPosition :one
+SELECT CAST(COALESCE(MAX(position) + 1, 0) AS INTEGER) AS next_position
+FROM text_chunk
+WHERE document_id = ?
+`
+
+func (q *Queries) GetNextTextChunkPosition(ctx context.Context, documentID string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getNextTextChunkPosition, documentID)
+	var next_position int64
+	err := row.Scan(&next_position)
+	return next_position, err
+}
+
+const getOriginalTextChunkByContentHash = `-- name: GetOriginalTextChunkByContentHash :one
+SELECT id, document_id, content, seg_content, created_at, position, metadata, content_hash, duplicate_of, simhash, language, language_detected, generated_by, prompt_hash, generated_at
+FROM text_chunk
+WHERE content_hash = ?
+  AND duplicate_of = ''
+ORDER BY created_at, id LIMIT 1
+`
+
+func (q *Queries) GetOriginalTextChunkByContentHash(ctx context.Context, contentHash string) (TextChunk, error) {
+	row := q.db.QueryRowContext(ctx, getOriginalTextChunkByContentHash, contentHash)
+	var i TextChunk
+	err := row.Scan(
+		&i.ID,
+		&i.DocumentID,
+		&i.Content,
+		&i.SegContent,
+		&i.CreatedAt,
+		&i.Position,
+		&i.Metadata,
+		&i.ContentHash,
+		&i.DuplicateOf,
+		&i.SimHash,
+		&i.Language,
+		&i.LanguageDetected,
+		&i.GeneratedBy,
+		&i.PromptHash,
+		&i.GeneratedAt,
 	)
 	return i, err
 }
 
 const getTextChunk = `-- name: GetTextChunk :one
-SELECT id, document_id, content, seg_content, created_at
+SELECT id, document_id, content, seg_content, created_at, position, metadata, content_hash, duplicate_of, simhash, language, language_detected, generated_by, prompt_hash, generated_at
 FROM text_chunk
 WHERE id = ? LIMIT 1
 `
@@ -158,10 +421,31 @@ func (q *Queries) GetTextChunk(ctx context.Context, id string) (TextChunk, error
 		&i.Content,
 		&i.SegContent,
 		&i.CreatedAt,
+		&i.Position,
+		&i.Metadata,
+		&i.ContentHash,
+		&i.DuplicateOf,
+		&i.SimHash,
+		&i.Language,
+		&i.LanguageDetected,
+		&i.GeneratedBy,
+		&i.PromptHash,
+		&i.GeneratedAt,
 	)
 	return i, err
 }
 
+const insertAllTextChunkFTS = `-- name: InsertAllTextChunkFTS :exec
+INSERT INTO text_chunk_fts (id, seg_content)
+SELECT id, seg_content
+FROM text_chunk
+`
+
+func (q *Queries) InsertAllTextChunkFTS(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, insertAllTextChunkFTS)
+	return err
+}
+
 const insertTextChunkFTS = `-- name: InsertTextChunkFTS :exec
 INSERT INTO text_chunk_fts (id, seg_content)
 VALUES (?, ?)
@@ -177,14 +461,68 @@ func (q *Queries) InsertTextChunkFTS(ctx context.Context, arg InsertTextChunkFTS
 	return err
 }
 
-const listTextChunkIdByDocumentID = `-- name: ListTextChunkIdByDocumentID :many
-SELECT id
+const insertTextChunkFTSByIDs = `-- name: InsertTextChunkFTSByIDs :exec
+INSERT INTO text_chunk_fts (id, seg_content)
+SELECT id, seg_content
 FROM text_chunk
-WHERE document_id = ?
+WHERE id IN (SELECT value
+             FROM json_each(?))
 `
 
-func (q *Queries) ListTextChunkIdByDocumentID(ctx context.Context, documentID string) ([]string, error) {
-	rows, err := q.db.QueryContext(ctx, listTextChunkIdByDocumentID, documentID)
+func (q *Queries) InsertTextChunkFTSByIDs(ctx context.Context, ids interface{}) error {
+	_, err := q.db.ExecContext(ctx, insertTextChunkFTSByIDs, ids)
+	return err
+}
+
+const listAllTextChunkContents = `-- name: ListAllTextChunkContents :many
+SELECT id, document_id, content, language, language_detected
+FROM text_chunk
+`
+
+type ListAllTextChunkContentsRow struct {
+	ID               string
+	DocumentID       string
+	Content          string
+	Language         string
+	LanguageDetected bool
+}
+
+func (q *Queries) ListAllTextChunkContents(ctx context.Context) ([]ListAllTextChunkContentsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listAllTextChunkContents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListAllTextChunkContentsRow
+	for rows.Next() {
+		var i ListAllTextChunkContentsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.DocumentID,
+			&i.Content,
+			&i.Language,
+			&i.LanguageDetected,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDocumentIDs = `-- name: ListDocumentIDs :many
+SELECT id
+FROM document
+`
+
+func (q *Queries) ListDocumentIDs(ctx context.Context) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listDocumentIDs)
 	if err != nil {
 		return nil, err
 	}
@@ -206,28 +544,58 @@ func (q *Queries) ListTextChunkIdByDocumentID(ctx context.Context, documentID st
 	return items, nil
 }
 
-const listTextChunkWithoutEmbeddingsByModelId = `-- name: ListTextChunkWithoutEmbeddingsByModelId :many
-SELECT id, content
-FROM text_chunk tc
-         LEFT JOIN text_embedding te ON tc.id = te.text_chunk_id AND te.model_id = ?
-WHERE te.text_chunk_id IS NULL
+const listDocumentIDsWithGeneratedTextChunks = `-- name: ListDocumentIDsWithGeneratedTextChunks :many
+SELECT DISTINCT document_id
+FROM text_chunk
+WHERE generated_by != ''
+ORDER BY document_id
 `
 
-type ListTextChunkWithoutEmbeddingsByModelIdRow struct {
+func (q *Queries) ListDocumentIDsWithGeneratedTextChunks(ctx context.Context) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listDocumentIDsWithGeneratedTextChunks)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var document_id string
+		if err := rows.Scan(&document_id); err != nil {
+			return nil, err
+		}
+		items = append(items, document_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDocumentSimHashes = `-- name: ListDocumentSimHashes :many
+SELECT id, title, simhash
+FROM document
+WHERE simhash != 0
+`
+
+type ListDocumentSimHashesRow struct {
 	ID      string
-	Content string
+	Title   string
+	SimHash int64
 }
 
-func (q *Queries) ListTextChunkWithoutEmbeddingsByModelId(ctx context.Context, modelID string) ([]ListTextChunkWithoutEmbeddingsByModelIdRow, error) {
-	rows, err := q.db.QueryContext(ctx, listTextChunkWithoutEmbeddingsByModelId, modelID)
+func (q *Queries) ListDocumentSimHashes(ctx context.Context) ([]ListDocumentSimHashesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listDocumentSimHashes)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []ListTextChunkWithoutEmbeddingsByModelIdRow
+	var items []ListDocumentSimHashesRow
 	for rows.Next() {
-		var i ListTextChunkWithoutEmbeddingsByModelIdRow
-		if err := rows.Scan(&i.ID, &i.Content); err != nil {
+		var i ListDocumentSimHashesRow
+		if err := rows.Scan(&i.ID, &i.Title, &i.SimHash); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -241,31 +609,24 @@ func (q *Queries) ListTextChunkWithoutEmbeddingsByModelId(ctx context.Context, m
 	return items, nil
 }
 
-const listTextChunksByDocumentID = `-- name: ListTextChunksByDocumentID :many
-SELECT id, document_id, content, seg_content, created_at
-FROM text_chunk
-WHERE document_id = ?
+const listDocumentTitles = `-- name: ListDocumentTitles :many
+SELECT title
+FROM document
 `
 
-func (q *Queries) ListTextChunksByDocumentID(ctx context.Context, documentID string) ([]TextChunk, error) {
-	rows, err := q.db.QueryContext(ctx, listTextChunksByDocumentID, documentID)
+func (q *Queries) ListDocumentTitles(ctx context.Context) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listDocumentTitles)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []TextChunk
+	var items []string
 	for rows.Next() {
-		var i TextChunk
-		if err := rows.Scan(
-			&i.ID,
-			&i.DocumentID,
-			&i.Content,
-			&i.SegContent,
-			&i.CreatedAt,
-		); err != nil {
+		var title string
+		if err := rows.Scan(&title); err != nil {
 			return nil, err
 		}
-		items = append(items, i)
+		items = append(items, title)
 	}
 	if err := rows.Close(); err != nil {
 		return nil, err
@@ -276,60 +637,798 @@ func (q *Queries) ListTextChunksByDocumentID(ctx context.Context, documentID str
 	return items, nil
 }
 
-const newDocument = `-- name: NewDocument :exec
-INSERT INTO document (id, title, description, data)
-VALUES (?, ?, ?, ?)
+const listDocumentsWithoutSimHash = `-- name: ListDocumentsWithoutSimHash :many
+SELECT id
+FROM document d
+WHERE d.simhash = 0
+  AND EXISTS (SELECT 1
+              FROM text_chunk tc
+              WHERE tc.document_id = d.id
+                AND tc.simhash != 0)
 `
 
-type NewDocumentParams struct {
-	ID          string
-	Title       string
-	Description string
-	Data        string
-}
-
-func (q *Queries) NewDocument(ctx context.Context, arg NewDocumentParams) error {
-	_, err := q.db.ExecContext(ctx, newDocument,
-		arg.ID,
-		arg.Title,
-		arg.Description,
-		arg.Data,
-	)
-	return err
+func (q *Queries) ListDocumentsWithoutSimHash(ctx context.Context) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listDocumentsWithoutSimHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const newTextChunk = `-- name: NewTextChunk :one
-INSERT INTO text_chunk (id, document_id, content, seg_content)
-VALUES (?, ?, ?, ?) RETURNING id, document_id, content, seg_content, created_at
+const listDuplicateContentHashes = `-- name: ListDuplicateContentHashes :many
+SELECT content_hash,
+       COUNT(*)                    AS chunk_count,
+       COUNT(DISTINCT document_id) AS document_count
+FROM text_chunk
+WHERE content_hash != ''
+GROUP BY content_hash
+HAVING COUNT(*) > 1
+   AND COUNT(DISTINCT document_id) >= ?
+ORDER BY chunk_count DESC, content_hash LIMIT ?
 `
 
-type NewTextChunkParams struct {
-	ID         string
-	DocumentID string
-	Content    string
-	SegContent string
+type ListDuplicateContentHashesParams struct {
+	DocumentCount int64
+	Limit         int64
 }
 
-func (q *Queries) NewTextChunk(ctx context.Context, arg NewTextChunkParams) (TextChunk, error) {
-	row := q.db.QueryRowContext(ctx, newTextChunk,
-		arg.ID,
-		arg.DocumentID,
-		arg.Content,
-		arg.SegContent,
-	)
-	var i TextChunk
-	err := row.Scan(
-		&i.ID,
-		&i.DocumentID,
-		&i.Content,
-		&i.SegContent,
-		&i.CreatedAt,
-	)
-	return i, err
+type ListDuplicateContentHashesRow struct {
+	ContentHash   string
+	ChunkCount    int64
+	DocumentCount int64
 }
 
-const newTextEmbedding = `-- name: NewTextEmbedding :exec
-INSERT INTO text_embedding (model_id, text_chunk_id, vector)
+func (q *Queries) ListDuplicateContentHashes(ctx context.Context, arg ListDuplicateContentHashesParams) ([]ListDuplicateContentHashesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listDuplicateContentHashes, arg.DocumentCount, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListDuplicateContentHashesRow
+	for rows.Next() {
+		var i ListDuplicateContentHashesRow
+		if err := rows.Scan(&i.ContentHash, &i.ChunkCount, &i.DocumentCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listFailedJobDocuments = `-- name: ListFailedJobDocuments :many
+SELECT document_id, error
+FROM job_document
+WHERE job_id = ?
+  AND status = 'failed'
+ORDER BY document_id
+`
+
+type ListFailedJobDocumentsRow struct {
+	DocumentID string
+	Error      string
+}
+
+func (q *Queries) ListFailedJobDocuments(ctx context.Context, jobID string) ([]ListFailedJobDocumentsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listFailedJobDocuments, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListFailedJobDocumentsRow
+	for rows.Next() {
+		var i ListFailedJobDocumentsRow
+		if err := rows.Scan(&i.DocumentID, &i.Error); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listJobIDsByStatus = `-- name: ListJobIDsByStatus :many
+SELECT id
+FROM job
+WHERE status = ?
+ORDER BY created_at, id
+`
+
+func (q *Queries) ListJobIDsByStatus(ctx context.Context, status string) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listJobIDsByStatus, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listJobs = `-- name: ListJobs :many
+SELECT id, kind, status, params, total, processed, failed, dropped, generated, error, created_at, updated_at
+FROM job
+ORDER BY created_at DESC, id
+`
+
+func (q *Queries) ListJobs(ctx context.Context) ([]Job, error) {
+	rows, err := q.db.QueryContext(ctx, listJobs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Job
+	for rows.Next() {
+		var i Job
+		if err := rows.Scan(
+			&i.ID,
+			&i.Kind,
+			&i.Status,
+			&i.Params,
+			&i.Total,
+			&i.Processed,
+			&i.Failed,
+			&i.Dropped,
+			&i.Generated,
+			&i.Error,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPendingJobDocumentIDs = `-- name: ListPendingJobDocumentIDs :many
+SELECT document_id
+FROM job_document
+WHERE job_id = ?
+  AND status = 'pending'
+ORDER BY document_id
+`
+
+func (q *Queries) ListPendingJobDocumentIDs(ctx context.Context, jobID string) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listPendingJobDocumentIDs, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var document_id string
+		if err := rows.Scan(&document_id); err != nil {
+			return nil, err
+		}
+		items = append(items, document_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSearchQueries = `-- name: ListSearchQueries :many
+SELECT query, count
+FROM search_query
+`
+
+type ListSearchQueriesRow struct {
+	Query string
+	Count int64
+}
+
+func (q *Queries) ListSearchQueries(ctx context.Context) ([]ListSearchQueriesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listSearchQueries)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListSearchQueriesRow
+	for rows.Next() {
+		var i ListSearchQueriesRow
+		if err := rows.Scan(&i.Query, &i.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTextChunkIdByDocumentID = `-- name: ListTextChunkIdByDocumentID :many
+SELECT id
+FROM text_chunk
+WHERE document_id = ?
+`
+
+func (q *Queries) ListTextChunkIdByDocumentID(ctx context.Context, documentID string) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listTextChunkIdByDocumentID, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTextChunkSimHashes = `-- name: ListTextChunkSimHashes :many
+SELECT id, document_id, simhash
+FROM text_chunk
+WHERE simhash != 0
+`
+
+type ListTextChunkSimHashesRow struct {
+	ID         string
+	DocumentID string
+	SimHash    int64
+}
+
+func (q *Queries) ListTextChunkSimHashes(ctx context.Context) ([]ListTextChunkSimHashesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listTextChunkSimHashes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTextChunkSimHashesRow
+	for rows.Next() {
+		var i ListTextChunkSimHashesRow
+		if err := rows.Scan(&i.ID, &i.DocumentID, &i.SimHash); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTextChunkWithoutEmbeddingsByModelId = `-- name: ListTextChunkWithoutEmbeddingsByModelId :many
+SELECT id, content
+FROM text_chunk tc
+         LEFT JOIN text_embedding te ON tc.id = te.text_chunk_id AND te.model_id = ?
+WHERE te.text_chunk_id IS NULL
+`
+
+type ListTextChunkWithoutEmbeddingsByModelIdRow struct {
+	ID      string
+	Content string
+}
+
+func (q *Queries) ListTextChunkWithoutEmbeddingsByModelId(ctx context.Context, modelID string) ([]ListTextChunkWithoutEmbeddingsByModelIdRow, error) {
+	rows, err := q.db.QueryContext(ctx, listTextChunkWithoutEmbeddingsByModelId, modelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTextChunkWithoutEmbeddingsByModelIdRow
+	for rows.Next() {
+		var i ListTextChunkWithoutEmbeddingsByModelIdRow
+		if err := rows.Scan(&i.ID, &i.Content); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTextChunksAfterPosition = `-- name: ListTextChunksAfterPosition :many
+SELECT id, document_id, content, seg_content, created_at, position, metadata, content_hash, duplicate_of, simhash, language, language_detected, generated_by, prompt_hash, generated_at
+FROM text_chunk
+WHERE document_id = ?
+  AND position > ?
+ORDER BY position LIMIT ?
+`
+
+type ListTextChunksAfterPositionParams struct {
+	DocumentID string
+	Position   int64
+	Limit      int64
+}
+
+func (q *Queries) ListTextChunksAfterPosition(ctx context.Context, arg ListTextChunksAfterPositionParams) ([]TextChunk, error) {
+	rows, err := q.db.QueryContext(ctx, listTextChunksAfterPosition, arg.DocumentID, arg.Position, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TextChunk
+	for rows.Next() {
+		var i TextChunk
+		if err := rows.Scan(
+			&i.ID,
+			&i.DocumentID,
+			&i.Content,
+			&i.SegContent,
+			&i.CreatedAt,
+			&i.Position,
+			&i.Metadata,
+			&i.ContentHash,
+			&i.DuplicateOf,
+			&i.SimHash,
+			&i.Language,
+			&i.LanguageDetected,
+			&i.GeneratedBy,
+			&i.PromptHash,
+			&i.GeneratedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTextChunksBeforePosition = `-- name: ListTextChunksBeforePosition :many
+SELECT id, document_id, content, seg_content, created_at, position, metadata, content_hash, duplicate_of, simhash, language, language_detected, generated_by, prompt_hash, generated_at
+FROM text_chunk
+WHERE document_id = ?
+  AND position < ?
+ORDER BY position DESC LIMIT ?
+`
+
+type ListTextChunksBeforePositionParams struct {
+	DocumentID string
+	Position   int64
+	Limit      int64
+}
+
+func (q *Queries) ListTextChunksBeforePosition(ctx context.Context, arg ListTextChunksBeforePositionParams) ([]TextChunk, error) {
+	rows, err := q.db.QueryContext(ctx, listTextChunksBeforePosition, arg.DocumentID, arg.Position, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TextChunk
+	for rows.Next() {
+		var i TextChunk
+		if err := rows.Scan(
+			&i.ID,
+			&i.DocumentID,
+			&i.Content,
+			&i.SegContent,
+			&i.CreatedAt,
+			&i.Position,
+			&i.Metadata,
+			&i.ContentHash,
+			&i.DuplicateOf,
+			&i.SimHash,
+			&i.Language,
+			&i.LanguageDetected,
+			&i.GeneratedBy,
+			&i.PromptHash,
+			&i.GeneratedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTextChunksByContentHash = `-- name: ListTextChunksByContentHash :many
+SELECT id, document_id, content, seg_content, created_at, position, metadata, content_hash, duplicate_of, simhash, language, language_detected, generated_by, prompt_hash, generated_at
+FROM text_chunk
+WHERE content_hash = ?
+ORDER BY created_at, id
+`
+
+func (q *Queries) ListTextChunksByContentHash(ctx context.Context, contentHash string) ([]TextChunk, error) {
+	rows, err := q.db.QueryContext(ctx, listTextChunksByContentHash, contentHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TextChunk
+	for rows.Next() {
+		var i TextChunk
+		if err := rows.Scan(
+			&i.ID,
+			&i.DocumentID,
+			&i.Content,
+			&i.SegContent,
+			&i.CreatedAt,
+			&i.Position,
+			&i.Metadata,
+			&i.ContentHash,
+			&i.DuplicateOf,
+			&i.SimHash,
+			&i.Language,
+			&i.LanguageDetected,
+			&i.GeneratedBy,
+			&i.PromptHash,
+			&i.GeneratedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTextChunksByDocumentID = `-- name: ListTextChunksByDocumentID :many
+SELECT id, document_id, content, seg_content, created_at, position, metadata, content_hash, duplicate_of, simhash, language, language_detected, generated_by, prompt_hash, generated_at
+FROM text_chunk
+WHERE document_id = ?
+ORDER BY position, created_at
+`
+
+func (q *Queries) ListTextChunksByDocumentID(ctx context.Context, documentID string) ([]TextChunk, error) {
+	rows, err := q.db.QueryContext(ctx, listTextChunksByDocumentID, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TextChunk
+	for rows.Next() {
+		var i TextChunk
+		if err := rows.Scan(
+			&i.ID,
+			&i.DocumentID,
+			&i.Content,
+			&i.SegContent,
+			&i.CreatedAt,
+			&i.Position,
+			&i.Metadata,
+			&i.ContentHash,
+			&i.DuplicateOf,
+			&i.SimHash,
+			&i.Language,
+			&i.LanguageDetected,
+			&i.GeneratedBy,
+			&i.PromptHash,
+			&i.GeneratedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTextChunksWithoutContentHash = `-- name: ListTextChunksWithoutContentHash :many
+SELECT id, content
+FROM text_chunk
+WHERE content_hash = ''
+`
+
+type ListTextChunksWithoutContentHashRow struct {
+	ID      string
+	Content string
+}
+
+func (q *Queries) ListTextChunksWithoutContentHash(ctx context.Context) ([]ListTextChunksWithoutContentHashRow, error) {
+	rows, err := q.db.QueryContext(ctx, listTextChunksWithoutContentHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTextChunksWithoutContentHashRow
+	for rows.Next() {
+		var i ListTextChunksWithoutContentHashRow
+		if err := rows.Scan(&i.ID, &i.Content); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTextChunksWithoutSimHash = `-- name: ListTextChunksWithoutSimHash :many
+SELECT id, seg_content
+FROM text_chunk
+WHERE simhash = 0
+  AND seg_content != ''
+`
+
+type ListTextChunksWithoutSimHashRow struct {
+	ID         string
+	SegContent string
+}
+
+func (q *Queries) ListTextChunksWithoutSimHash(ctx context.Context) ([]ListTextChunksWithoutSimHashRow, error) {
+	rows, err := q.db.QueryContext(ctx, listTextChunksWithoutSimHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTextChunksWithoutSimHashRow
+	for rows.Next() {
+		var i ListTextChunksWithoutSimHashRow
+		if err := rows.Scan(&i.ID, &i.SegContent); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTextEmbeddingsByTextChunkID = `-- name: ListTextEmbeddingsByTextChunkID :many
+SELECT model_id, vector
+FROM text_embedding
+WHERE text_chunk_id = ?
+`
+
+type ListTextEmbeddingsByTextChunkIDRow struct {
+	ModelID string
+	Vector  []byte
+}
+
+func (q *Queries) ListTextEmbeddingsByTextChunkID(ctx context.Context, textChunkID string) ([]ListTextEmbeddingsByTextChunkIDRow, error) {
+	rows, err := q.db.QueryContext(ctx, listTextEmbeddingsByTextChunkID, textChunkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTextEmbeddingsByTextChunkIDRow
+	for rows.Next() {
+		var i ListTextEmbeddingsByTextChunkIDRow
+		if err := rows.Scan(&i.ModelID, &i.Vector); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const newDocument = `-- name: NewDocument :exec
+INSERT INTO document (id, title, description, data, duplicate_of)
+VALUES (?, ?, ?, ?, ?)
+`
+
+type NewDocumentParams struct {
+	ID          string
+	Title       string
+	Description string
+	Data        string
+	DuplicateOf string
+}
+
+func (q *Queries) NewDocument(ctx context.Context, arg NewDocumentParams) error {
+	_, err := q.db.ExecContext(ctx, newDocument,
+		arg.ID,
+		arg.Title,
+		arg.Description,
+		arg.Data,
+		arg.DuplicateOf,
+	)
+	return err
+}
+
+const newJob = `-- name: NewJob :one
+INSERT INTO job (id, kind, status, params, total)
+VALUES (?, ?, ?, ?, ?) RETURNING id, kind, status, params, total, processed, failed, dropped, generated, error, created_at, updated_at
+`
+
+type NewJobParams struct {
+	ID     string
+	Kind   string
+	Status string
+	Params string
+	Total  int64
+}
+
+func (q *Queries) NewJob(ctx context.Context, arg NewJobParams) (Job, error) {
+	row := q.db.QueryRowContext(ctx, newJob,
+		arg.ID,
+		arg.Kind,
+		arg.Status,
+		arg.Params,
+		arg.Total,
+	)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.Status,
+		&i.Params,
+		&i.Total,
+		&i.Processed,
+		&i.Failed,
+		&i.Dropped,
+		&i.Generated,
+		&i.Error,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const newJobDocument = `-- name: NewJobDocument :exec
+INSERT INTO job_document (job_id, document_id)
+VALUES (?, ?)
+`
+
+type NewJobDocumentParams struct {
+	JobID      string
+	DocumentID string
+}
+
+func (q *Queries) NewJobDocument(ctx context.Context, arg NewJobDocumentParams) error {
+	_, err := q.db.ExecContext(ctx, newJobDocument, arg.JobID, arg.DocumentID)
+	return err
+}
+
+const newTextChunk = `-- name: NewTextChunk :one
+INSERT INTO text_chunk (id, document_id, content, seg_content, position, metadata, content_hash, duplicate_of, simhash,
+                        language, language_detected, generated_by, prompt_hash, generated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id, document_id, content, seg_content, created_at, position, metadata, content_hash, duplicate_of, simhash, language, language_detected, generated_by, prompt_hash, generated_at
+`
+
+type NewTextChunkParams struct {
+	ID               string
+	DocumentID       string
+	Content          string
+	SegContent       string
+	Position         int64
+	Metadata         string
+	ContentHash      string
+	DuplicateOf      string
+	SimHash          int64
+	Language         string
+	LanguageDetected bool
+	GeneratedBy      string
+	PromptHash       string
+	GeneratedAt      int64
+}
+
+func (q *Queries) NewTextChunk(ctx context.Context, arg NewTextChunkParams) (TextChunk, error) {
+	row := q.db.QueryRowContext(ctx, newTextChunk,
+		arg.ID,
+		arg.DocumentID,
+		arg.Content,
+		arg.SegContent,
+		arg.Position,
+		arg.Metadata,
+		arg.ContentHash,
+		arg.DuplicateOf,
+		arg.SimHash,
+		arg.Language,
+		arg.LanguageDetected,
+		arg.GeneratedBy,
+		arg.PromptHash,
+		arg.GeneratedAt,
+	)
+	var i TextChunk
+	err := row.Scan(
+		&i.ID,
+		&i.DocumentID,
+		&i.Content,
+		&i.SegContent,
+		&i.CreatedAt,
+		&i.Position,
+		&i.Metadata,
+		&i.ContentHash,
+		&i.DuplicateOf,
+		&i.SimHash,
+		&i.Language,
+		&i.LanguageDetected,
+		&i.GeneratedBy,
+		&i.PromptHash,
+		&i.GeneratedAt,
+	)
+	return i, err
+}
+
+const newTextEmbedding = `-- name: NewTextEmbedding :exec
+INSERT INTO text_embedding (model_id, text_chunk_id, vector)
 VALUES (?, ?, ?)
 `
 
@@ -343,3 +1442,214 @@ func (q *Queries) NewTextEmbedding(ctx context.Context, arg NewTextEmbeddingPara
 	_, err := q.db.ExecContext(ctx, newTextEmbedding, arg.ModelID, arg.TextChunkID, arg.Vector)
 	return err
 }
+
+const recordSearchQuery = `-- name: RecordSearchQuery :exec
+INSERT INTO search_query (query, count)
+VALUES (?, 1)
+ON CONFLICT (query) DO UPDATE SET count            = count + 1,
+                                  last_searched_at = strftime('%s', 'now')
+`
+
+func (q *Queries) RecordSearchQuery(ctx context.Context, query string) error {
+	_, err := q.db.ExecContext(ctx, recordSearchQuery, query)
+	return err
+}
+
+const setMeta = `-- name: SetMeta :exec
+INSERT INTO meta (key, value)
+VALUES (?, ?)
+ON CONFLICT (key) DO UPDATE SET value = excluded.value
+`
+
+type SetMetaParams struct {
+	Key   string
+	Value string
+}
+
+func (q *Queries) SetMeta(ctx context.Context, arg SetMetaParams) error {
+	_, err := q.db.ExecContext(ctx, setMeta, arg.Key, arg.Value)
+	return err
+}
+
+const updateDocumentData = `-- name: UpdateDocumentData :exec
+UPDATE document
+SET data = ?
+WHERE id = ?
+`
+
+type UpdateDocumentDataParams struct {
+	Data string
+	ID   string
+}
+
+func (q *Queries) UpdateDocumentData(ctx context.Context, arg UpdateDocumentDataParams) error {
+	_, err := q.db.ExecContext(ctx, updateDocumentData, arg.Data, arg.ID)
+	return err
+}
+
+const updateDocumentSimHash = `-- name: UpdateDocumentSimHash :exec
+UPDATE document
+SET simhash = ?
+WHERE id = ?
+`
+
+type UpdateDocumentSimHashParams struct {
+	SimHash int64
+	ID      string
+}
+
+func (q *Queries) UpdateDocumentSimHash(ctx context.Context, arg UpdateDocumentSimHashParams) error {
+	_, err := q.db.ExecContext(ctx, updateDocumentSimHash, arg.SimHash, arg.ID)
+	return err
+}
+
+const updateJobStatus = `-- name: UpdateJobStatus :exec
+UPDATE job
+SET status     = ?,
+    error      = ?,
+    updated_at = strftime('%s', 'now')
+WHERE id = ?
+`
+
+type UpdateJobStatusParams struct {
+	Status string
+	Error  string
+	ID     string
+}
+
+func (q *Queries) UpdateJobStatus(ctx context.Context, arg UpdateJobStatusParams) error {
+	_, err := q.db.ExecContext(ctx, updateJobStatus, arg.Status, arg.Error, arg.ID)
+	return err
+}
+
+const updateTextChunk = `-- name: UpdateTextChunk :one
+UPDATE text_chunk
+SET content      = ?,
+    seg_content  = ?,
+    metadata     = ?,
+    content_hash = ?,
+    simhash      = ?,
+    language     = ?,
+    language_detected = ?,
+    duplicate_of = ''
+WHERE id = ? RETURNING id, document_id, content, seg_content, created_at, position, metadata, content_hash, duplicate_of, simhash, language, language_detected, generated_by, prompt_hash, generated_at
+`
+
+type UpdateTextChunkParams struct {
+	Content          string
+	SegContent       string
+	Metadata         string
+	ContentHash      string
+	SimHash          int64
+	Language         string
+	LanguageDetected bool
+	ID               string
+}
+
+func (q *Queries) UpdateTextChunk(ctx context.Context, arg UpdateTextChunkParams) (TextChunk, error) {
+	row := q.db.QueryRowContext(ctx, updateTextChunk,
+		arg.Content,
+		arg.SegContent,
+		arg.Metadata,
+		arg.ContentHash,
+		arg.SimHash,
+		arg.Language,
+		arg.LanguageDetected,
+		arg.ID,
+	)
+	var i TextChunk
+	err := row.Scan(
+		&i.ID,
+		&i.DocumentID,
+		&i.Content,
+		&i.SegContent,
+		&i.CreatedAt,
+		&i.Position,
+		&i.Metadata,
+		&i.ContentHash,
+		&i.DuplicateOf,
+		&i.SimHash,
+		&i.Language,
+		&i.LanguageDetected,
+		&i.GeneratedBy,
+		&i.PromptHash,
+		&i.GeneratedAt,
+	)
+	return i, err
+}
+
+const updateTextChunkAnalysis = `-- name: UpdateTextChunkAnalysis :exec
+UPDATE text_chunk
+SET seg_content  = ?,
+    content_hash = ?,
+    simhash      = ?,
+    language     = ?
+WHERE id = ?
+`
+
+type UpdateTextChunkAnalysisParams struct {
+	SegContent  string
+	ContentHash string
+	SimHash     int64
+	Language    string
+	ID          string
+}
+
+func (q *Queries) UpdateTextChunkAnalysis(ctx context.Context, arg UpdateTextChunkAnalysisParams) error {
+	_, err := q.db.ExecContext(ctx, updateTextChunkAnalysis,
+		arg.SegContent,
+		arg.ContentHash,
+		arg.SimHash,
+		arg.Language,
+		arg.ID,
+	)
+	return err
+}
+
+const updateTextChunkContentHash = `-- name: UpdateTextChunkContentHash :exec
+UPDATE text_chunk
+SET content_hash = ?
+WHERE id = ?
+`
+
+type UpdateTextChunkContentHashParams struct {
+	ContentHash string
+	ID          string
+}
+
+func (q *Queries) UpdateTextChunkContentHash(ctx context.Context, arg UpdateTextChunkContentHashParams) error {
+	_, err := q.db.ExecContext(ctx, updateTextChunkContentHash, arg.ContentHash, arg.ID)
+	return err
+}
+
+const updateTextChunkFTS = `-- name: UpdateTextChunkFTS :exec
+UPDATE text_chunk_fts
+SET seg_content = ?
+WHERE id = ?
+`
+
+type UpdateTextChunkFTSParams struct {
+	SegContent string
+	ID         string
+}
+
+func (q *Queries) UpdateTextChunkFTS(ctx context.Context, arg UpdateTextChunkFTSParams) error {
+	_, err := q.db.ExecContext(ctx, updateTextChunkFTS, arg.SegContent, arg.ID)
+	return err
+}
+
+const updateTextChunkSimHash = `-- name: UpdateTextChunkSimHash :exec
+UPDATE text_chunk
+SET simhash = ?
+WHERE id = ?
+`
+
+type UpdateTextChunkSimHashParams struct {
+	SimHash int64
+	ID      string
+}
+
+func (q *Queries) UpdateTextChunkSimHash(ctx context.Context, arg UpdateTextChunkSimHashParams) error {
+	_, err := q.db.ExecContext(ctx, updateTextChunkSimHash, arg.SimHash, arg.ID)
+	return err
+}
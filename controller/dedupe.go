@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v5"
+	"github.com/samber/lo"
+	"github.com/tsingjyujing/vestigo/controller/dao"
+	"github.com/tsingjyujing/vestigo/utils"
+)
+
+const (
+	// DedupePolicyAllow stores duplicated text chunks as usual
+	DedupePolicyAllow = "allow"
+	// DedupePolicySkip doesn't store a text chunk if the same content already exists,
+	// nor a document if an existing one has the same texts
+	DedupePolicySkip = "skip"
+	// DedupePolicyLink stores the text chunk as a duplicate of the existing one and reuses its embeddings,
+	// a document with the same texts as an existing one is linked to it too
+	DedupePolicyLink = "link"
+)
+
+func validateDedupePolicy(policy string) error {
+	switch policy {
+	case DedupePolicyAllow, DedupePolicySkip, DedupePolicyLink:
+		return nil
+	}
+	return fmt.Errorf("unknown dedupe policy '%s', should be one of %s, %s, %s", policy, DedupePolicyAllow, DedupePolicySkip, DedupePolicyLink)
+}
+
+// getDedupePolicy returns the policy from the query parameter `dedupe`, or the default one from options
+func (c *Controller) getDedupePolicy(echoCtx *echo.Context) (string, error) {
+	policy := echoCtx.QueryParamOr("dedupe", c.options.DedupePolicy)
+	if policy == "" {
+		policy = DedupePolicyAllow
+	}
+	return policy, validateDedupePolicy(policy)
+}
+
+// contentHash hashes the normalized content, so texts only differ in width, case or whitespaces are the same.
+// The default normalizer is used regardless of the language, so the hash doesn't depend on detection results.
+func (c *Controller) contentHash(content string) string {
+	normalized, err := c.analyzer.Load().Normalizer.Normalize(content)
+	if err != nil {
+		logger.WithError(err).Error("Failed to normalize text for hashing")
+		normalized = content
+	}
+	sum := sha256.Sum256([]byte(strings.Join(strings.Fields(normalized), " ")))
+	return hex.EncodeToString(sum[:])
+}
+
+// findOriginalTextChunk returns the first stored text chunk with the same content hash or nil if there's none
+func findOriginalTextChunk(ctx context.Context, queries *dao.Queries, contentHash string) (*dao.TextChunk, error) {
+	original, err := queries.GetOriginalTextChunkByContentHash(ctx, contentHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &original, nil
+}
+
+// findDuplicateDocument returns the ID of an existing document with the same source texts, or empty if there's none.
+// The texts are compared by content hashes, the document with docId is excluded since it's to be overwritten.
+func (c *Controller) findDuplicateDocument(ctx context.Context, docId string, texts []TextInput) (string, error) {
+	hashes := lo.Uniq(lo.FilterMap(texts, func(item TextInput, index int) (string, bool) {
+		return c.contentHash(item.Content), item.origin == nil
+	}))
+	if len(hashes) == 0 {
+		return "", nil
+	}
+	hashesJSON, err := json.Marshal(hashes)
+	if err != nil {
+		return "", err
+	}
+	duplicateOf, err := c.queries.GetDuplicateDocumentID(ctx, dao.GetDuplicateDocumentIDParams{
+		ID:            docId,
+		ContentHash:   hashes[0],
+		HashCount:     int64(len(hashes)),
+		ContentHashes: string(hashesJSON),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return duplicateOf, err
+}
+
+// backfillContentHashes computes content hashes of text chunks created before hashing was introduced
+func (c *Controller) backfillContentHashes(ctx context.Context) error {
+	rows, err := c.queries.ListTextChunksWithoutContentHash(ctx)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	_, err = utils.WithTx(
+		ctx,
+		c.db,
+		nil,
+		func(tx *sql.Tx) (any, error) {
+			queries := dao.New(tx)
+			for _, row := range rows {
+				if err := queries.UpdateTextChunkContentHash(ctx, dao.UpdateTextChunkContentHashParams{
+					ContentHash: c.contentHash(row.Content),
+					ID:          row.ID,
+				}); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+	logger.Infof("Computed content hash for %d text chunks", len(rows))
+	return nil
+}
+
+type DuplicateTextChunk struct {
+	ID          string `json:"id"`
+	DocumentID  string `json:"document_id"`
+	Position    int64  `json:"position"`
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	CreatedAt   int64  `json:"created_at"`
+}
+
+type DuplicateCluster struct {
+	ContentHash   string               `json:"content_hash"`
+	Content       string               `json:"content"`
+	ChunkCount    int64                `json:"chunk_count"`
+	DocumentCount int64                `json:"document_count"`
+	Texts         []DuplicateTextChunk `json:"texts"`
+}
+
+type DuplicateReport struct {
+	Clusters []DuplicateCluster `json:"clusters"`
+}
+
+// ListDuplicates reports text chunks sharing the same content hash,
+// by default only the clusters across at least 2 documents are listed
+func (c *Controller) ListDuplicates(echoCtx *echo.Context) error {
+	ctx := echoCtx.Request().Context()
+	minDocuments, err := strconv.Atoi(echoCtx.QueryParamOr("min_documents", "2"))
+	if err != nil || minDocuments <= 0 {
+		return utils.EchoHandleGenericError(echoCtx, fmt.Errorf("invalid parameter 'min_documents': %s", echoCtx.QueryParam("min_documents")), http.StatusBadRequest)
+	}
+	n, err := strconv.Atoi(echoCtx.QueryParam("n"))
+	if err != nil || n <= 0 {
+		n = 100
+	}
+	hashes, err := c.queries.ListDuplicateContentHashes(ctx, dao.ListDuplicateContentHashesParams{
+		DocumentCount: int64(minDocuments),
+		Limit:         int64(n),
+	})
+	if err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	report := DuplicateReport{Clusters: make([]DuplicateCluster, 0, len(hashes))}
+	for _, hash := range hashes {
+		rows, err := c.queries.ListTextChunksByContentHash(ctx, hash.ContentHash)
+		if err != nil {
+			return utils.EchoHandleInternalError(echoCtx, err)
+		}
+		cluster := DuplicateCluster{
+			ContentHash:   hash.ContentHash,
+			ChunkCount:    hash.ChunkCount,
+			DocumentCount: hash.DocumentCount,
+			Texts:         make([]DuplicateTextChunk, 0, len(rows)),
+		}
+		for _, row := range rows {
+			if cluster.Content == "" {
+				cluster.Content = row.Content
+			}
+			cluster.Texts = append(cluster.Texts, DuplicateTextChunk{
+				ID:          row.ID,
+				DocumentID:  row.DocumentID,
+				Position:    row.Position,
+				DuplicateOf: row.DuplicateOf,
+				CreatedAt:   row.CreatedAt,
+			})
+		}
+		report.Clusters = append(report.Clusters, cluster)
+	}
+	return utils.EchoJsonResponse(echoCtx, report, http.StatusOK)
+}
@@ -0,0 +1,237 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coder/hnsw"
+	"github.com/labstack/echo/v5"
+	"github.com/samber/lo"
+)
+
+const (
+	defaultFacetSize = 10
+	maxFacetSize     = 100
+	// defaultFacetWindow is the number of nearest text chunks of each embedding model counted by facets
+	defaultFacetWindow = 1000
+	// maxFacetWindow limits the candidate window, a larger one is slower to search in the ANN index
+	maxFacetWindow = 10000
+)
+
+// histogramModifiers are the SQLite date modifiers truncating unix time to the start of each interval
+var histogramModifiers = map[string]string{
+	"day":   `'start of day'`,
+	"week":  `'-6 days', 'weekday 1', 'start of day'`,
+	"month": `'start of month'`,
+	"year":  `'start of year'`,
+}
+
+// FacetCount is the number of matched documents with a value of a data key
+type FacetCount struct {
+	Value string `json:"value" jsonschema:"the value of the data key, each element is counted for arrays"`
+	Count int64  `json:"count" jsonschema:"the number of matched documents with the value"`
+}
+
+// HistogramBucket is the number of matched documents created in an interval
+type HistogramBucket struct {
+	Start int64  `json:"start" jsonschema:"the unix time of the start of the interval"`
+	Key   string `json:"key" jsonschema:"the date of the start of the interval, e.g. 2024-01-01"`
+	Count int64  `json:"count" jsonschema:"the number of matched documents created in the interval"`
+}
+
+// Facets are the counts of all documents matching the query and filters, not only the returned results
+type Facets struct {
+	// Data are the most frequent values of the requested data keys
+	Data map[string][]FacetCount `json:"data,omitempty" jsonschema:"the most frequent values of each requested data key"`
+	// CreatedAt is the histogram of the creation time of documents by the requested interval
+	CreatedAt []HistogramBucket `json:"created_at,omitempty" jsonschema:"the histogram of the creation time of documents"`
+}
+
+type facetParams struct {
+	keys     []string
+	interval string
+	size     int
+	window   int
+}
+
+// parseFacetParams parses `facets=<key>,<key>` for the data keys (repeatable), `histogram=day|week|month|year`
+// for created_at, `facet_size=10` for the number of values of each key and `facet_window=1000` for the number of
+// candidates of embedding models. It returns nil if no facets are requested.
+func (c *Controller) parseFacetParams(echoCtx *echo.Context) (*facetParams, error) {
+	keys := make([]string, 0)
+	for _, value := range echoCtx.QueryParams()["facets"] {
+		for _, key := range strings.Split(value, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				if _, err := jsonPath(key); err != nil {
+					return nil, fmt.Errorf("invalid facet %s: %w", key, err)
+				}
+				keys = append(keys, key)
+			}
+		}
+	}
+	interval := strings.ToLower(echoCtx.QueryParam("histogram"))
+	if _, ok := histogramModifiers[interval]; interval != "" && !ok {
+		return nil, fmt.Errorf("invalid parameter 'histogram': %s, it should be day, week, month or year", interval)
+	}
+	if len(keys) == 0 && interval == "" {
+		return nil, nil
+	}
+	size, err := strconv.Atoi(echoCtx.QueryParamOr("facet_size", strconv.Itoa(defaultFacetSize)))
+	if err != nil || size <= 0 || size > maxFacetSize {
+		return nil, fmt.Errorf("invalid parameter 'facet_size': %s, it should be between 1 and %d", echoCtx.QueryParam("facet_size"), maxFacetSize)
+	}
+	defaultWindow := lo.Ternary(c.options.FacetWindow > 0, c.options.FacetWindow, defaultFacetWindow)
+	window, err := strconv.Atoi(echoCtx.QueryParamOr("facet_window", strconv.Itoa(defaultWindow)))
+	if err != nil || window <= 0 || window > maxFacetWindow {
+		return nil, fmt.Errorf("invalid parameter 'facet_window': %s, it should be between 1 and %d", echoCtx.QueryParam("facet_window"), maxFacetWindow)
+	}
+	return &facetParams{keys: lo.Uniq(keys), interval: interval, size: size, window: window}, nil
+}
+
+// matchedDocuments builds a subquery of the IDs of documents matching the query and filter: all matches of BM25,
+// and the documents of the nearest text chunks in the candidate window of embedding models
+func (c *Controller) matchedDocuments(ctx context.Context, embeddings queryEmbeddings, method string, ftsQuery string, text string, filter *searchFilter, window int) (string, []any, error) {
+	parts := make([]string, 0, len(c.embeddingIndexes)+1)
+	args := make([]any, 0)
+	isBM25, isHybrid := strings.ToLower(method) == RetrievalBM25, strings.ToLower(method) == RetrievalHybrid
+	if (isBM25 || isHybrid) && ftsQuery != "" {
+		parts = append(parts, `SELECT tc.document_id
+			FROM text_chunk_fts fts
+			JOIN text_chunk tc ON tc.id = fts.id
+			JOIN document d ON d.id = tc.document_id
+			WHERE fts.seg_content MATCH ?`+filter.where())
+		args = append(args, expandQuery(c.analyzer.Load(), ftsQuery))
+		args = append(args, filter.args...)
+	}
+	var modelIds []string
+	switch {
+	case isHybrid:
+		modelIds = lo.Keys(c.embeddingIndexes)
+	case !isBM25:
+		modelIds = []string{method}
+	}
+	for _, modelId := range modelIds {
+		queryEmbedding, err := c.embedQuery(ctx, embeddings, modelId, text)
+		if err != nil {
+			return "", nil, err
+		}
+		ids := lo.Map(c.embeddingIndexes[modelId].SearchWithDistance(queryEmbedding, window), func(item hnsw.SearchResult[string], index int) string {
+			return item.Key
+		})
+		if len(ids) == 0 {
+			continue
+		}
+		// The IDs are bound as a JSON array, the window may exceed the limit of SQLite variables
+		idsJSON, err := json.Marshal(ids)
+		if err != nil {
+			return "", nil, err
+		}
+		parts = append(parts, `SELECT tc.document_id
+			FROM text_chunk tc
+			JOIN document d ON d.id = tc.document_id
+			WHERE tc.id IN (SELECT value FROM json_each(?))`+filter.where())
+		args = append(args, string(idsJSON))
+		args = append(args, filter.args...)
+	}
+	if len(parts) == 0 {
+		// Nothing matches
+		return "SELECT NULL WHERE FALSE", nil, nil
+	}
+	return strings.Join(parts, " UNION "), args, nil
+}
+
+// computeFacets counts the documents matching the query and filter by the values of data keys and creation time
+func (c *Controller) computeFacets(ctx context.Context, embeddings queryEmbeddings, method string, ftsQuery string, text string, filter *searchFilter, params *facetParams) (*Facets, error) {
+	matched, matchedArgs, err := c.matchedDocuments(ctx, embeddings, method, ftsQuery, text, filter, params.window)
+	if err != nil {
+		return nil, err
+	}
+	facets := &Facets{}
+	if len(params.keys) > 0 {
+		facets.Data = make(map[string][]FacetCount, len(params.keys))
+	}
+	for _, key := range params.keys {
+		path, err := jsonPath(key)
+		if err != nil {
+			return nil, err
+		}
+		args := append([]any{path}, matchedArgs...)
+		args = append(args, params.size)
+		// json_each returns the elements of arrays like tags, and a scalar itself
+		counts, err := queryFacetCounts(ctx, c.db, `
+			SELECT `+jsonValueText+` AS facet_value, COUNT(DISTINCT d.id) AS count
+			FROM document d, json_each(d.data, ?) je
+			WHERE d.id IN (`+matched+`) AND je.type NOT IN ('object', 'array', 'null')
+			GROUP BY facet_value
+			ORDER BY count DESC, facet_value
+			LIMIT ?
+		`, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count facet %s: %w", key, err)
+		}
+		facets.Data[key] = counts
+	}
+	if params.interval != "" {
+		histogram, err := queryHistogram(ctx, c.db, histogramModifiers[params.interval], matched, matchedArgs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count the histogram of created_at: %w", err)
+		}
+		facets.CreatedAt = histogram
+	}
+	return facets, nil
+}
+
+func queryFacetCounts(ctx context.Context, db *sql.DB, query string, args ...any) ([]FacetCount, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			logger.WithError(err).Error("Failed to close rows")
+		}
+	}(rows)
+	counts := make([]FacetCount, 0)
+	for rows.Next() {
+		var count FacetCount
+		if err := rows.Scan(&count.Value, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+func queryHistogram(ctx context.Context, db *sql.DB, modifiers string, matched string, matchedArgs []any) ([]HistogramBucket, error) {
+	bucket := fmt.Sprintf("d.created_at, 'unixepoch', %s", modifiers)
+	rows, err := db.QueryContext(ctx, `
+		SELECT CAST(strftime('%s', `+bucket+`) AS INTEGER) AS start, date(`+bucket+`) AS key, COUNT(*) AS count
+		FROM document d
+		WHERE d.id IN (`+matched+`)
+		GROUP BY start
+		ORDER BY start
+	`, matchedArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			logger.WithError(err).Error("Failed to close rows")
+		}
+	}(rows)
+	histogram := make([]HistogramBucket, 0)
+	for rows.Next() {
+		var bucket HistogramBucket
+		if err := rows.Scan(&bucket.Start, &bucket.Key, &bucket.Count); err != nil {
+			return nil, err
+		}
+		histogram = append(histogram, bucket)
+	}
+	return histogram, rows.Err()
+}
@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+const metadataFilterPrefix = "metadata."
+
+const dataFilterPrefix = "data."
+
+// jsonValueText is the text of a value of json_each or json_tree to be compared with query parameters,
+// booleans are true and false rather than 1 and 0 of SQLite
+const jsonValueText = "CASE type WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' ELSE CAST(value AS TEXT) END"
+
+// metadataFilterClause matches text chunks whose metadata value at the key is one of the values,
+// the root of json_tree is the value of the key itself
+const metadataFilterClause = "(SELECT " + jsonValueText + " FROM json_tree(tc.metadata, ?) WHERE parent IS NULL) IN (%s)"
+
+// dataFilterClause matches text chunks of documents whose data value at the key is or contains one of the values,
+// json_each returns the elements of arrays like tags, and a scalar itself
+const dataFilterClause = "EXISTS (SELECT 1 FROM json_each(d.data, ?) WHERE " + jsonValueText + " IN (%s))"
+
+// filterOversampling is how many times more candidates are fetched from the ANN index when
+// results are filtered afterward, so the filtered result is less likely to be shorter than requested
+const filterOversampling = 10
+
+// searchFilter is a set of SQL conditions on the joined `text_chunk tc` and `document d` tables
+type searchFilter struct {
+	clauses []string
+	args    []any
+	// collapseDuplicates keeps only the best result of text chunks with the same content hash
+	collapseDuplicates bool
+}
+
+func (f *searchFilter) add(clause string, args ...any) {
+	f.clauses = append(f.clauses, clause)
+	f.args = append(f.args, args...)
+}
+
+// merge adds the conditions of another filter
+func (f *searchFilter) merge(other *searchFilter) {
+	f.clauses = append(f.clauses, other.clauses...)
+	f.args = append(f.args, other.args...)
+}
+
+func (f *searchFilter) empty() bool {
+	return len(f.clauses) == 0
+}
+
+// oversampled returns true if results are dropped after retrieval, so more candidates are needed
+func (f *searchFilter) oversampled() bool {
+	return !f.empty() || f.collapseDuplicates
+}
+
+// postFilter applies the filters which can't be done in SQL on the sorted results and truncates them
+func (f *searchFilter) postFilter(results []SearchResultItem, nDoc int) []SearchResultItem {
+	if f.collapseDuplicates {
+		seen := make(map[string]bool)
+		collapsed := make([]SearchResultItem, 0, len(results))
+		for _, item := range results {
+			key := item.contentHash
+			if key == "" {
+				key = item.TextChunkID
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			collapsed = append(collapsed, item)
+		}
+		results = collapsed
+	}
+	if len(results) > nDoc {
+		results = results[:nDoc]
+	}
+	return results
+}
+
+// where returns the conditions to be appended after an existing WHERE clause
+func (f *searchFilter) where() string {
+	if f.empty() {
+		return ""
+	}
+	return " AND " + strings.Join(f.clauses, " AND ")
+}
+
+// jsonPath builds a SQLite JSON path for a top level key of an object
+func jsonPath(key string) (string, error) {
+	if key == "" || strings.Contains(key, `"`) {
+		return "", fmt.Errorf("invalid key: %q", key)
+	}
+	return fmt.Sprintf(`$."%s"`, key), nil
+}
+
+// parseSearchFilter builds the filter from query parameters,
+// `metadata.<key>=<value>` matches text chunks whose metadata[key] equals to one of the given values,
+// `data.<key>=<value>` matches text chunks of documents whose data[key] equals to or, for arrays like tags,
+// contains one of the given values,
+// `lang=<code>` matches text chunks in one of the given languages,
+// `generated=true|false` matches only generated or source text chunks,
+// `generated_by=<model_id>` matches text chunks generated by one of the given models,
+// `collapse=true` collapses text chunks with the same content
+func parseSearchFilter(params url.Values) (*searchFilter, error) {
+	collapse := params.Get("collapse")
+	filter := &searchFilter{collapseDuplicates: collapse == "true" || collapse == "1"}
+	if generated := params.Get("generated"); generated != "" {
+		isGenerated, err := strconv.ParseBool(generated)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameter 'generated': %s", generated)
+		}
+		if isGenerated {
+			filter.add("tc.generated_by != ''")
+		} else {
+			filter.add("tc.generated_by = ''")
+		}
+	}
+	if generatedBy := lo.Compact(params["generated_by"]); len(generatedBy) > 0 {
+		filter.add(
+			fmt.Sprintf("tc.generated_by IN (%s)", strings.TrimSuffix(strings.Repeat("?,", len(generatedBy)), ",")),
+			lo.ToAnySlice(generatedBy)...,
+		)
+	}
+	if languages := parseLanguages(params); len(languages) > 0 {
+		args := make([]any, 0, len(languages))
+		for _, language := range languages {
+			args = append(args, language)
+		}
+		filter.add(
+			fmt.Sprintf("tc.language IN (%s)", strings.TrimSuffix(strings.Repeat("?,", len(languages)), ",")),
+			args...,
+		)
+	}
+	if err := addJSONFilters(filter, params, metadataFilterPrefix, metadataFilterClause); err != nil {
+		return nil, err
+	}
+	if err := addJSONFilters(filter, params, dataFilterPrefix, dataFilterClause); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// addJSONFilters adds a condition for each `<prefix><key>` parameter by the template of the clause,
+// whose arguments are the JSON path of the key and the values
+func addJSONFilters(filter *searchFilter, params url.Values, prefix string, clauseTemplate string) error {
+	keys := make([]string, 0)
+	for key := range params {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys) // make the generated SQL stable
+	for _, key := range keys {
+		path, err := jsonPath(strings.TrimPrefix(key, prefix))
+		if err != nil {
+			return fmt.Errorf("invalid %s filter %s: %w", strings.TrimSuffix(prefix, "."), key, err)
+		}
+		values := params[key]
+		args := make([]any, 0, len(values)+1)
+		args = append(args, path)
+		for _, value := range values {
+			args = append(args, value)
+		}
+		filter.add(fmt.Sprintf(clauseTemplate, strings.TrimSuffix(strings.Repeat("?,", len(values)), ",")), args...)
+	}
+	return nil
+}
+
+// parseLanguages returns the language codes of the `lang` parameters, multiple ones can be separated by commas
+func parseLanguages(params url.Values) []string {
+	languages := make([]string, 0)
+	for _, value := range params["lang"] {
+		for _, language := range strings.Split(value, ",") {
+			if language = strings.ToLower(strings.TrimSpace(language)); language != "" {
+				languages = append(languages, language)
+			}
+		}
+	}
+	return languages
+}
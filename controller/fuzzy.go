@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/samber/lo"
+	"github.com/tsingjyujing/vestigo/text"
+)
+
+// maxSpellingCandidates is the number of similar terms a misspelled query term is expanded to
+const maxSpellingCandidates = 3
+
+// maxEditDistance allows more typos in longer terms, short terms are not corrected since too many terms are similar
+func maxEditDistance(term string) int {
+	switch n := utf8.RuneCountInString(term); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// termExists checks whether the term is in the full text index
+func (c *Controller) termExists(ctx context.Context, term string) (bool, error) {
+	var count int
+	if err := c.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM text_chunk_vocab WHERE term = ?`, term).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// spellingCandidates returns the terms in the full text index within the edit distance of the term,
+// the closest and the most common ones first. They're searched in the terms trie of the suggester,
+// which holds the same vocabulary as text_chunk_vocab, so a search doesn't scan the whole vocabulary.
+func (c *Controller) spellingCandidates(term string, distance int) []string {
+	s := c.suggester
+	s.lock.RLock()
+	similar := s.terms.Similar(s.fold(term), distance)
+	s.lock.RUnlock()
+	// The folded form of a missing term may be in the trie, it's not a correction
+	candidates := lo.FilterMap(similar, func(item text.Similarity, index int) (string, bool) {
+		return item.Key, item.Distance > 0
+	})
+	return lo.Slice(candidates, 0, maxSpellingCandidates)
+}
+
+// correctQuery expands the barewords of a FTS5 query which are not in the full text index to similar terms in it.
+// It returns the rewritten query, and the best candidates of the misspelled words for the "did you mean" suggestion.
+func (c *Controller) correctQuery(ctx context.Context, query string) (string, map[string]string, error) {
+	analyzer := c.analyzer.Load()
+	corrections := make(map[string]string)
+	var err error
+	corrected := rewriteBarewords(query, func(word string) []string {
+		if err != nil {
+			return nil
+		}
+		term := strings.ToLower(word)
+		distance := maxEditDistance(term)
+		// Words split into tokens are matched by the phrases of tokens, they're not in the full text index as a term
+		if distance == 0 || splitQueryWord(analyzer, word) != nil {
+			return nil
+		}
+		forms := []string{term}
+		if normalized, normalizeErr := analyzer.Normalizer.Normalize(word); normalizeErr == nil && normalized != term {
+			forms = append(forms, normalized)
+		}
+		for _, form := range forms {
+			var exists bool
+			if exists, err = c.termExists(ctx, form); err != nil || exists {
+				return nil
+			}
+		}
+		candidates := c.spellingCandidates(term, distance)
+		if len(candidates) == 0 {
+			return nil
+		}
+		corrections[word] = candidates[0]
+		return candidates
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if len(corrections) == 0 {
+		return query, nil, nil
+	}
+	return corrected, corrections, nil
+}
@@ -0,0 +1,305 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/samber/lo"
+	"github.com/tsingjyujing/vestigo/controller/dao"
+	"github.com/tsingjyujing/vestigo/models"
+	"github.com/tsingjyujing/vestigo/utils"
+)
+
+// TextOrigin tells which model generated a text chunk and by which prompt
+type TextOrigin struct {
+	Model       string `json:"model" jsonschema:"the ID of the generation model"`
+	PromptHash  string `json:"prompt_hash" jsonschema:"the SHA-256 of the system prompt, it changes with the prompt"`
+	GeneratedAt int64  `json:"generated_at" jsonschema:"the unix time of the generation"`
+}
+
+func newTextOrigin(row dao.TextChunk) *TextOrigin {
+	if row.GeneratedBy == "" {
+		return nil
+	}
+	return &TextOrigin{Model: row.GeneratedBy, PromptHash: row.PromptHash, GeneratedAt: row.GeneratedAt}
+}
+
+// promptHash hashes the system prompt of the model, texts generated by other prompts are stale
+func promptHash(model models.GenerationModel) string {
+	sum := sha256.Sum256([]byte(model.SystemPrompt()))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateTexts generates a text from the source texts of the document by each model, models with output schemas
+// extract the fields instead, which are merged in the order of model IDs. The failed models are returned with the errors
+func (c *Controller) generateTexts(ctx context.Context, document models.GenerationDocument, sourceTexts []string, modelIds []string) ([]TextInput, map[string]any, map[string]error) {
+	sort.Strings(modelIds)
+	generatedTexts := make([]TextInput, 0, len(modelIds))
+	fields := make(map[string]any)
+	failures := make(map[string]error)
+	for _, modelId := range modelIds {
+		model := c.generationModels[modelId]
+		generatedText, err := model.Generate(ctx, document, sourceTexts)
+		var output map[string]any
+		if err == nil && model.OutputSchema() != nil {
+			output, err = models.ParseOutput(model.OutputSchema(), generatedText)
+		}
+		if err != nil {
+			logger.WithError(err).WithField("model", modelId).Error("failed to generate text from document")
+			failures[modelId] = err
+			continue
+		}
+		if output != nil {
+			logger.WithField("fields", output).WithField("model", modelId).Debug("extracted fields")
+			maps.Copy(fields, output)
+			continue
+		}
+		logger.WithField("texts", generatedText).WithField("model", modelId).Debug("generated text")
+		generatedTexts = append(generatedTexts, TextInput{
+			Content: generatedText,
+			origin:  &TextOrigin{Model: modelId, PromptHash: promptHash(model), GeneratedAt: time.Now().Unix()},
+		})
+	}
+	return generatedTexts, fields, failures
+}
+
+// mergeGeneratedFields returns the data of a document with the fields extracted by models, which replace the existing ones
+func mergeGeneratedFields(data map[string]any, fields map[string]any) map[string]any {
+	if len(fields) == 0 {
+		return data
+	}
+	merged := make(map[string]any, len(data)+len(fields))
+	maps.Copy(merged, data)
+	maps.Copy(merged, fields)
+	return merged
+}
+
+func newGenerationDocument(row dao.Document) models.GenerationDocument {
+	return models.GenerationDocument{Title: row.Title, Description: row.Description, Data: unmarshalJSONObject(row.Data)}
+}
+
+// parseGenerationModels parses `ai_gen`: true for all generation models, or the comma separated IDs of the models
+func (c *Controller) parseGenerationModels(echoCtx *echo.Context) ([]string, error) {
+	value := strings.TrimSpace(echoCtx.QueryParam("ai_gen"))
+	if value == "" {
+		return nil, nil
+	}
+	if enabled, err := strconv.ParseBool(value); err == nil {
+		if !enabled {
+			return nil, nil
+		}
+		return lo.Keys(c.generationModels), nil
+	}
+	modelIds := lo.Uniq(lo.Compact(lo.Map(strings.Split(value, ","), func(item string, index int) string { return strings.TrimSpace(item) })))
+	for _, modelId := range modelIds {
+		if _, ok := c.generationModels[modelId]; !ok {
+			return nil, fmt.Errorf("generation model '%s' not found", modelId)
+		}
+	}
+	return modelIds, nil
+}
+
+// generatedTextSelector selects generated text chunks by the models, and only the stale ones if stale is set:
+// generated by a model which is no longer configured or by another prompt.
+// The fields extracted by models with output schemas have no text chunks, they're never stale.
+type generatedTextSelector struct {
+	models []string
+	stale  bool
+}
+
+func (s generatedTextSelector) matches(c *Controller, row dao.TextChunk) bool {
+	if row.GeneratedBy == "" {
+		return false
+	}
+	if len(s.models) > 0 && !lo.Contains(s.models, row.GeneratedBy) {
+		return false
+	}
+	if !s.stale {
+		return true
+	}
+	model, ok := c.generationModels[row.GeneratedBy]
+	return !ok || promptHash(model) != row.PromptHash
+}
+
+// parseGeneratedTextSelector parses `model=<id>` (repeatable) and `stale=true`,
+// all generation models are selected by default to regenerate texts, and they must be configured
+func (c *Controller) parseGeneratedTextSelector(echoCtx *echo.Context, regenerate bool) (generatedTextSelector, error) {
+	selector := generatedTextSelector{models: lo.Uniq(lo.Compact(echoCtx.QueryParams()["model"]))}
+	stale, err := strconv.ParseBool(echoCtx.QueryParamOr("stale", "false"))
+	if err != nil {
+		return selector, fmt.Errorf("invalid parameter 'stale': %s", echoCtx.QueryParam("stale"))
+	}
+	selector.stale = stale
+	if regenerate {
+		if len(selector.models) == 0 {
+			selector.models = lo.Keys(c.generationModels)
+		}
+		for _, modelId := range selector.models {
+			if _, ok := c.generationModels[modelId]; !ok {
+				return selector, fmt.Errorf("generation model '%s' not found", modelId)
+			}
+		}
+	}
+	return selector, nil
+}
+
+type GeneratedTextsResponse struct {
+	// Documents is the number of documents with dropped or generated text chunks
+	Documents int `json:"documents"`
+	Dropped   int `json:"dropped"`
+	Generated int `json:"generated"`
+	// Extracted is the number of documents with data fields extracted by models with output schemas
+	Extracted int `json:"extracted"`
+	// Failed is the number of failed generations, the text chunks of failed models are kept
+	Failed   int `json:"failed"`
+	failures map[string]error
+}
+
+func (r *GeneratedTextsResponse) add(other GeneratedTextsResponse) {
+	r.Documents += other.Documents
+	r.Dropped += other.Dropped
+	r.Generated += other.Generated
+	r.Extracted += other.Extracted
+	r.Failed += other.Failed
+}
+
+// refreshGeneratedTexts drops the selected generated text chunks of a document, and generates them again from the
+// source texts if regenerate is set. With stale set, only the models of stale text chunks generate again.
+func (c *Controller) refreshGeneratedTexts(ctx context.Context, docId string, selector generatedTextSelector, regenerate bool, dedupePolicy string) (GeneratedTextsResponse, error) {
+	response := GeneratedTextsResponse{}
+	document, err := c.queries.GetDocument(ctx, docId)
+	if err != nil {
+		return response, err
+	}
+	rows, err := c.queries.ListTextChunksByDocumentID(ctx, docId)
+	if err != nil {
+		return response, err
+	}
+	dropped := lo.Filter(rows, func(item dao.TextChunk, index int) bool { return selector.matches(c, item) })
+	generatedTexts := make([]TextInput, 0)
+	var fields map[string]any
+	if regenerate {
+		modelIds := selector.models
+		if selector.stale {
+			modelIds = lo.Intersect(modelIds, lo.Uniq(lo.Map(dropped, func(item dao.TextChunk, index int) string { return item.GeneratedBy })))
+		}
+		sourceTexts := lo.FilterMap(rows, func(item dao.TextChunk, index int) (string, bool) { return item.Content, item.GeneratedBy == "" })
+		if len(sourceTexts) > 0 && len(modelIds) > 0 {
+			var failures map[string]error
+			generatedTexts, fields, failures = c.generateTexts(ctx, newGenerationDocument(document), sourceTexts, modelIds)
+			response.Failed, response.failures = len(failures), failures
+			// Keep the old texts rather than losing them if the model fails
+			dropped = lo.Filter(dropped, func(item dao.TextChunk, index int) bool { return failures[item.GeneratedBy] == nil })
+		}
+	}
+	if len(dropped) == 0 && len(generatedTexts) == 0 && len(fields) == 0 {
+		return response, nil
+	}
+	deltas := &suggestionDeltas{}
+	_, err = utils.WithTx(
+		ctx,
+		c.db,
+		nil,
+		func(tx *sql.Tx) (any, error) {
+			queries := dao.New(tx)
+			if len(fields) > 0 {
+				dataJSON, err := marshalJSONObject(mergeGeneratedFields(unmarshalJSONObject(document.Data), fields))
+				if err != nil {
+					return nil, err
+				}
+				if err := queries.UpdateDocumentData(ctx, dao.UpdateDocumentDataParams{Data: dataJSON, ID: docId}); err != nil {
+					return nil, err
+				}
+				response.Extracted = 1
+			}
+			for _, row := range dropped {
+				if err := c.deleteTextChunkInternal(ctx, queries, row, deltas); err != nil {
+					return nil, err
+				}
+			}
+			position, err := queries.GetNextTextChunkPosition(ctx, docId)
+			if err != nil {
+				return nil, err
+			}
+			for _, input := range generatedTexts {
+				if _, created, err := c.createTextChunks(ctx, docId, queries, input, position, dedupePolicy, deltas); err != nil {
+					return nil, err
+				} else if created {
+					position++
+					response.Generated++
+				}
+			}
+			return nil, refreshDocumentSimHash(ctx, queries, docId)
+		},
+	)
+	if err != nil {
+		return response, err
+	}
+	c.suggester.apply(c.analyzer.Load(), deltas)
+	response.Documents = 1
+	response.Dropped = len(dropped)
+	return response, nil
+}
+
+// refreshAllGeneratedTexts refreshes the generated text chunks of a document by `doc_id`, or the whole corpus without it
+func (c *Controller) refreshAllGeneratedTexts(echoCtx *echo.Context, regenerate bool) error {
+	ctx := echoCtx.Request().Context()
+	selector, err := c.parseGeneratedTextSelector(echoCtx, regenerate)
+	if err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	dedupePolicy, err := c.getDedupePolicy(echoCtx)
+	if err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	var docIds []string
+	if docId := echoCtx.Param("doc_id"); docId != "" {
+		if _, err := c.queries.GetDocument(ctx, docId); err != nil {
+			return utils.EchoHandleSQLError(echoCtx, err)
+		}
+		docIds = []string{docId}
+	} else if regenerate && !selector.stale {
+		// Every document gets the texts of the models, including the ones never generated
+		docIds, err = c.queries.ListDocumentIDs(ctx)
+	} else {
+		docIds, err = c.queries.ListDocumentIDsWithGeneratedTextChunks(ctx)
+	}
+	if err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	response := GeneratedTextsResponse{}
+	for _, docId := range docIds {
+		if err := ctx.Err(); err != nil {
+			return utils.EchoHandleInternalError(echoCtx, err)
+		}
+		result, err := c.refreshGeneratedTexts(ctx, docId, selector, regenerate, dedupePolicy)
+		if err != nil {
+			return utils.EchoHandleInternalError(echoCtx, fmt.Errorf("failed to refresh generated texts of document %s: %w", docId, err))
+		}
+		response.add(result)
+	}
+	logger.WithField("documents", response.Documents).WithField("dropped", response.Dropped).WithField("generated", response.Generated).WithField("extracted", response.Extracted).Info("Refreshed generated texts")
+	return utils.EchoJsonResponse(echoCtx, response, http.StatusOK)
+}
+
+// DropGeneratedTexts deletes the generated text chunks of a document or the whole corpus,
+// selected by `model=<id>` and `stale=true`
+func (c *Controller) DropGeneratedTexts(echoCtx *echo.Context) error {
+	return c.refreshAllGeneratedTexts(echoCtx, false)
+}
+
+// RegenerateTexts replaces the generated text chunks of a document or the whole corpus by new ones,
+// selected by `model=<id>` and `stale=true`, e.g. after changing the prompts
+func (c *Controller) RegenerateTexts(echoCtx *echo.Context) error {
+	return c.refreshAllGeneratedTexts(echoCtx, true)
+}
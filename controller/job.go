@@ -0,0 +1,447 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+	"github.com/samber/lo"
+	"github.com/tsingjyujing/vestigo/controller/dao"
+	"github.com/tsingjyujing/vestigo/utils"
+)
+
+// JobKindRegenerate regenerates the generated text chunks of documents
+const JobKindRegenerate = "regenerate"
+
+const (
+	JobStatusRunning   = "running"
+	JobStatusPaused    = "paused"
+	JobStatusCancelled = "cancelled"
+	JobStatusCompleted = "completed"
+	JobStatusFailed    = "failed"
+)
+
+// The status of documents in jobs
+const (
+	jobDocumentStatusDone   = "done"
+	jobDocumentStatusFailed = "failed"
+)
+
+const (
+	defaultJobConcurrency = 2
+	// maxJobConcurrency limits the concurrent generations of a job, which are usually limited by the model servers
+	maxJobConcurrency = 16
+)
+
+// jobRunner keeps the cancel functions of the jobs running in this process
+type jobRunner struct {
+	lock    sync.Mutex
+	running map[string]*jobRun
+	wg      sync.WaitGroup
+}
+
+// jobRun is a run of a job, it's kept in running until it exits, a stopped job may be resumed before that
+type jobRun struct {
+	cancel  context.CancelFunc
+	stopped bool
+	// done is closed when the run and its workers exit
+	done chan struct{}
+}
+
+func (run *jobRun) stop() {
+	run.stopped = true
+	run.cancel()
+}
+
+func newJobRunner() *jobRunner {
+	return &jobRunner{running: make(map[string]*jobRun)}
+}
+
+// stop cancels the job if it's running and returns immediately, the run is removed when it exits
+func (r *jobRunner) stop(jobId string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if run, ok := r.running[jobId]; ok {
+		run.stop()
+	}
+}
+
+// close cancels all running jobs and waits for them, their status is kept to resume on the next start
+func (r *jobRunner) close() {
+	r.lock.Lock()
+	for _, run := range r.running {
+		run.stop()
+	}
+	r.lock.Unlock()
+	r.wg.Wait()
+}
+
+// JobParams are the parameters of a regeneration job
+type JobParams struct {
+	// Models are the IDs of generation models to run, all of them if empty
+	Models []string `json:"models,omitempty"`
+	// Stale regenerates only the stale texts: generated by models no longer configured or by another prompt
+	Stale bool `json:"stale,omitempty"`
+	// Concurrency is the number of documents processed at the same time, 2 by default
+	Concurrency int `json:"concurrency,omitempty"`
+	// Dedupe is the policy for generated texts with existing content, see DedupePolicyAllow etc.
+	Dedupe string `json:"dedupe,omitempty"`
+}
+
+type JobFailure struct {
+	DocumentID string `json:"document_id"`
+	Error      string `json:"error"`
+}
+
+type Job struct {
+	ID     string    `json:"id"`
+	Kind   string    `json:"kind"`
+	Status string    `json:"status"`
+	Params JobParams `json:"params"`
+	// Total is the number of documents of the job
+	Total     int64 `json:"total"`
+	Processed int64 `json:"processed"`
+	// Failed is the number of documents failed to process or with failed generations
+	Failed    int64        `json:"failed"`
+	Dropped   int64        `json:"dropped"`
+	Generated int64        `json:"generated"`
+	Error     string       `json:"error,omitempty"`
+	CreatedAt int64        `json:"created_at"`
+	UpdatedAt int64        `json:"updated_at"`
+	Failures  []JobFailure `json:"failures,omitempty"`
+}
+
+func newJob(row dao.Job) Job {
+	params := JobParams{}
+	if err := json.Unmarshal([]byte(row.Params), &params); err != nil {
+		logger.WithError(err).Errorf("Failed to parse the parameters of job %s", row.ID)
+	}
+	return Job{
+		ID:        row.ID,
+		Kind:      row.Kind,
+		Status:    row.Status,
+		Params:    params,
+		Total:     row.Total,
+		Processed: row.Processed,
+		Failed:    row.Failed,
+		Dropped:   row.Dropped,
+		Generated: row.Generated,
+		Error:     row.Error,
+		CreatedAt: row.CreatedAt,
+		UpdatedAt: row.UpdatedAt,
+	}
+}
+
+// validateJobParams fills the default values of the parameters and checks them
+func (c *Controller) validateJobParams(params *JobParams) error {
+	params.Models = lo.Uniq(lo.Compact(params.Models))
+	if len(params.Models) == 0 {
+		params.Models = lo.Keys(c.generationModels)
+	}
+	if len(params.Models) == 0 {
+		return fmt.Errorf("no generation models are configured")
+	}
+	sort.Strings(params.Models)
+	for _, modelId := range params.Models {
+		if _, ok := c.generationModels[modelId]; !ok {
+			return fmt.Errorf("generation model '%s' not found", modelId)
+		}
+	}
+	if params.Concurrency == 0 {
+		params.Concurrency = defaultJobConcurrency
+	}
+	if params.Concurrency < 0 || params.Concurrency > maxJobConcurrency {
+		return fmt.Errorf("invalid concurrency %d, it should be between 1 and %d", params.Concurrency, maxJobConcurrency)
+	}
+	if params.Dedupe == "" {
+		params.Dedupe = lo.Ternary(c.options.DedupePolicy != "", c.options.DedupePolicy, DedupePolicyAllow)
+	}
+	return validateDedupePolicy(params.Dedupe)
+}
+
+// resumeJobs restarts the jobs which were running when the process stopped
+func (c *Controller) resumeJobs(ctx context.Context) error {
+	jobIds, err := c.queries.ListJobIDsByStatus(ctx, JobStatusRunning)
+	if err != nil {
+		return err
+	}
+	for _, jobId := range jobIds {
+		row, err := c.queries.GetJob(ctx, jobId)
+		if err != nil {
+			return err
+		}
+		logger.WithField("job_id", jobId).Info("Resuming job")
+		c.runJob(newJob(row))
+	}
+	return nil
+}
+
+// runJob processes the pending documents of the job in the background until it's done or stopped,
+// it starts after the stopped run of the job exits
+func (c *Controller) runJob(job Job) {
+	r := c.jobs
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	previous := r.running[job.ID]
+	if previous != nil && !previous.stopped {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &jobRun{cancel: cancel, done: make(chan struct{})}
+	r.running[job.ID] = run
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer func() {
+			r.lock.Lock()
+			if r.running[job.ID] == run {
+				delete(r.running, job.ID)
+			}
+			r.lock.Unlock()
+			cancel()
+			close(run.done)
+		}()
+		if previous != nil {
+			// The documents still processed by the stopped run are pending, they would be processed twice
+			<-previous.done
+		}
+		err := c.processJob(ctx, job)
+		if ctx.Err() != nil {
+			// Paused, cancelled or closed, the status is set by the one stopping it
+			return
+		}
+		status, message := JobStatusCompleted, ""
+		if err != nil {
+			logger.WithError(err).WithField("job_id", job.ID).Error("Job failed")
+			status, message = JobStatusFailed, err.Error()
+		}
+		if err := c.queries.UpdateJobStatus(context.Background(), dao.UpdateJobStatusParams{Status: status, Error: message, ID: job.ID}); err != nil {
+			logger.WithError(err).WithField("job_id", job.ID).Error("Failed to update the status of job")
+			return
+		}
+		logger.WithField("job_id", job.ID).WithField("status", status).Info("Job finished")
+	}()
+}
+
+// processJob regenerates the texts of the pending documents by the workers
+func (c *Controller) processJob(ctx context.Context, job Job) error {
+	// The models may be removed from the configuration after a restart
+	for _, modelId := range job.Params.Models {
+		if _, ok := c.generationModels[modelId]; !ok {
+			return fmt.Errorf("generation model '%s' not found", modelId)
+		}
+	}
+	docIds, err := c.queries.ListPendingJobDocumentIDs(ctx, job.ID)
+	if err != nil {
+		return err
+	}
+	selector := generatedTextSelector{models: job.Params.Models, stale: job.Params.Stale}
+	pending := make(chan string)
+	var wg sync.WaitGroup
+	for range max(job.Params.Concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for docId := range pending {
+				if err := c.processJobDocument(ctx, job, docId, selector); err != nil && ctx.Err() == nil {
+					logger.WithError(err).WithField("job_id", job.ID).Error("Failed to record the progress of job")
+				}
+			}
+		}()
+	}
+feed:
+	for _, docId := range docIds {
+		select {
+		case pending <- docId:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(pending)
+	wg.Wait()
+	return nil
+}
+
+// processJobDocument regenerates the texts of a document and records the progress,
+// the document is kept pending if the job is stopped meanwhile
+func (c *Controller) processJobDocument(ctx context.Context, job Job, docId string, selector generatedTextSelector) error {
+	result, err := c.refreshGeneratedTexts(ctx, docId, selector, true, job.Params.Dedupe)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	status, message := jobDocumentStatusDone, ""
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// The document is deleted after the job started
+	case err != nil:
+		status, message = jobDocumentStatusFailed, err.Error()
+	case result.Failed > 0:
+		status = jobDocumentStatusFailed
+		messages := make([]string, 0, len(result.failures))
+		for modelId, failure := range result.failures {
+			messages = append(messages, fmt.Sprintf("%s: %v", modelId, failure))
+		}
+		sort.Strings(messages)
+		message = strings.Join(messages, "; ")
+	}
+	_, err = utils.WithTx(
+		ctx,
+		c.db,
+		nil,
+		func(tx *sql.Tx) (any, error) {
+			queries := dao.New(tx)
+			if err := queries.FinishJobDocument(ctx, dao.FinishJobDocumentParams{Status: status, Error: message, JobID: job.ID, DocumentID: docId}); err != nil {
+				return nil, err
+			}
+			return nil, queries.AddJobProgress(ctx, dao.AddJobProgressParams{
+				Processed: 1,
+				Failed:    int64(lo.Ternary(status == jobDocumentStatusFailed, 1, 0)),
+				Dropped:   int64(result.Dropped),
+				Generated: int64(result.Generated),
+				ID:        job.ID,
+			})
+		},
+	)
+	return err
+}
+
+// StartJob creates a job regenerating the generated texts of the corpus and runs it in the background
+func (c *Controller) StartJob(echoCtx *echo.Context) error {
+	ctx := echoCtx.Request().Context()
+	params := JobParams{}
+	if err := echoCtx.Bind(&params); err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	if err := c.validateJobParams(&params); err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	jobId, err := uuid.NewRandom()
+	if err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	row, err := utils.WithTx(
+		ctx,
+		c.db,
+		nil,
+		func(tx *sql.Tx) (dao.Job, error) {
+			queries := dao.New(tx)
+			var docIds []string
+			var err error
+			if params.Stale {
+				docIds, err = queries.ListDocumentIDsWithGeneratedTextChunks(ctx)
+			} else {
+				docIds, err = queries.ListDocumentIDs(ctx)
+			}
+			if err != nil {
+				return dao.Job{}, err
+			}
+			for _, docId := range docIds {
+				if err := queries.NewJobDocument(ctx, dao.NewJobDocumentParams{JobID: jobId.String(), DocumentID: docId}); err != nil {
+					return dao.Job{}, err
+				}
+			}
+			return queries.NewJob(ctx, dao.NewJobParams{
+				ID:     jobId.String(),
+				Kind:   JobKindRegenerate,
+				Status: JobStatusRunning,
+				Params: string(paramsJSON),
+				Total:  int64(len(docIds)),
+			})
+		},
+	)
+	if err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	job := newJob(row)
+	c.runJob(job)
+	logger.WithField("job_id", job.ID).WithField("documents", job.Total).Info("Started job")
+	return utils.EchoJsonResponse(echoCtx, job, http.StatusAccepted)
+}
+
+// ListJobs returns all jobs, the latest first
+func (c *Controller) ListJobs(echoCtx *echo.Context) error {
+	rows, err := c.queries.ListJobs(echoCtx.Request().Context())
+	if err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	return utils.EchoJsonResponse(echoCtx, lo.Map(rows, func(item dao.Job, index int) Job { return newJob(item) }), http.StatusOK)
+}
+
+// GetJob returns the progress of the job with the failed documents
+func (c *Controller) GetJob(echoCtx *echo.Context) error {
+	ctx := echoCtx.Request().Context()
+	row, err := c.queries.GetJob(ctx, echoCtx.Param("job_id"))
+	if err != nil {
+		return utils.EchoHandleSQLError(echoCtx, err)
+	}
+	job := newJob(row)
+	failures, err := c.queries.ListFailedJobDocuments(ctx, job.ID)
+	if err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	job.Failures = lo.Map(failures, func(item dao.ListFailedJobDocumentsRow, index int) JobFailure {
+		return JobFailure{DocumentID: item.DocumentID, Error: item.Error}
+	})
+	return utils.EchoJsonResponse(echoCtx, job, http.StatusOK)
+}
+
+// setJobStatus moves the job from one of the statuses to the new one, it's a conflict for other statuses
+func (c *Controller) setJobStatus(echoCtx *echo.Context, from []string, to string) error {
+	ctx := echoCtx.Request().Context()
+	jobId := echoCtx.Param("job_id")
+	row, err := c.queries.GetJob(ctx, jobId)
+	if err != nil {
+		return utils.EchoHandleSQLError(echoCtx, err)
+	}
+	if !lo.Contains(from, row.Status) {
+		return utils.EchoHandleGenericError(echoCtx, fmt.Errorf("job %s is %s, it can't be %s", jobId, row.Status, to), http.StatusConflict)
+	}
+	if to == JobStatusRunning {
+		// Check the models before resuming, they may be removed from the configuration
+		job := newJob(row)
+		if err := c.validateJobParams(&job.Params); err != nil {
+			return utils.EchoHandleGenericError(echoCtx, err, http.StatusConflict)
+		}
+	}
+	if err := c.queries.UpdateJobStatus(ctx, dao.UpdateJobStatusParams{Status: to, ID: jobId}); err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	if to == JobStatusRunning {
+		c.runJob(newJob(row))
+	} else {
+		c.jobs.stop(jobId)
+	}
+	row, err = c.queries.GetJob(ctx, jobId)
+	if err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	logger.WithField("job_id", jobId).WithField("status", to).Info("Changed the status of job")
+	return utils.EchoJsonResponse(echoCtx, newJob(row), http.StatusOK)
+}
+
+// PauseJob stops the running job, the pending documents are processed when it's resumed
+func (c *Controller) PauseJob(echoCtx *echo.Context) error {
+	return c.setJobStatus(echoCtx, []string{JobStatusRunning}, JobStatusPaused)
+}
+
+// ResumeJob runs the paused or failed job again from the pending documents
+func (c *Controller) ResumeJob(echoCtx *echo.Context) error {
+	return c.setJobStatus(echoCtx, []string{JobStatusPaused, JobStatusFailed}, JobStatusRunning)
+}
+
+// CancelJob stops the job for good, the processed documents are kept
+func (c *Controller) CancelJob(echoCtx *echo.Context) error {
+	return c.setJobStatus(echoCtx, []string{JobStatusRunning, JobStatusPaused}, JobStatusCancelled)
+}
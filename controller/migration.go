@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// columnMigration adds a column which is missing in databases created by older versions,
+// since CREATE TABLE IF NOT EXISTS in the DDL won't touch existing tables
+type columnMigration struct {
+	table      string
+	column     string
+	definition string
+	// backfill is executed once right after the column is added, can be empty
+	backfill string
+}
+
+var columnMigrations = []columnMigration{
+	{
+		table:      "text_chunk",
+		column:     "position",
+		definition: "INTEGER NOT NULL DEFAULT 0",
+		// Keep the insertion order of existing text chunks as good as we can
+		backfill: `UPDATE text_chunk
+SET position = (SELECT r.rn
+                FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY document_id ORDER BY created_at, id) - 1 AS rn
+                      FROM text_chunk) r
+                WHERE r.id = text_chunk.id)`,
+	},
+	{
+		table:      "text_chunk",
+		column:     "metadata",
+		definition: "TEXT NOT NULL DEFAULT '{}'",
+	},
+	{
+		table:      "text_chunk",
+		column:     "content_hash",
+		definition: "TEXT NOT NULL DEFAULT ''",
+		// Hashes are computed by the controller since it depends on the normalizer
+	},
+	{
+		table:      "text_chunk",
+		column:     "duplicate_of",
+		definition: "TEXT NOT NULL DEFAULT ''",
+	},
+	{
+		table:      "text_chunk",
+		column:     "simhash",
+		definition: "INTEGER NOT NULL DEFAULT 0",
+	},
+	{
+		table:      "text_chunk",
+		column:     "language",
+		definition: "TEXT NOT NULL DEFAULT ''",
+		// Languages are detected when the indexes are rebuilt for the new analyzer
+	},
+	{
+		table:      "text_chunk",
+		column:     "generated_by",
+		definition: "TEXT NOT NULL DEFAULT ''",
+		// The origin of texts generated by older versions is unknown, they are kept as source texts
+	},
+	{
+		table:      "text_chunk",
+		column:     "prompt_hash",
+		definition: "TEXT NOT NULL DEFAULT ''",
+	},
+	{
+		table:      "text_chunk",
+		column:     "generated_at",
+		definition: "INTEGER NOT NULL DEFAULT 0",
+	},
+	{
+		table:      "document",
+		column:     "simhash",
+		definition: "INTEGER NOT NULL DEFAULT 0",
+	},
+	{
+		table:      "text_chunk",
+		column:     "language_detected",
+		definition: "BOOLEAN NOT NULL DEFAULT FALSE",
+		// Whether the languages of older versions were given is unknown, most of them were detected
+		backfill: "UPDATE text_chunk SET language_detected = TRUE",
+	},
+	{
+		table:      "document",
+		column:     "duplicate_of",
+		definition: "TEXT NOT NULL DEFAULT ''",
+	},
+}
+
+// InitDatabase upgrades existing tables and creates the missing ones with the DDL
+func InitDatabase(ctx context.Context, db *sql.DB) error {
+	for _, m := range columnMigrations {
+		if err := m.apply(ctx, db); err != nil {
+			return fmt.Errorf("failed to migrate column %s.%s: %w", m.table, m.column, err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, GetDDL()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m columnMigration) apply(ctx context.Context, db *sql.DB) error {
+	var tableCount int
+	if err := db.QueryRowContext(
+		ctx,
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?",
+		m.table,
+	).Scan(&tableCount); err != nil {
+		return err
+	}
+	if tableCount == 0 {
+		return nil // fresh database, the DDL will create the table with the column
+	}
+	var columnCount int
+	if err := db.QueryRowContext(
+		ctx,
+		"SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?",
+		m.table,
+		m.column,
+	).Scan(&columnCount); err != nil {
+		return err
+	}
+	if columnCount > 0 {
+		return nil
+	}
+	logger.Infof("Adding column %s to table %s", m.column, m.table)
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.definition)); err != nil {
+		return err
+	}
+	if m.backfill != "" {
+		if _, err := db.ExecContext(ctx, m.backfill); err != nil {
+			return err
+		}
+	}
+	return nil
+}
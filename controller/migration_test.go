@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+// TestInitDatabaseMigratesOldSchema tests upgrading a database created before the position column existed
+func TestInitDatabaseMigratesOldSchema(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+CREATE TABLE document
+(
+    id          TEXT PRIMARY KEY,
+    title       TEXT    NOT NULL,
+    description TEXT    NOT NULL DEFAULT '',
+    data        TEXT    NOT NULL DEFAULT '{}',
+    created_at  INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))
+) WITHOUT ROWID;
+CREATE TABLE text_chunk
+(
+    id          TEXT PRIMARY KEY,
+    document_id TEXT    NOT NULL,
+    content     TEXT    NOT NULL,
+    seg_content TEXT    NOT NULL,
+    created_at  INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    FOREIGN KEY (document_id) REFERENCES document (id) ON DELETE CASCADE
+) WITHOUT ROWID;
+INSERT INTO document (id, title) VALUES ('doc', 'old document');
+INSERT INTO text_chunk (id, document_id, content, seg_content, created_at) VALUES
+    ('c', 'doc', 'third', 'third', 3),
+    ('a', 'doc', 'first', 'first', 1),
+    ('b', 'doc', 'second', 'second', 2);
+`)
+	require.NoError(t, err)
+
+	require.NoError(t, InitDatabase(t.Context(), db))
+	// Running it again should be a no-op
+	require.NoError(t, InitDatabase(t.Context(), db))
+
+	rows, err := db.Query("SELECT content, position FROM text_chunk ORDER BY position")
+	require.NoError(t, err)
+	defer rows.Close()
+	contents := make([]string, 0)
+	positions := make([]int64, 0)
+	for rows.Next() {
+		var content string
+		var position int64
+		require.NoError(t, rows.Scan(&content, &position))
+		contents = append(contents, content)
+		positions = append(positions, position)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []string{"first", "second", "third"}, contents)
+	assert.Equal(t, []int64{0, 1, 2}, positions)
+
+	// The languages of existing text chunks are detected again
+	var detected int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM text_chunk WHERE language_detected").Scan(&detected))
+	assert.Equal(t, 3, detected)
+}
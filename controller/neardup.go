@@ -0,0 +1,361 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v5"
+	"github.com/tsingjyujing/vestigo/controller/dao"
+	"github.com/tsingjyujing/vestigo/text"
+	"github.com/tsingjyujing/vestigo/utils"
+)
+
+const (
+	// defaultNearDuplicateDistance is the default max Hamming distance of near-duplicate SimHash signatures
+	defaultNearDuplicateDistance = 6
+	// maxNearDuplicateDistance limits the distance, larger ones match almost everything and make banding useless
+	maxNearDuplicateDistance = 16
+
+	NearDuplicateLevelDocument = "document"
+	NearDuplicateLevelText     = "text"
+)
+
+func validateNearDuplicateDistance(distance int) error {
+	if distance < 0 || distance > maxNearDuplicateDistance {
+		return fmt.Errorf("near duplicate distance should be in [0, %d], got %d", maxNearDuplicateDistance, distance)
+	}
+	return nil
+}
+
+// segSimHash computes the SimHash signature from the tokens in seg_content,
+// which are the tokens from the tokenizer and their normalized forms
+func segSimHash(segContent ...string) int64 {
+	tokens := make([]string, 0)
+	for _, s := range segContent {
+		tokens = append(tokens, strings.Fields(s)...)
+	}
+	// SQLite only has signed integers, keep the bits as they are
+	return int64(text.SimHash(tokens))
+}
+
+func formatSimHash(simhash int64) string {
+	return fmt.Sprintf("%016x", uint64(simhash))
+}
+
+// refreshDocumentSimHash recomputes the signature of the document from all its text chunks
+func refreshDocumentSimHash(ctx context.Context, queries *dao.Queries, docId string) error {
+	rows, err := queries.ListTextChunksByDocumentID(ctx, docId)
+	if err != nil {
+		return err
+	}
+	segContents := make([]string, 0, len(rows))
+	for _, row := range rows {
+		segContents = append(segContents, row.SegContent)
+	}
+	return queries.UpdateDocumentSimHash(ctx, dao.UpdateDocumentSimHashParams{
+		SimHash: segSimHash(segContents...),
+		ID:      docId,
+	})
+}
+
+// backfillSimHashes computes signatures of text chunks and documents created before SimHash was introduced
+func (c *Controller) backfillSimHashes(ctx context.Context) error {
+	count, err := utils.WithTx(
+		ctx,
+		c.db,
+		nil,
+		func(tx *sql.Tx) (int, error) {
+			queries := dao.New(tx)
+			rows, err := queries.ListTextChunksWithoutSimHash(ctx)
+			if err != nil {
+				return 0, err
+			}
+			for _, row := range rows {
+				if err := queries.UpdateTextChunkSimHash(ctx, dao.UpdateTextChunkSimHashParams{
+					SimHash: segSimHash(row.SegContent),
+					ID:      row.ID,
+				}); err != nil {
+					return 0, err
+				}
+			}
+			docIds, err := queries.ListDocumentsWithoutSimHash(ctx)
+			if err != nil {
+				return 0, err
+			}
+			for _, docId := range docIds {
+				if err := refreshDocumentSimHash(ctx, queries, docId); err != nil {
+					return 0, err
+				}
+			}
+			return len(rows) + len(docIds), nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		logger.Infof("Computed SimHash for %d text chunks and documents", count)
+	}
+	return nil
+}
+
+// findNearDuplicateDocument returns the ID of the closest stored document within the distance, or "" if there's none
+func (c *Controller) findNearDuplicateDocument(ctx context.Context, texts []TextInput, excludeId string, distance int) (string, error) {
+	segContents := make([]string, 0, len(texts))
+	for _, t := range texts {
+		segContents = append(segContents, c.segmentText(t.Content, c.detectLanguage(t)))
+	}
+	simhash := uint64(segSimHash(segContents...))
+	if simhash == 0 {
+		return "", nil
+	}
+	rows, err := c.queries.ListDocumentSimHashes(ctx)
+	if err != nil {
+		return "", err
+	}
+	nearest, nearestDistance := "", distance+1
+	for _, row := range rows {
+		if row.ID == excludeId {
+			continue
+		}
+		if d := text.HammingDistance(simhash, uint64(row.SimHash)); d < nearestDistance {
+			nearest, nearestDistance = row.ID, d
+		}
+	}
+	return nearest, nil
+}
+
+// getRejectNearDuplicateDistance returns the distance from the query parameter `near_duplicate_distance`,
+// or the default one from options, 0 means near duplicates are not rejected
+func (c *Controller) getRejectNearDuplicateDistance(echoCtx *echo.Context) (int, error) {
+	distance, err := strconv.Atoi(echoCtx.QueryParamOr("near_duplicate_distance", strconv.Itoa(c.options.RejectNearDuplicateDistance)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid parameter 'near_duplicate_distance': %s", echoCtx.QueryParam("near_duplicate_distance"))
+	}
+	return distance, validateNearDuplicateDistance(distance)
+}
+
+type NearDuplicateItem struct {
+	// ID is the document ID or the text chunk ID depends on the level
+	ID         string `json:"id"`
+	DocumentID string `json:"document_id"`
+	Title      string `json:"title,omitempty"`
+	SimHash    string `json:"simhash"`
+	// Distance is the Hamming distance to the first item of the group
+	Distance int `json:"distance"`
+}
+
+type NearDuplicateGroup struct {
+	Items []NearDuplicateItem `json:"items"`
+}
+
+type NearDuplicateReport struct {
+	Level    string               `json:"level"`
+	Distance int                  `json:"distance"`
+	Groups   []NearDuplicateGroup `json:"groups"`
+}
+
+type simHashEntry struct {
+	id         string
+	documentID string
+	title      string
+	simhash    uint64
+}
+
+func (e simHashEntry) item(distance int) NearDuplicateItem {
+	return NearDuplicateItem{
+		ID:         e.id,
+		DocumentID: e.documentID,
+		Title:      e.title,
+		SimHash:    formatSimHash(int64(e.simhash)),
+		Distance:   distance,
+	}
+}
+
+func (c *Controller) listSimHashEntries(ctx context.Context, level string) ([]simHashEntry, error) {
+	switch level {
+	case NearDuplicateLevelDocument:
+		rows, err := c.queries.ListDocumentSimHashes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]simHashEntry, 0, len(rows))
+		for _, row := range rows {
+			entries = append(entries, simHashEntry{id: row.ID, documentID: row.ID, title: row.Title, simhash: uint64(row.SimHash)})
+		}
+		return entries, nil
+	case NearDuplicateLevelText:
+		rows, err := c.queries.ListTextChunkSimHashes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]simHashEntry, 0, len(rows))
+		for _, row := range rows {
+			entries = append(entries, simHashEntry{id: row.ID, documentID: row.DocumentID, simhash: uint64(row.SimHash)})
+		}
+		return entries, nil
+	}
+	return nil, fmt.Errorf("unknown level '%s', should be one of %s, %s", level, NearDuplicateLevelDocument, NearDuplicateLevelText)
+}
+
+// groupNearDuplicates clusters the entries transitively, two entries are linked if their distance is within maxDistance.
+// Candidates are found by SimHash bands, so not all pairs are compared.
+func groupNearDuplicates(entries []simHashEntry, maxDistance int) [][]int {
+	parent := make([]int, len(entries))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	buckets := make(map[text.SimHashBand][]int)
+	for i, entry := range entries {
+		for _, band := range text.SimHashBands(entry.simhash, maxDistance+1) {
+			buckets[band] = append(buckets[band], i)
+		}
+	}
+	for _, bucket := range buckets {
+		for x := 0; x < len(bucket); x++ {
+			for y := x + 1; y < len(bucket); y++ {
+				i, j := bucket[x], bucket[y]
+				if find(i) == find(j) {
+					continue
+				}
+				if text.HammingDistance(entries[i].simhash, entries[j].simhash) <= maxDistance {
+					parent[find(i)] = find(j)
+				}
+			}
+		}
+	}
+	members := make(map[int][]int)
+	for i := range entries {
+		root := find(i)
+		members[root] = append(members[root], i)
+	}
+	groups := make([][]int, 0)
+	for _, group := range members {
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	// Larger groups first, then by the smallest index to make the result stable
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i]) != len(groups[j]) {
+			return len(groups[i]) > len(groups[j])
+		}
+		return groups[i][0] < groups[j][0]
+	})
+	return groups
+}
+
+func parseNearDuplicateParams(echoCtx *echo.Context) (string, int, error) {
+	level := echoCtx.QueryParamOr("level", NearDuplicateLevelDocument)
+	if level != NearDuplicateLevelDocument && level != NearDuplicateLevelText {
+		return "", 0, fmt.Errorf("invalid parameter 'level': %s", level)
+	}
+	distance, err := strconv.Atoi(echoCtx.QueryParamOr("distance", strconv.Itoa(defaultNearDuplicateDistance)))
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid parameter 'distance': %s", echoCtx.QueryParam("distance"))
+	}
+	return level, distance, validateNearDuplicateDistance(distance)
+}
+
+// ListNearDuplicates reports groups of near-duplicate documents or text chunks in the whole corpus
+func (c *Controller) ListNearDuplicates(echoCtx *echo.Context) error {
+	ctx := echoCtx.Request().Context()
+	level, distance, err := parseNearDuplicateParams(echoCtx)
+	if err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	n, err := strconv.Atoi(echoCtx.QueryParam("n"))
+	if err != nil || n <= 0 {
+		n = 100
+	}
+	entries, err := c.listSimHashEntries(ctx, level)
+	if err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	// Sort by ID so the first item of a group is stable
+	sort.Slice(entries, func(i, j int) bool { return entries[i].id < entries[j].id })
+	groups := groupNearDuplicates(entries, distance)
+	if len(groups) > n {
+		groups = groups[:n]
+	}
+	report := NearDuplicateReport{Level: level, Distance: distance, Groups: make([]NearDuplicateGroup, 0, len(groups))}
+	for _, group := range groups {
+		sort.Ints(group)
+		first := entries[group[0]]
+		items := make([]NearDuplicateItem, 0, len(group))
+		for _, i := range group {
+			items = append(items, entries[i].item(text.HammingDistance(first.simhash, entries[i].simhash)))
+		}
+		report.Groups = append(report.Groups, NearDuplicateGroup{Items: items})
+	}
+	return utils.EchoJsonResponse(echoCtx, report, http.StatusOK)
+}
+
+// ListDocumentNearDuplicates reports near duplicates of a document,
+// at the document level there's a group with the document and similar documents,
+// at the text level there's a group for each text chunk of the document which has similar text chunks
+func (c *Controller) ListDocumentNearDuplicates(echoCtx *echo.Context) error {
+	ctx := echoCtx.Request().Context()
+	docId, err := url.QueryUnescape(echoCtx.Param("doc_id"))
+	if err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	level, distance, err := parseNearDuplicateParams(echoCtx)
+	if err != nil {
+		return utils.EchoHandleGenericError(echoCtx, err, http.StatusBadRequest)
+	}
+	if _, err := c.queries.GetDocument(ctx, docId); err != nil {
+		return utils.EchoHandleSQLError(echoCtx, err)
+	}
+	entries, err := c.listSimHashEntries(ctx, level)
+	if err != nil {
+		return utils.EchoHandleInternalError(echoCtx, err)
+	}
+	report := NearDuplicateReport{Level: level, Distance: distance, Groups: make([]NearDuplicateGroup, 0)}
+	for _, target := range entries {
+		if target.documentID != docId {
+			continue
+		}
+		type match struct {
+			entry    simHashEntry
+			distance int
+		}
+		matches := make([]match, 0)
+		for _, entry := range entries {
+			if entry.id == target.id {
+				continue
+			}
+			if d := text.HammingDistance(target.simhash, entry.simhash); d <= distance {
+				matches = append(matches, match{entry: entry, distance: d})
+			}
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		sort.Slice(matches, func(i, j int) bool {
+			if matches[i].distance != matches[j].distance {
+				return matches[i].distance < matches[j].distance
+			}
+			return matches[i].entry.id < matches[j].entry.id
+		})
+		items := []NearDuplicateItem{target.item(0)}
+		for _, m := range matches {
+			items = append(items, m.entry.item(m.distance))
+		}
+		report.Groups = append(report.Groups, NearDuplicateGroup{Items: items})
+	}
+	return utils.EchoJsonResponse(echoCtx, report, http.StatusOK)
+}
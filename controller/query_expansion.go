@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/samber/lo"
+	"github.com/tsingjyujing/vestigo/text"
+)
+
+// fts5Operators are the barewords with special meanings in FTS5 queries
+var fts5Operators = map[string]bool{"AND": true, "OR": true, "NOT": true, "NEAR": true}
+
+// synonymTokens returns the canonical terms of tokens having synonyms, they are indexed along with the tokens
+// so texts match queries using any term of the group
+func synonymTokens(analyzer *text.Analyzer, tokens []string) []string {
+	canonicalTokens := make([]string, 0)
+	for _, token := range tokens {
+		if canonical, ok := analyzer.Synonym(token); ok {
+			canonicalTokens = append(canonicalTokens, canonical)
+		}
+	}
+	return canonicalTokens
+}
+
+// splitQueryWord tokenizes a query word by the tokenizer of its detected language, it returns the tokens if the word
+// is split into several ones, e.g. the n-grams of Korean words, or nil if the word is a token itself
+func splitQueryWord(analyzer *text.Analyzer, word string) []string {
+	tokens := analyzer.TokenizerOf(analyzer.DetectLanguage(word)).Tokenize(word)
+	if len(tokens) < 2 {
+		return nil
+	}
+	return tokens
+}
+
+// expandQuery rewrites the barewords in a FTS5 query to match their normalized forms (e.g. stems) and
+// canonical synonyms too, as texts are indexed with them. Texts indexed before a synonym was added still match the original word.
+// Words split by the tokenizer of their language also match the phrase of their tokens, like texts are indexed.
+func expandQuery(analyzer *text.Analyzer, query string) string {
+	return rewriteBarewords(query, func(word string) []string {
+		alternatives := make([]string, 0)
+		if tokens := splitQueryWord(analyzer, word); tokens != nil {
+			alternatives = append(alternatives, strings.Join(tokens, " "))
+		}
+		if normalized, err := analyzer.Normalizer.Normalize(word); err == nil && normalized != "" && normalized != strings.ToLower(word) {
+			alternatives = append(alternatives, normalized)
+		}
+		if canonical, ok := analyzer.Synonym(word); ok && !lo.Contains(alternatives, canonical) {
+			alternatives = append(alternatives, canonical)
+		}
+		return alternatives
+	})
+}
+
+// fts5Segment is a part of a FTS5 query: spaces, a quoted phrase, a parenthesis or a word
+type fts5Segment struct {
+	text string
+	// bareword is true for words which can be rewritten, i.e. not operators, not in NEAR groups
+	// and without special characters like "*" or ":"
+	bareword bool
+}
+
+func (s fts5Segment) isSpace() bool {
+	return strings.TrimSpace(s.text) == ""
+}
+
+// startsOperand reports whether the segment can be the beginning of an operand of AND
+func (s fts5Segment) startsOperand() bool {
+	return !s.isSpace() && s.text != ")" && !fts5Operators[s.text]
+}
+
+// endsOperand reports whether the segment can be the end of an operand of AND
+func (s fts5Segment) endsOperand() bool {
+	return !s.isSpace() && s.text != "(" && !fts5Operators[s.text] && !strings.HasSuffix(s.text, ":") && s.text != "^"
+}
+
+// scanFTS5Query splits a FTS5 query into segments, joining the texts of segments gives the query
+func scanFTS5Query(query string) []fts5Segment {
+	runes := []rune(query)
+	segments := make([]fts5Segment, 0)
+	for i := 0; i < len(runes); {
+		start := i
+		switch {
+		case unicode.IsSpace(runes[i]):
+			for i < len(runes) && unicode.IsSpace(runes[i]) {
+				i++
+			}
+		case runes[i] == '"':
+			// "" is an escaped quote in a phrase
+			for i++; i < len(runes); i++ {
+				if runes[i] == '"' {
+					if i+1 < len(runes) && runes[i+1] == '"' {
+						i++
+						continue
+					}
+					i++
+					break
+				}
+			}
+		case runes[i] == '(' || runes[i] == ')':
+			i++
+		default:
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`"()`, runes[i]) {
+				i++
+			}
+		}
+		segments = append(segments, fts5Segment{text: string(runes[start:i])})
+	}
+	// Words in NEAR groups can't be replaced with groups
+	nearDepth := 0
+	for i, segment := range segments {
+		switch {
+		case segment.text == "(" && (nearDepth > 0 || (i > 0 && segments[i-1].text == "NEAR")):
+			nearDepth++
+		case segment.text == ")" && nearDepth > 0:
+			nearDepth--
+		case nearDepth == 0 && !segment.isSpace() && segment.text != "(" && segment.text != ")" && !strings.HasPrefix(segment.text, `"`):
+			segments[i].bareword = !fts5Operators[segment.text] && !strings.ContainsFunc(segment.text, isNotBarewordRune) &&
+				// the word after a column filter like "seg_content :"
+				!(i > 1 && strings.HasSuffix(segments[i-2].text, ":"))
+		}
+	}
+	return segments
+}
+
+// rewriteBarewords replaces the barewords having alternatives with groups like `(word OR "alternative")`.
+// FTS5 doesn't allow implicit ANDs next to parentheses, so explicit ANDs are added around the groups.
+func rewriteBarewords(query string, alternativesOf func(word string) []string) string {
+	segments := scanFTS5Query(query)
+	var builder strings.Builder
+	rewritten := false
+	var previous fts5Segment
+	previousIsGroup := false
+	for _, segment := range segments {
+		isGroup := false
+		output := segment.text
+		if segment.bareword {
+			if alternatives := alternativesOf(segment.text); len(alternatives) > 0 {
+				quoted := lo.Map(alternatives, func(item string, index int) string { return `"` + strings.ReplaceAll(item, `"`, `""`) + `"` })
+				output = "(" + segment.text + " OR " + strings.Join(quoted, " OR ") + ")"
+				isGroup = true
+				rewritten = true
+			}
+		}
+		if segment.isSpace() {
+			builder.WriteString(output)
+			continue
+		}
+		if (isGroup || previousIsGroup) && segment.startsOperand() && previous.endsOperand() {
+			if !strings.HasSuffix(builder.String(), " ") {
+				builder.WriteString(" ")
+			}
+			builder.WriteString("AND ")
+		}
+		builder.WriteString(output)
+		previous = segment
+		previousIsGroup = isGroup
+	}
+	if !rewritten {
+		return query
+	}
+	return builder.String()
+}
+
+// isNotBarewordRune reports whether the rune can't be in a FTS5 bareword
+func isNotBarewordRune(r rune) bool {
+	return r < 0x80 && !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+}
@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteBarewords(t *testing.T) {
+	alternatives := map[string][]string{"cat": {"kitten"}, "dog": {"puppy", "hound"}}
+	rewrite := func(query string) string {
+		return rewriteBarewords(query, func(word string) []string { return alternatives[word] })
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "cat", want: `(cat OR "kitten")`},
+		{query: "big cat", want: `big AND (cat OR "kitten")`},
+		{query: "cat dog", want: `(cat OR "kitten") AND (dog OR "puppy" OR "hound")`},
+		{query: "cat OR fish", want: `(cat OR "kitten") OR fish`},
+		{query: `"big cat" dog`, want: `"big cat" AND (dog OR "puppy" OR "hound")`},
+		{query: "NEAR(cat fish, 3)", want: "NEAR(cat fish, 3)"},
+		{query: "cat* fish", want: "cat* fish"},
+		{query: "(fish cat)", want: `(fish AND (cat OR "kitten"))`},
+		{query: "fish", want: "fish"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, rewrite(tt.query), tt.query)
+	}
+
+	// The rewritten queries are valid for FTS5
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`CREATE VIRTUAL TABLE fts USING fts5(content)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO fts (content) VALUES ('the big kitten and the puppy')`)
+	require.NoError(t, err)
+	for _, tt := range tests {
+		var count int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM fts WHERE content MATCH ?`, tt.want).Scan(&count), tt.want)
+	}
+	var count int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM fts WHERE content MATCH ?`, rewrite("big cat dog")).Scan(&count))
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,682 @@
+package controller
+
+// The Vestigo query language of the `q` parameter of searches:
+//
+//	federation            texts containing the word
+//	galactic federation   texts containing both words, the same as `galactic AND federation`
+//	cat OR dog            texts containing either word, AND binds tighter than OR
+//	"galactic federation" the phrase, `"galactic fed"*` matches phrases with the last word as a prefix
+//	feder*                words with the prefix
+//	(cat OR dog) food     parentheses group sub-queries
+//	-dog, NOT dog         texts without the word, phrase or group, there must be other terms to exclude from
+//	star NEAR/5 wars      the terms within 5 words of each other, NEAR alone is NEAR/10,
+//	                      `a NEAR/5 b NEAR/5 c` keeps all of them close
+//	title:federation      documents whose title contains the text, `title:"star wars"` for texts with spaces
+//	data.category:news    documents whose data has the value or an array containing the value at the key
+//	metadata.page:2       text chunks whose metadata has the value at the key, like the `metadata.<key>` parameter
+//	created_at:2024-01-01..2024-06-30
+//	                      documents created in the date range, either end can be omitted, e.g. `created_at:2024-01-01..`,
+//	                      comparisons like `created_at:>=2024-01-01` and a single day `created_at:2024-01-01` work too.
+//	                      Dates are YYYY-MM-DD in UTC, RFC 3339 times or Unix timestamps.
+//
+// Field filters are SQL conditions rather than full text matches, so they can only be combined with AND at the top level,
+// and excluded by `-`. The full text part is compiled to a FTS5 query for BM25, and to plain text for embedding models.
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/samber/lo"
+)
+
+const (
+	// defaultNearDistance is the distance of NEAR without /n, the same as the default of FTS5
+	defaultNearDistance = 10
+	queryFieldTitle     = "title"
+	queryFieldCreatedAt = "created_at"
+	queryFieldData      = "data."
+	queryFieldMetadata  = "metadata."
+)
+
+// errInvalidQuery is the error of malformed queries, they are bad requests
+var errInvalidQuery = errors.New("invalid query")
+
+func invalidQueryError(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", errInvalidQuery, fmt.Sprintf(format, args...))
+}
+
+type queryTokenKind int
+
+const (
+	queryTokenWord queryTokenKind = iota
+	queryTokenPhrase
+	queryTokenField
+	queryTokenLeftParen
+	queryTokenRightParen
+	queryTokenMinus
+	queryTokenAnd
+	queryTokenOr
+	queryTokenNot
+	queryTokenNear
+)
+
+type queryToken struct {
+	kind queryTokenKind
+	// text is the word, the phrase without quotes, or the value of a field
+	text string
+	// field is the name of a field token, e.g. "title" or "data.category"
+	field string
+	// prefix is true for words and phrases ending with "*"
+	prefix bool
+	// distance of NEAR
+	distance int
+	// start and end are the byte offsets in the query
+	start, end int
+}
+
+// lexQuery splits a query into tokens
+func lexQuery(query string) ([]queryToken, error) {
+	tokens := make([]queryToken, 0)
+	for i := 0; i < len(query); {
+		r, size := utf8.DecodeRuneInString(query[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '(':
+			tokens = append(tokens, queryToken{kind: queryTokenLeftParen, start: i, end: i + 1})
+			i++
+		case r == ')':
+			tokens = append(tokens, queryToken{kind: queryTokenRightParen, start: i, end: i + 1})
+			i++
+		case r == '"':
+			phrase, end, err := lexPhrase(query, i)
+			if err != nil {
+				return nil, err
+			}
+			phrase.start = i
+			tokens = append(tokens, phrase)
+			i = end
+		case r == '-' && i+1 < len(query) && startsQueryWord(query[i+1:]):
+			tokens = append(tokens, queryToken{kind: queryTokenMinus, start: i, end: i + 1})
+			i++
+		default:
+			end := i
+			for end < len(query) && startsQueryWord(query[end:]) {
+				_, size := utf8.DecodeRuneInString(query[end:])
+				end += size
+			}
+			word := query[i:end]
+			token := queryToken{kind: queryTokenWord, text: word, start: i, end: end}
+			switch {
+			case word == "AND":
+				token.kind = queryTokenAnd
+			case word == "OR":
+				token.kind = queryTokenOr
+			case word == "NOT":
+				token.kind = queryTokenNot
+			case word == "NEAR" || strings.HasPrefix(word, "NEAR/"):
+				token.kind = queryTokenNear
+				token.distance = defaultNearDistance
+				if word != "NEAR" {
+					distance, err := strconv.Atoi(strings.TrimPrefix(word, "NEAR/"))
+					if err != nil || distance < 0 {
+						return nil, invalidQueryError("invalid distance of %s, it should be like NEAR/5", word)
+					}
+					token.distance = distance
+				}
+			default:
+				if field, value, ok := strings.Cut(word, ":"); ok && isQueryField(field) {
+					token.kind = queryTokenField
+					token.field = field
+					token.text = value
+					if value == "" && end < len(query) && query[end] == '"' {
+						// title:"star wars"
+						phrase, phraseEnd, err := lexPhrase(query, end)
+						if err != nil {
+							return nil, err
+						}
+						token.text = phrase.text
+						end = phraseEnd
+					}
+					if token.text == "" {
+						return nil, invalidQueryError("missing value of field %s", field)
+					}
+					token.end = end
+				} else if ok && isFieldName(field) && value != "" && !strings.HasPrefix(value, "/") {
+					return nil, invalidQueryError("unknown field %s, the fields are title, created_at, data.<key> and metadata.<key>", field)
+				} else if strings.HasSuffix(word, "*") {
+					token.text = strings.TrimSuffix(word, "*")
+					token.prefix = true
+					if token.text == "" || strings.Contains(token.text, "*") {
+						return nil, invalidQueryError("invalid prefix %s", word)
+					}
+				}
+			}
+			tokens = append(tokens, token)
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// lexPhrase reads a quoted phrase starting at the quote, followed by "*" for prefix phrases
+func lexPhrase(query string, start int) (queryToken, int, error) {
+	end := strings.IndexByte(query[start+1:], '"')
+	if end < 0 {
+		return queryToken{}, 0, invalidQueryError("unterminated phrase %s", query[start:])
+	}
+	end += start + 1
+	token := queryToken{kind: queryTokenPhrase, text: query[start+1 : end], start: start}
+	end++
+	if end < len(query) && query[end] == '*' {
+		token.prefix = true
+		end++
+	}
+	token.end = end
+	if strings.TrimSpace(token.text) == "" {
+		return queryToken{}, 0, invalidQueryError("empty phrase")
+	}
+	return token, end, nil
+}
+
+// startsQueryWord reports whether the first rune of s can be in a word, i.e. not a space, a quote or a parenthesis
+func startsQueryWord(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return !unicode.IsSpace(r) && !strings.ContainsRune(`()"`, r)
+}
+
+func isQueryField(field string) bool {
+	return field == queryFieldTitle || field == queryFieldCreatedAt ||
+		(strings.HasPrefix(field, queryFieldData) && len(field) > len(queryFieldData)) ||
+		(strings.HasPrefix(field, queryFieldMetadata) && len(field) > len(queryFieldMetadata))
+}
+
+// isFieldName reports whether a word before ":" looks like a field name rather than a part of text like "10:30"
+func isFieldName(field string) bool {
+	return field != "" && !strings.ContainsFunc(field, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' || r == '.')
+	})
+}
+
+// queryNode is a node of the syntax tree of a query
+type queryNode interface {
+	isQueryNode()
+}
+
+// termNode is a word or a phrase
+type termNode struct {
+	token queryToken
+}
+
+// nearNode matches terms close to each other
+type nearNode struct {
+	terms    []termNode
+	distance int
+}
+
+type andNode struct {
+	children []queryNode
+}
+
+type orNode struct {
+	children []queryNode
+}
+
+type notNode struct {
+	child queryNode
+}
+
+// filterNode is a field filter
+type filterNode struct {
+	token queryToken
+}
+
+func (termNode) isQueryNode()   {}
+func (nearNode) isQueryNode()   {}
+func (andNode) isQueryNode()    {}
+func (orNode) isQueryNode()     {}
+func (notNode) isQueryNode()    {}
+func (filterNode) isQueryNode() {}
+
+// queryParser is a recursive descent parser, from the lowest precedence: OR, AND, NOT / -, NEAR
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() *queryToken {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	children := make([]queryNode, 0, 1)
+	for {
+		child, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		if token := p.peek(); token == nil || token.kind != queryTokenOr {
+			break
+		}
+		p.pos++
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return orNode{children: children}, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	children := make([]queryNode, 0, 1)
+	for {
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		token := p.peek()
+		if token != nil && token.kind == queryTokenAnd {
+			p.pos++
+			continue
+		}
+		// Implicit AND until OR, ")" or the end
+		if token == nil || token.kind == queryTokenOr || token.kind == queryTokenRightParen {
+			break
+		}
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return andNode{children: children}, nil
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	if token := p.peek(); token != nil && (token.kind == queryTokenMinus || token.kind == queryTokenNot) {
+		p.pos++
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := child.(notNode); ok {
+			return nil, invalidQueryError("double negation at %d", token.start)
+		}
+		return notNode{child: child}, nil
+	}
+	return p.parseNear()
+}
+
+func (p *queryParser) parseNear() (queryNode, error) {
+	first, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	token := p.peek()
+	if token == nil || token.kind != queryTokenNear {
+		return first, nil
+	}
+	near := nearNode{distance: token.distance}
+	for operand := first; ; {
+		term, ok := operand.(termNode)
+		if !ok {
+			return nil, invalidQueryError("NEAR only accepts words and phrases at %d", token.start)
+		}
+		near.terms = append(near.terms, term)
+		token = p.peek()
+		if token == nil || token.kind != queryTokenNear {
+			break
+		}
+		if token.distance != near.distance {
+			return nil, invalidQueryError("chained NEAR operators should have the same distance at %d", token.start)
+		}
+		p.pos++
+		if operand, err = p.parsePrimary(); err != nil {
+			return nil, err
+		}
+	}
+	return near, nil
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	token := p.peek()
+	if token == nil {
+		return nil, invalidQueryError("unexpected end of query")
+	}
+	p.pos++
+	switch token.kind {
+	case queryTokenWord, queryTokenPhrase:
+		return termNode{token: *token}, nil
+	case queryTokenField:
+		return filterNode{token: *token}, nil
+	case queryTokenLeftParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.peek(); closing == nil || closing.kind != queryTokenRightParen {
+			return nil, invalidQueryError("unclosed parenthesis at %d", token.start)
+		}
+		p.pos++
+		return node, nil
+	case queryTokenRightParen:
+		return nil, invalidQueryError("unexpected ) at %d", token.start)
+	case queryTokenAnd, queryTokenOr, queryTokenNear:
+		return nil, invalidQueryError("missing operand before %s at %d", token.describe(), token.start)
+	default:
+		return nil, invalidQueryError("unexpected %s at %d", token.describe(), token.start)
+	}
+}
+
+func (t queryToken) describe() string {
+	switch t.kind {
+	case queryTokenAnd:
+		return "AND"
+	case queryTokenOr:
+		return "OR"
+	case queryTokenNot:
+		return "NOT"
+	case queryTokenNear:
+		return "NEAR"
+	case queryTokenMinus:
+		return "-"
+	default:
+		return strconv.Quote(t.text)
+	}
+}
+
+// parsedQuery is a query compiled for searches
+type parsedQuery struct {
+	// source is the query as it's given
+	source string
+	tokens []queryToken
+	// fts5 is the full text part as a FTS5 query, "" if there are only filters
+	fts5 string
+	// text is the full text part as plain text for embedding models, excluded terms are dropped
+	text string
+	// filter are the field filters
+	filter *searchFilter
+}
+
+// parseQuery parses a query of the Vestigo query language, errors wrap errInvalidQuery
+func parseQuery(query string) (*parsedQuery, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, invalidQueryError("empty query")
+	}
+	parser := &queryParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token := parser.peek(); token != nil {
+		return nil, invalidQueryError("unexpected %s at %d", token.describe(), token.start)
+	}
+	parsed := &parsedQuery{source: query, tokens: tokens, filter: &searchFilter{}}
+	// Split the filters at the top level from the full text part
+	topLevel := []queryNode{root}
+	if and, ok := root.(andNode); ok {
+		topLevel = and.children
+	}
+	textNodes := make([]queryNode, 0, len(topLevel))
+	for _, node := range topLevel {
+		negated := false
+		filter, ok := node.(filterNode)
+		if not, isNot := node.(notNode); isNot {
+			filter, ok = not.child.(filterNode)
+			negated = true
+		}
+		if !ok {
+			textNodes = append(textNodes, node)
+			continue
+		}
+		clause, args, err := filter.compile()
+		if err != nil {
+			return nil, err
+		}
+		if negated {
+			clause = "NOT (" + clause + ")"
+		}
+		parsed.filter.add(clause, args...)
+	}
+	if len(textNodes) == 0 {
+		return parsed, nil
+	}
+	textRoot := textNodes[0]
+	if len(textNodes) > 1 {
+		textRoot = andNode{children: textNodes}
+	}
+	if parsed.fts5, err = compileFTS5(textRoot); err != nil {
+		return nil, err
+	}
+	parsed.text = strings.Join(plainTerms(textRoot), " ")
+	return parsed, nil
+}
+
+// compileFTS5 compiles the full text part of a query to FTS5, with explicit ANDs and parentheses
+// so it can be rewritten by rewriteBarewords
+func compileFTS5(node queryNode) (string, error) {
+	switch n := node.(type) {
+	case termNode:
+		return fts5Term(n.token), nil
+	case nearNode:
+		terms := lo.Map(n.terms, func(item termNode, index int) string { return fts5Term(item.token) })
+		return fmt.Sprintf("NEAR(%s, %d)", strings.Join(terms, " "), n.distance), nil
+	case orNode:
+		children := make([]string, 0, len(n.children))
+		for _, child := range n.children {
+			if _, ok := child.(notNode); ok {
+				return "", invalidQueryError("exclusions can't be alternatives of OR, e.g. `a OR -b`")
+			}
+			compiled, err := compileFTS5Operand(child)
+			if err != nil {
+				return "", err
+			}
+			children = append(children, compiled)
+		}
+		return strings.Join(children, " OR "), nil
+	case andNode:
+		included := make([]string, 0, len(n.children))
+		excluded := make([]string, 0)
+		for _, child := range n.children {
+			target := &included
+			if not, ok := child.(notNode); ok {
+				child = not.child
+				target = &excluded
+			}
+			compiled, err := compileFTS5Operand(child)
+			if err != nil {
+				return "", err
+			}
+			*target = append(*target, compiled)
+		}
+		if len(included) == 0 {
+			return "", invalidQueryError("a query can't only exclude terms")
+		}
+		compiled := strings.Join(included, " AND ")
+		if len(excluded) > 0 {
+			if len(included) > 1 {
+				compiled = "(" + compiled + ")"
+			}
+			for _, item := range excluded {
+				compiled += " NOT " + item
+			}
+		}
+		return compiled, nil
+	case notNode:
+		return "", invalidQueryError("a query can't only exclude terms")
+	case filterNode:
+		return "", invalidQueryError("field filter %s can only be combined with AND at the top level", n.token.field)
+	}
+	return "", fmt.Errorf("unknown query node %T", node)
+}
+
+// compileFTS5Operand compiles a node as an operand of AND or OR, with parentheses if it's composite
+func compileFTS5Operand(node queryNode) (string, error) {
+	compiled, err := compileFTS5(node)
+	if err != nil {
+		return "", err
+	}
+	switch node.(type) {
+	case andNode, orNode:
+		return "(" + compiled + ")", nil
+	}
+	return compiled, nil
+}
+
+// fts5Term compiles a word or a phrase, words are kept as barewords when FTS5 allows, so they can be corrected and expanded
+func fts5Term(token queryToken) string {
+	term := token.text
+	if token.kind == queryTokenPhrase || strings.ContainsFunc(term, isNotBarewordRune) || fts5Operators[term] {
+		term = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		if token.prefix {
+			term += " "
+		}
+	}
+	if token.prefix {
+		term += "*"
+	}
+	return term
+}
+
+// plainTerms returns the words and phrases which are not excluded
+func plainTerms(node queryNode) []string {
+	switch n := node.(type) {
+	case termNode:
+		return []string{n.token.text}
+	case nearNode:
+		return lo.Map(n.terms, func(item termNode, index int) string { return item.token.text })
+	case andNode:
+		return lo.FlatMap(n.children, func(item queryNode, index int) []string { return plainTerms(item) })
+	case orNode:
+		return lo.FlatMap(n.children, func(item queryNode, index int) []string { return plainTerms(item) })
+	}
+	return nil
+}
+
+// compile builds the SQL condition of the filter on the joined `text_chunk tc` and `document d` tables
+func (f filterNode) compile() (string, []any, error) {
+	field, value := f.token.field, f.token.text
+	switch {
+	case field == queryFieldTitle:
+		return "instr(lower(d.title), lower(?)) > 0", []any{value}, nil
+	case field == queryFieldCreatedAt:
+		return compileTimeRange("d.created_at", value)
+	case strings.HasPrefix(field, queryFieldData):
+		return compileJSONFilter(dataFilterClause, strings.TrimPrefix(field, queryFieldData), value)
+	case strings.HasPrefix(field, queryFieldMetadata):
+		return compileJSONFilter(metadataFilterClause, strings.TrimPrefix(field, queryFieldMetadata), value)
+	}
+	return "", nil, invalidQueryError("unknown field %s", field)
+}
+
+// compileJSONFilter matches the value at the key by the clause of the `data.` or `metadata.` parameters,
+// so both of them compare the values in the same way
+func compileJSONFilter(clauseTemplate, key, value string) (string, []any, error) {
+	path, err := jsonPath(key)
+	if err != nil {
+		return "", nil, invalidQueryError("invalid key of field: %s", err.Error())
+	}
+	return fmt.Sprintf(clauseTemplate, "?"), []any{path, value}, nil
+}
+
+// compileTimeRange compiles ranges like `2024-01-01..2024-06-30`, `>=2024-01-01` or `2024-01-01` of Unix timestamps
+func compileTimeRange(column, value string) (string, []any, error) {
+	var from, until *int64 // from is inclusive, until is exclusive
+	setFrom := func(bound string, exclusive bool) error {
+		start, end, err := parseTimeBound(bound)
+		if err == nil {
+			from = lo.ToPtr(lo.Ternary(exclusive, end, start))
+		}
+		return err
+	}
+	setUntil := func(bound string, inclusive bool) error {
+		start, end, err := parseTimeBound(bound)
+		if err == nil {
+			until = lo.ToPtr(lo.Ternary(inclusive, end, start))
+		}
+		return err
+	}
+	var err error
+	switch {
+	case strings.Contains(value, ".."):
+		lower, upper, _ := strings.Cut(value, "..")
+		if lower == "" && upper == "" {
+			return "", nil, invalidQueryError("empty range of %s", queryFieldCreatedAt)
+		}
+		if lower != "" {
+			err = setFrom(lower, false)
+		}
+		if err == nil && upper != "" {
+			err = setUntil(upper, true)
+		}
+	case strings.HasPrefix(value, ">="):
+		err = setFrom(value[2:], false)
+	case strings.HasPrefix(value, ">"):
+		err = setFrom(value[1:], true)
+	case strings.HasPrefix(value, "<="):
+		err = setUntil(value[2:], true)
+	case strings.HasPrefix(value, "<"):
+		err = setUntil(value[1:], false)
+	default:
+		if err = setFrom(value, false); err == nil {
+			err = setUntil(value, true)
+		}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	clauses := make([]string, 0, 2)
+	args := make([]any, 0, 2)
+	if from != nil {
+		clauses = append(clauses, column+" >= ?")
+		args = append(args, *from)
+	}
+	if until != nil {
+		clauses = append(clauses, column+" < ?")
+		args = append(args, *until)
+	}
+	return strings.Join(clauses, " AND "), args, nil
+}
+
+// parseTimeBound parses a date, a RFC 3339 time or a Unix timestamp, and returns the time span it covers,
+// e.g. a date covers the whole day
+func parseTimeBound(value string) (start int64, end int64, err error) {
+	if timestamp, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return timestamp, timestamp + 1, nil
+	}
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date.Unix(), date.AddDate(0, 0, 1).Unix(), nil
+	}
+	if moment, err := time.Parse(time.RFC3339, value); err == nil {
+		return moment.Unix(), moment.Unix() + 1, nil
+	}
+	return 0, 0, invalidQueryError("invalid time %q of %s, it should be YYYY-MM-DD, a RFC 3339 time or a Unix timestamp", value, queryFieldCreatedAt)
+}
+
+// replaceWords replaces the words of the query in the replacements, e.g. to show the corrected query
+func (q *parsedQuery) replaceWords(replacements map[string]string) string {
+	var builder strings.Builder
+	last := 0
+	for _, token := range q.tokens {
+		replacement, ok := replacements[token.text]
+		if token.kind != queryTokenWord || token.prefix || !ok {
+			continue
+		}
+		builder.WriteString(q.source[last:token.start])
+		builder.WriteString(replacement)
+		last = token.end
+	}
+	builder.WriteString(q.source[last:])
+	return builder.String()
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		fts5    string
+		text    string
+		clauses []string
+		args    []any
+	}{
+		{query: "federation", fts5: "federation", text: "federation"},
+		{query: "galactic federation", fts5: "galactic AND federation", text: "galactic federation"},
+		{query: "cat OR dog food", fts5: "cat OR (dog AND food)", text: "cat dog food"},
+		{query: "(cat OR dog) AND food", fts5: "(cat OR dog) AND food", text: "cat dog food"},
+		{query: `"galactic federation" planets`, fts5: `"galactic federation" AND planets`, text: "galactic federation planets"},
+		{query: `"galactic fed"*`, fts5: `"galactic fed" *`, text: "galactic fed"},
+		{query: "feder*", fts5: "feder*", text: "feder"},
+		{query: "star NEAR/5 wars", fts5: "NEAR(star wars, 5)", text: "star wars"},
+		{query: `star NEAR "the empire" NEAR wars`, fts5: `NEAR(star "the empire" wars, 10)`, text: "star the empire wars"},
+		{query: "federation -empire", fts5: "federation NOT empire", text: "federation"},
+		{query: "galactic federation NOT (empire OR rebels)", fts5: "(galactic AND federation) NOT (empire OR rebels)", text: "galactic federation"},
+		{query: "c++ 10:30 and", fts5: `"c++" AND "10:30" AND and`, text: "c++ 10:30 and"},
+		{query: "联邦 政府*", fts5: "联邦 AND 政府*", text: "联邦 政府"},
+		{
+			query:   `federation title:"star wars"`,
+			fts5:    "federation",
+			text:    "federation",
+			clauses: []string{"instr(lower(d.title), lower(?)) > 0"},
+			args:    []any{"star wars"},
+		},
+		{
+			query:   "federation data.category:news -metadata.page:2",
+			fts5:    "federation",
+			text:    "federation",
+			clauses: []string{fmt.Sprintf(dataFilterClause, "?"), "NOT (" + fmt.Sprintf(metadataFilterClause, "?") + ")"},
+			args:    []any{`$."category"`, "news", `$."page"`, "2"},
+		},
+		{
+			query:   "federation created_at:2024-01-01..2024-01-31",
+			fts5:    "federation",
+			text:    "federation",
+			clauses: []string{"d.created_at >= ? AND d.created_at < ?"},
+			args:    []any{int64(1704067200), int64(1706745600)},
+		},
+		{
+			query:   "federation created_at:>2024-01-01 created_at:<=1706745600",
+			fts5:    "federation",
+			text:    "federation",
+			clauses: []string{"d.created_at >= ?", "d.created_at < ?"},
+			args:    []any{int64(1704153600), int64(1706745601)},
+		},
+		{
+			query:   "federation created_at:2024-01-01",
+			fts5:    "federation",
+			text:    "federation",
+			clauses: []string{"d.created_at >= ? AND d.created_at < ?"},
+			args:    []any{int64(1704067200), int64(1704153600)},
+		},
+		{query: "title:federation", clauses: []string{"instr(lower(d.title), lower(?)) > 0"}, args: []any{"federation"}},
+	}
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`CREATE VIRTUAL TABLE fts USING fts5(content)`)
+	require.NoError(t, err)
+	for _, tt := range tests {
+		parsed, err := parseQuery(tt.query)
+		require.NoError(t, err, tt.query)
+		assert.Equal(t, tt.fts5, parsed.fts5, tt.query)
+		assert.Equal(t, tt.text, parsed.text, tt.query)
+		assert.Equal(t, tt.clauses, parsed.filter.clauses, tt.query)
+		assert.Equal(t, tt.args, parsed.filter.args, tt.query)
+		if parsed.fts5 != "" {
+			// The compiled queries are valid for FTS5, also after rewriting barewords
+			var count int
+			require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM fts WHERE content MATCH ?`, parsed.fts5).Scan(&count), parsed.fts5)
+			rewritten := rewriteBarewords(parsed.fts5, func(word string) []string { return []string{word + "s"} })
+			require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM fts WHERE content MATCH ?`, rewritten).Scan(&count), rewritten)
+		}
+	}
+
+	for _, query := range []string{
+		"",
+		"   ",
+		`"galactic federation`,
+		`""`,
+		"(cat OR dog",
+		"cat)",
+		"cat OR",
+		"AND cat",
+		"-cat",
+		"cat OR -dog",
+		"--cat dog",
+		"star NEAR/x wars",
+		"star NEAR (wars OR trek)",
+		"star NEAR/5 wars NEAR/3 trek",
+		"title:",
+		"author:tolkien",
+		"cat OR title:federation",
+		"(cat title:federation) OR dog",
+		"cat created_at:yesterday",
+		"cat created_at:..",
+		"*",
+	} {
+		_, err := parseQuery(query)
+		assert.True(t, errors.Is(err, errInvalidQuery), "query %q: %v", query, err)
+	}
+
+	parsed, err := parseQuery(`"big cat" cat feder* NOT cat`)
+	require.NoError(t, err)
+	assert.Equal(t, `"big cat" kitten feder* NOT kitten`, parsed.replaceWords(map[string]string{"cat": "kitten", "feder": "federal"}))
+}
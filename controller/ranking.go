@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/samber/lo"
+)
+
+// defaultRankingCandidates is the number of top results scored by ranking profiles by default
+const defaultRankingCandidates = 50
+
+// rankingNone disables the default ranking profile of requests
+const rankingNone = "none"
+
+// RankingProfile adjusts the scores of search results after retrieval and reranking.
+// The scores are normalized to 0..1 among the candidates, then multiplied by the time decay and the boost.
+type RankingProfile struct {
+	// DecayHalfLife is the age of documents whose scores are halved by the exponential time decay, 0 disables it
+	DecayHalfLife time.Duration
+	// BoostField is a numeric key of the data of documents, e.g. rating, the scores are multiplied by
+	// 1 + BoostWeight * value, documents without the number are not boosted
+	BoostField  string
+	BoostWeight float64
+	// Pins are the IDs of documents whose results are moved to the top in the order
+	Pins []string
+	// Candidates is the number of top results to be scored, 50 by default
+	Candidates int
+}
+
+func (p RankingProfile) empty() bool {
+	return p.DecayHalfLife == 0 && p.BoostField == "" && len(p.Pins) == 0
+}
+
+func (p RankingProfile) validate() error {
+	if p.DecayHalfLife < 0 {
+		return fmt.Errorf("the half-life of decay can't be negative")
+	}
+	if p.BoostField != "" {
+		if _, err := jsonPath(p.BoostField); err != nil {
+			return fmt.Errorf("invalid boost field: %w", err)
+		}
+	}
+	if p.Candidates < 0 || p.Candidates > maxRerankCandidates {
+		return fmt.Errorf("invalid candidates %d, it should be at most %d", p.Candidates, maxRerankCandidates)
+	}
+	return nil
+}
+
+// ParseHalfLife parses the half-life of time decay, a Go duration like 72h or a number of days like 30d
+func ParseHalfLife(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid half-life: %s", value)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	halfLife, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid half-life: %s", value)
+	}
+	return halfLife, nil
+}
+
+// parseRankingProfile parses `ranking=<profile>` for a named profile, the default one of the options is used without it
+// and `ranking=none` disables it. `decay=<half-life>`, `boost=<data key>&boost_weight=1` and `pin=<doc_id>` (repeatable)
+// override the profile. It returns nil if the results are not ranked.
+func (c *Controller) parseRankingProfile(echoCtx *echo.Context) (*RankingProfile, error) {
+	profile := RankingProfile{}
+	name := echoCtx.QueryParamOr("ranking", c.options.DefaultRanking)
+	if name != "" && name != rankingNone {
+		namedProfile, ok := c.options.RankingProfiles[name]
+		if !ok {
+			return nil, fmt.Errorf("ranking profile '%s' not found", name)
+		}
+		profile = namedProfile
+	}
+	if decay := echoCtx.QueryParam("decay"); decay != "" {
+		halfLife, err := ParseHalfLife(decay)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameter 'decay': %w", err)
+		}
+		profile.DecayHalfLife = halfLife
+	}
+	if boost := echoCtx.QueryParam("boost"); boost != "" {
+		profile.BoostField, profile.BoostWeight = boost, 1
+	}
+	if boostWeight := echoCtx.QueryParam("boost_weight"); boostWeight != "" {
+		weight, err := strconv.ParseFloat(boostWeight, 64)
+		if err != nil || math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return nil, fmt.Errorf("invalid parameter 'boost_weight': %s", boostWeight)
+		}
+		profile.BoostWeight = weight
+	}
+	if pins := lo.Compact(lo.FlatMap(echoCtx.QueryParams()["pin"], func(item string, index int) []string {
+		return lo.Map(strings.Split(item, ","), func(pin string, index int) string { return strings.TrimSpace(pin) })
+	})); len(pins) > 0 {
+		profile.Pins = lo.Uniq(pins)
+	}
+	if err := profile.validate(); err != nil {
+		return nil, err
+	}
+	if profile.empty() {
+		return nil, nil
+	}
+	if profile.Candidates == 0 {
+		profile.Candidates = defaultRankingCandidates
+	}
+	return &profile, nil
+}
+
+// rankedDocument is the information of documents used by ranking
+type rankedDocument struct {
+	createdAt int64
+	boost     sql.NullFloat64
+}
+
+// getRankedDocuments returns the creation time and the boost value of documents
+func (c *Controller) getRankedDocuments(ctx context.Context, profile *RankingProfile, docIds []string) (map[string]rankedDocument, error) {
+	boostColumn := "NULL"
+	args := make([]any, 0, len(docIds)+2)
+	if profile.BoostField != "" {
+		path, err := jsonPath(profile.BoostField)
+		if err != nil {
+			return nil, err
+		}
+		// Only numbers are boosted, json_extract returns texts for strings
+		boostColumn = "CASE WHEN json_type(data, ?) IN ('integer', 'real') THEN json_extract(data, ?) END"
+		args = append(args, path, path)
+	}
+	args = append(args, lo.ToAnySlice(docIds)...)
+	rows, err := c.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id, created_at, %s
+		FROM document
+		WHERE id IN (%s)
+	`, boostColumn, strings.TrimSuffix(strings.Repeat("?,", len(docIds)), ",")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			logger.WithError(err).Error("Failed to close rows")
+		}
+	}(rows)
+	documents := make(map[string]rankedDocument, len(docIds))
+	for rows.Next() {
+		var id string
+		var document rankedDocument
+		if err := rows.Scan(&id, &document.createdAt, &document.boost); err != nil {
+			return nil, err
+		}
+		documents[id] = document
+	}
+	return documents, rows.Err()
+}
+
+// rank scores the results by the profile and sorts them with the pinned ones first, the scores of results are replaced.
+// The results should be sorted by relevance, higher or lower scores first.
+func (c *Controller) rank(ctx context.Context, profile *RankingProfile, results []SearchResultItem, now time.Time) ([]SearchResultItem, error) {
+	if len(results) == 0 {
+		return results, nil
+	}
+	var documents map[string]rankedDocument
+	if profile.DecayHalfLife > 0 || profile.BoostField != "" {
+		var err error
+		documents, err = c.getRankedDocuments(ctx, profile, lo.Uniq(lo.Map(results, func(item SearchResultItem, index int) string { return item.DocumentID })))
+		if err != nil {
+			return nil, fmt.Errorf("failed to rank: %w", err)
+		}
+	}
+	// Min-max normalization works for both BM25 ranks (lower is better) and similarities (higher is better)
+	best, worst := results[0].Score, results[len(results)-1].Score
+	ranked := make([]SearchResultItem, len(results))
+	for i, item := range results {
+		score := 1.0
+		if best != worst {
+			score = (item.Score - worst) / (best - worst)
+		}
+		document := documents[item.DocumentID]
+		if profile.DecayHalfLife > 0 {
+			age := max(now.Sub(time.Unix(document.createdAt, 0)), 0)
+			score *= math.Exp2(-float64(age) / float64(profile.DecayHalfLife))
+		}
+		if profile.BoostField != "" && document.boost.Valid {
+			score *= max(1+profile.BoostWeight*document.boost.Float64, 0)
+		}
+		item.Score = score
+		ranked[i] = item
+	}
+	pinned := make(map[string]int, len(profile.Pins))
+	for i, docId := range profile.Pins {
+		pinned[docId] = i
+	}
+	pinOrder := func(item SearchResultItem) int {
+		if i, ok := pinned[item.DocumentID]; ok {
+			return i
+		}
+		return len(profile.Pins)
+	}
+	// Stable to keep the order of retrieval for ties
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if pi, pj := pinOrder(ranked[i]), pinOrder(ranked[j]); pi != pj {
+			return pi < pj
+		}
+		return ranked[i].Score > ranked[j].Score
+	})
+	return ranked, nil
+}
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/labstack/echo/v5"
+	"github.com/samber/lo"
+	"github.com/tsingjyujing/vestigo/models"
+)
+
+// defaultRerankCandidates is the number of candidates sent to the rerank model by default
+const defaultRerankCandidates = 50
+
+// maxRerankCandidates limits the candidates of a request, the cost of reranking grows with them
+const maxRerankCandidates = 200
+
+// getRerankModel returns the rerank model with the ID, generation models can also rerank by prompting
+func (c *Controller) getRerankModel(modelId string) (models.RerankModel, error) {
+	if model, ok := c.options.RerankModels[modelId]; ok {
+		return model, nil
+	}
+	if model, ok := c.generationModels[modelId]; ok {
+		return models.NewGenerationRerankModel(model), nil
+	}
+	return nil, fmt.Errorf("rerank model '%s' not found", modelId)
+}
+
+// parseRerankParams parses `rerank=<model_id>&rerank_k=50`, the model is nil if reranking is not requested.
+// The top max(n, rerank_k) candidates are reranked.
+func (c *Controller) parseRerankParams(echoCtx *echo.Context) (models.RerankModel, int, error) {
+	modelId := echoCtx.QueryParam("rerank")
+	if modelId == "" {
+		return nil, 0, nil
+	}
+	model, err := c.getRerankModel(modelId)
+	if err != nil {
+		return nil, 0, err
+	}
+	k, err := strconv.Atoi(echoCtx.QueryParamOr("rerank_k", strconv.Itoa(defaultRerankCandidates)))
+	if err != nil || k <= 0 || k > maxRerankCandidates {
+		return nil, 0, fmt.Errorf("invalid parameter 'rerank_k': %s, it should be between 1 and %d", echoCtx.QueryParam("rerank_k"), maxRerankCandidates)
+	}
+	return model, k, nil
+}
+
+// rerank scores the results by the model and sorts them by the scores, the scores of results are replaced
+func rerank(ctx context.Context, model models.RerankModel, query string, results []SearchResultItem) ([]SearchResultItem, error) {
+	if len(results) == 0 {
+		return results, nil
+	}
+	scores, err := model.Rerank(ctx, query, lo.Map(results, func(item SearchResultItem, index int) string { return item.Content }))
+	if err != nil {
+		return nil, fmt.Errorf("failed to rerank: %w", err)
+	}
+	reranked := make([]SearchResultItem, len(results))
+	for i, item := range results {
+		item.Score = scores[i]
+		reranked[i] = item
+	}
+	// Stable to keep the order of retrieval for ties
+	sort.SliceStable(reranked, func(i, j int) bool {
+		return reranked[i].Score > reranked[j].Score
+	})
+	return reranked, nil
+}
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/tsingjyujing/vestigo/models"
+	"github.com/tsingjyujing/vestigo/text"
+)
+
+// maxRewriteKeywords limits the keyword expansions of a query
+const maxRewriteKeywords = 8
+
+const queryRewriteSystemPromptTemplate = `You rewrite search queries for a search engine over documents in the languages %s (ISO 639-1).
+Reply with only a JSON object with two fields:
+"keywords": an array of up to %d short keyword queries with the terms, synonyms and translations of the key concepts of the query in each of the languages;
+"hypothetical_answer": a short passage of 2 to 4 sentences in the language of the query which would answer or match the query, as if it was taken from a document.`
+
+// QueryRewrite is the rewritten variants of a query by a generation model
+type QueryRewrite struct {
+	Model              string   `json:"model" jsonschema:"the ID of the generation model rewriting the query"`
+	Keywords           []string `json:"keywords" jsonschema:"the keyword expansions of the query in the languages of the corpus, searched by BM25"`
+	HypotheticalAnswer string   `json:"hypothetical_answer,omitempty" jsonschema:"the hypothetical answer of the query, searched by embedding models (HyDE)"`
+}
+
+// rewriteQuery asks the generation model for keyword expansions and a hypothetical answer of the query
+func (c *Controller) rewriteQuery(ctx context.Context, modelId string, model models.GenerationModel, query string) (*QueryRewrite, error) {
+	languages := c.options.Analyzer.DetectLanguages
+	if len(languages) == 0 {
+		languages = text.DefaultDetectLanguages
+	}
+	reply, err := model.Chat(ctx, fmt.Sprintf(queryRewriteSystemPromptTemplate, strings.Join(languages, ", "), maxRewriteKeywords), query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewrite the query by %s: %w", modelId, err)
+	}
+	// The object may be wrapped by explanations or code blocks
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no rewritten query in the reply of %s: %s", modelId, reply)
+	}
+	rewrite := &QueryRewrite{}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), rewrite); err != nil {
+		return nil, fmt.Errorf("failed to parse the rewritten query of %s: %w", modelId, err)
+	}
+	rewrite.Model = modelId
+	rewrite.Keywords = lo.Slice(lo.Uniq(lo.FilterMap(rewrite.Keywords, func(item string, index int) (string, bool) {
+		item = strings.TrimSpace(item)
+		return item, item != ""
+	})), 0, maxRewriteKeywords)
+	rewrite.HypotheticalAnswer = strings.TrimSpace(rewrite.HypotheticalAnswer)
+	return rewrite, nil
+}
+
+// retrieveRewritten retrieves text chunks by the query and its rewritten variant, and merges them by reciprocal
+// rank fusion: BM25 searches the keywords and embedding models search the hypothetical answer.
+// Without the rewrite it's the same as retrieve.
+func (c *Controller) retrieveRewritten(ctx context.Context, embeddings queryEmbeddings, method string, ftsQuery string, text string, nDoc int, filter *searchFilter, rewrite *QueryRewrite) ([]SearchResultItem, error) {
+	isBM25 := strings.ToLower(method) == RetrievalBM25
+	rankings := make([][]SearchResultItem, 0, 2)
+	if ftsQuery != "" || !isBM25 {
+		results, err := c.retrieve(ctx, embeddings, method, ftsQuery, text, nDoc, filter)
+		if err != nil {
+			return nil, err
+		}
+		if rewrite == nil {
+			return results, nil
+		}
+		rankings = append(rankings, results)
+	}
+	if rewrite != nil {
+		keywordsQuery := c.questionQuery(rewrite.Keywords...)
+		// BM25 needs the keywords, embedding models need the hypothetical answer and hybrid searches either
+		var searchable bool
+		switch strings.ToLower(method) {
+		case RetrievalBM25:
+			searchable = keywordsQuery != ""
+		case RetrievalHybrid:
+			searchable = keywordsQuery != "" || rewrite.HypotheticalAnswer != ""
+		default:
+			searchable = rewrite.HypotheticalAnswer != ""
+		}
+		if searchable {
+			hypotheticalAnswer := lo.Ternary(rewrite.HypotheticalAnswer != "", rewrite.HypotheticalAnswer, text)
+			results, err := c.retrieve(ctx, embeddings, method, keywordsQuery, hypotheticalAnswer, nDoc, filter)
+			if err != nil {
+				return nil, err
+			}
+			rankings = append(rankings, results)
+		}
+	}
+	switch len(rankings) {
+	case 0:
+		return make([]SearchResultItem, 0), nil
+	case 1:
+		return rankings[0], nil
+	}
+	return fuseRankings(rankings, nDoc), nil
+}
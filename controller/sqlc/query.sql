@@ -4,8 +4,8 @@ FROM document
 WHERE id = ?;
 
 -- name: NewDocument :exec
-INSERT INTO document (id, title, description, data)
-VALUES (?, ?, ?, ?);
+INSERT INTO document (id, title, description, data, duplicate_of)
+VALUES (?, ?, ?, ?, ?);
 
 -- name: GetDocument :one
 SELECT *
@@ -13,19 +13,52 @@ FROM document
 WHERE id = ? LIMIT 1;
 
 -- name: NewTextChunk :one
-INSERT INTO text_chunk (id, document_id, content, seg_content)
-VALUES (?, ?, ?, ?) RETURNING *;
+INSERT INTO text_chunk (id, document_id, content, seg_content, position, metadata, content_hash, duplicate_of, simhash,
+                        language, language_detected, generated_by, prompt_hash, generated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING *;
 
 -- name: ListTextChunksByDocumentID :many
 SELECT *
 FROM text_chunk
+WHERE document_id = ?
+ORDER BY position, created_at;
+
+-- name: GetNextTextChunkPosition :one
+SELECT CAST(COALESCE(MAX(position) + 1, 0) AS INTEGER) AS next_position
+FROM text_chunk
 WHERE document_id = ?;
 
+-- name: ListTextChunksBeforePosition :many
+SELECT *
+FROM text_chunk
+WHERE document_id = ?
+  AND position < ?
+ORDER BY position DESC LIMIT ?;
+
+-- name: ListTextChunksAfterPosition :many
+SELECT *
+FROM text_chunk
+WHERE document_id = ?
+  AND position > ?
+ORDER BY position LIMIT ?;
+
 -- name: GetTextChunk :one
 SELECT *
 FROM text_chunk
 WHERE id = ? LIMIT 1;
 
+-- name: UpdateTextChunk :one
+UPDATE text_chunk
+SET content      = ?,
+    seg_content  = ?,
+    metadata     = ?,
+    content_hash = ?,
+    simhash      = ?,
+    language     = ?,
+    language_detected = ?,
+    duplicate_of = ''
+WHERE id = ? RETURNING *;
+
 -- name: DeleteTextChunk :exec
 DELETE
 FROM text_chunk
@@ -64,6 +97,33 @@ WHERE id = ?;
 INSERT INTO text_chunk_fts (id, seg_content)
 VALUES (?, ?);
 
+-- name: UpdateTextChunkFTS :exec
+UPDATE text_chunk_fts
+SET seg_content = ?
+WHERE id = ?;
+
+-- name: DeleteAllTextChunkFTS :exec
+DELETE
+FROM text_chunk_fts;
+
+-- name: InsertAllTextChunkFTS :exec
+INSERT INTO text_chunk_fts (id, seg_content)
+SELECT id, seg_content
+FROM text_chunk;
+
+-- name: DeleteTextChunkFTSByIDs :exec
+DELETE
+FROM text_chunk_fts
+WHERE id IN (SELECT value
+             FROM json_each(sqlc.arg(ids)));
+
+-- name: InsertTextChunkFTSByIDs :exec
+INSERT INTO text_chunk_fts (id, seg_content)
+SELECT id, seg_content
+FROM text_chunk
+WHERE id IN (SELECT value
+             FROM json_each(sqlc.arg(ids)));
+
 -- name: NewTextEmbedding :exec
 INSERT INTO text_embedding (model_id, text_chunk_id, vector)
 VALUES (?, ?, ?);
@@ -84,3 +144,247 @@ SELECT text_chunk_id, vector
 FROM text_embedding
 WHERE model_id = ?;
 
+
+-- name: GetOriginalTextChunkByContentHash :one
+SELECT *
+FROM text_chunk
+WHERE content_hash = ?
+  AND duplicate_of = ''
+ORDER BY created_at, id LIMIT 1;
+
+-- name: ListTextEmbeddingsByTextChunkID :many
+SELECT model_id, vector
+FROM text_embedding
+WHERE text_chunk_id = ?;
+
+-- name: ClearDuplicateOfByDocumentID :exec
+UPDATE text_chunk
+SET duplicate_of = ''
+WHERE duplicate_of IN (SELECT id
+                       FROM text_chunk tc
+                       WHERE tc.document_id = ?);
+
+-- name: ClearDuplicateOfByTextChunkID :exec
+UPDATE text_chunk
+SET duplicate_of = ''
+WHERE duplicate_of = ?;
+
+-- name: ClearStaleDuplicateOf :exec
+UPDATE text_chunk
+SET duplicate_of = ''
+WHERE duplicate_of != ''
+  AND content_hash IS NOT (SELECT original.content_hash
+                           FROM text_chunk original
+                           WHERE original.id = text_chunk.duplicate_of);
+
+-- name: GetDuplicateDocumentID :one
+SELECT tc.document_id
+FROM text_chunk tc
+         JOIN document d ON d.id = tc.document_id
+WHERE tc.generated_by = ''
+  AND tc.document_id != sqlc.arg(id)
+  AND tc.document_id IN (SELECT document_id
+                         FROM text_chunk
+                         WHERE content_hash = sqlc.arg(content_hash))
+GROUP BY tc.document_id
+HAVING COUNT(DISTINCT tc.content_hash) = sqlc.arg(hash_count)
+   AND SUM(tc.content_hash IN (SELECT value FROM json_each(sqlc.arg(content_hashes)))) = COUNT(*)
+ORDER BY MAX(d.duplicate_of) != '', MIN(d.created_at), tc.document_id LIMIT 1;
+
+-- name: ClearStaleDocumentDuplicateOf :exec
+UPDATE document
+SET duplicate_of = ''
+WHERE duplicate_of != ''
+  AND (id = ?1 OR duplicate_of = ?1)
+  AND (EXISTS (SELECT content_hash
+               FROM text_chunk
+               WHERE document_id = document.id
+                 AND generated_by = ''
+               EXCEPT
+               SELECT content_hash
+               FROM text_chunk
+               WHERE document_id = document.duplicate_of
+                 AND generated_by = '')
+    OR EXISTS (SELECT content_hash
+               FROM text_chunk
+               WHERE document_id = document.duplicate_of
+                 AND generated_by = ''
+               EXCEPT
+               SELECT content_hash
+               FROM text_chunk
+               WHERE document_id = document.id
+                 AND generated_by = ''));
+
+-- name: ListTextChunksWithoutContentHash :many
+SELECT id, content
+FROM text_chunk
+WHERE content_hash = '';
+
+-- name: UpdateTextChunkContentHash :exec
+UPDATE text_chunk
+SET content_hash = ?
+WHERE id = ?;
+
+-- name: ListDuplicateContentHashes :many
+SELECT content_hash,
+       COUNT(*)                    AS chunk_count,
+       COUNT(DISTINCT document_id) AS document_count
+FROM text_chunk
+WHERE content_hash != ''
+GROUP BY content_hash
+HAVING COUNT(*) > 1
+   AND COUNT(DISTINCT document_id) >= ?
+ORDER BY chunk_count DESC, content_hash LIMIT ?;
+
+-- name: ListTextChunksByContentHash :many
+SELECT *
+FROM text_chunk
+WHERE content_hash = ?
+ORDER BY created_at, id;
+
+-- name: UpdateDocumentSimHash :exec
+UPDATE document
+SET simhash = ?
+WHERE id = ?;
+
+-- name: UpdateDocumentData :exec
+UPDATE document
+SET data = ?
+WHERE id = ?;
+
+-- name: UpdateTextChunkSimHash :exec
+UPDATE text_chunk
+SET simhash = ?
+WHERE id = ?;
+
+-- name: ListTextChunksWithoutSimHash :many
+SELECT id, seg_content
+FROM text_chunk
+WHERE simhash = 0
+  AND seg_content != '';
+
+-- name: ListDocumentsWithoutSimHash :many
+SELECT id
+FROM document d
+WHERE d.simhash = 0
+  AND EXISTS (SELECT 1
+              FROM text_chunk tc
+              WHERE tc.document_id = d.id
+                AND tc.simhash != 0);
+
+-- name: ListDocumentSimHashes :many
+SELECT id, title, simhash
+FROM document
+WHERE simhash != 0;
+
+-- name: ListTextChunkSimHashes :many
+SELECT id, document_id, simhash
+FROM text_chunk
+WHERE simhash != 0;
+
+-- name: GetMeta :one
+SELECT value
+FROM meta
+WHERE key = ?;
+
+-- name: SetMeta :exec
+INSERT INTO meta (key, value)
+VALUES (?, ?)
+ON CONFLICT (key) DO UPDATE SET value = excluded.value;
+
+-- name: ListAllTextChunkContents :many
+SELECT id, document_id, content, language, language_detected
+FROM text_chunk;
+
+-- name: UpdateTextChunkAnalysis :exec
+UPDATE text_chunk
+SET seg_content  = ?,
+    content_hash = ?,
+    simhash      = ?,
+    language     = ?
+WHERE id = ?;
+
+-- name: ListDocumentIDs :many
+SELECT id
+FROM document;
+
+-- name: RecordSearchQuery :exec
+INSERT INTO search_query (query, count)
+VALUES (?, 1)
+ON CONFLICT (query) DO UPDATE SET count            = count + 1,
+                                  last_searched_at = strftime('%s', 'now');
+
+-- name: ListSearchQueries :many
+SELECT query, count
+FROM search_query;
+
+-- name: ListDocumentTitles :many
+SELECT title
+FROM document;
+
+-- name: ListDocumentIDsWithGeneratedTextChunks :many
+SELECT DISTINCT document_id
+FROM text_chunk
+WHERE generated_by != ''
+ORDER BY document_id;
+
+-- name: NewJob :one
+INSERT INTO job (id, kind, status, params, total)
+VALUES (?, ?, ?, ?, ?) RETURNING *;
+
+-- name: GetJob :one
+SELECT *
+FROM job
+WHERE id = ? LIMIT 1;
+
+-- name: ListJobs :many
+SELECT *
+FROM job
+ORDER BY created_at DESC, id;
+
+-- name: ListJobIDsByStatus :many
+SELECT id
+FROM job
+WHERE status = ?
+ORDER BY created_at, id;
+
+-- name: UpdateJobStatus :exec
+UPDATE job
+SET status     = ?,
+    error      = ?,
+    updated_at = strftime('%s', 'now')
+WHERE id = ?;
+
+-- name: AddJobProgress :exec
+UPDATE job
+SET processed  = processed + ?,
+    failed     = failed + ?,
+    dropped    = dropped + ?,
+    generated  = generated + ?,
+    updated_at = strftime('%s', 'now')
+WHERE id = ?;
+
+-- name: NewJobDocument :exec
+INSERT INTO job_document (job_id, document_id)
+VALUES (?, ?);
+
+-- name: ListPendingJobDocumentIDs :many
+SELECT document_id
+FROM job_document
+WHERE job_id = ?
+  AND status = 'pending'
+ORDER BY document_id;
+
+-- name: FinishJobDocument :exec
+UPDATE job_document
+SET status = ?,
+    error  = ?
+WHERE job_id = ?
+  AND document_id = ?;
+
+-- name: ListFailedJobDocuments :many
+SELECT document_id, error
+FROM job_document
+WHERE job_id = ?
+  AND status = 'failed'
+ORDER BY document_id;
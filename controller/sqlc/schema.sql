@@ -1,22 +1,43 @@
 CREATE TABLE IF NOT EXISTS document
 (
-    id          TEXT PRIMARY KEY,
-    title       TEXT    NOT NULL,
-    description TEXT    NOT NULL DEFAULT '',
-    data        TEXT    NOT NULL DEFAULT '{}',
-    created_at  INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))
+    id           TEXT PRIMARY KEY,
+    title        TEXT    NOT NULL,
+    description  TEXT    NOT NULL DEFAULT '',
+    data         TEXT    NOT NULL DEFAULT '{}',
+    created_at   INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    simhash      INTEGER NOT NULL DEFAULT 0,
+    duplicate_of TEXT    NOT NULL DEFAULT '' -- ID of the document with the same source texts, linked by the dedupe policy
 ) WITHOUT ROWID;
 
 CREATE TABLE IF NOT EXISTS text_chunk
 (
-    id          TEXT PRIMARY KEY,
-    document_id TEXT    NOT NULL,
-    content     TEXT    NOT NULL,
-    seg_content TEXT    NOT NULL,
-    created_at  INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    id                TEXT PRIMARY KEY,
+    document_id       TEXT    NOT NULL,
+    content           TEXT    NOT NULL,
+    seg_content       TEXT    NOT NULL,
+    created_at        INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    position          INTEGER NOT NULL DEFAULT 0,
+    metadata          TEXT    NOT NULL DEFAULT '{}',
+    content_hash      TEXT    NOT NULL DEFAULT '',
+    duplicate_of      TEXT    NOT NULL DEFAULT '',
+    simhash           INTEGER NOT NULL DEFAULT 0,
+    language          TEXT    NOT NULL DEFAULT '',
+    language_detected BOOLEAN NOT NULL DEFAULT FALSE, -- the language is detected rather than given, detected again on rebuilds
+    generated_by      TEXT    NOT NULL DEFAULT '', -- ID of the generation model, empty for source texts
+    prompt_hash       TEXT    NOT NULL DEFAULT '',
+    generated_at      INTEGER NOT NULL DEFAULT 0,
     FOREIGN KEY (document_id) REFERENCES document (id) ON DELETE CASCADE
 ) WITHOUT ROWID;
 
+CREATE INDEX IF NOT EXISTS idx_text_chunk_document_id_position
+    ON text_chunk (document_id, position);
+
+CREATE INDEX IF NOT EXISTS idx_text_chunk_content_hash
+    ON text_chunk (content_hash);
+
+CREATE INDEX IF NOT EXISTS idx_text_chunk_generated_by
+    ON text_chunk (generated_by);
+
 CREATE VIRTUAL TABLE IF NOT EXISTS text_chunk_fts
     USING fts5
 (
@@ -25,6 +46,13 @@ CREATE VIRTUAL TABLE IF NOT EXISTS text_chunk_fts
     tokenize = 'unicode61'
 );
 
+CREATE VIRTUAL TABLE IF NOT EXISTS text_chunk_vocab
+    USING fts5vocab
+( -- Term dictionary of the full text index, to correct misspelled query terms
+    text_chunk_fts,
+    row
+);
+
 CREATE TABLE IF NOT EXISTS text_embedding
 ( -- Use default row ID for simplicity
     model_id      TEXT    NOT NULL,
@@ -38,4 +66,43 @@ CREATE INDEX IF NOT EXISTS idx_text_embedding_model_id
     ON text_embedding (model_id);
 
 CREATE INDEX IF NOT EXISTS idx_text_embedding_text_chunk_id
-    ON text_embedding (text_chunk_id);
\ No newline at end of file
+    ON text_embedding (text_chunk_id);
+
+CREATE TABLE IF NOT EXISTS meta
+( -- Settings of the database itself, e.g. the fingerprint of the analyzer building the indexes
+    key   TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS search_query
+( -- Past queries with results, they are suggested by autocomplete
+    query            TEXT PRIMARY KEY,
+    count            INTEGER NOT NULL DEFAULT 0,
+    last_searched_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS job
+( -- Background jobs, the progress is kept to resume them after restarts
+    id         TEXT PRIMARY KEY,
+    kind       TEXT    NOT NULL,
+    status     TEXT    NOT NULL,
+    params     TEXT    NOT NULL DEFAULT '{}',
+    total      INTEGER NOT NULL DEFAULT 0,
+    processed  INTEGER NOT NULL DEFAULT 0,
+    failed     INTEGER NOT NULL DEFAULT 0,
+    dropped    INTEGER NOT NULL DEFAULT 0,
+    generated  INTEGER NOT NULL DEFAULT 0,
+    error      TEXT    NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    updated_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS job_document
+( -- Documents processed by jobs, the pending ones are processed when the job resumes
+    job_id      TEXT NOT NULL,
+    document_id TEXT NOT NULL,
+    status      TEXT NOT NULL DEFAULT 'pending',
+    error       TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (job_id, document_id),
+    FOREIGN KEY (job_id) REFERENCES job (id) ON DELETE CASCADE
+) WITHOUT ROWID;
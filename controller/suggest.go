@@ -0,0 +1,276 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/labstack/echo/v5"
+	"github.com/samber/lo"
+	"github.com/tsingjyujing/vestigo/text"
+	"github.com/tsingjyujing/vestigo/utils"
+)
+
+const (
+	SuggestionSourceTerm  = "term"
+	SuggestionSourceTitle = "title"
+	SuggestionSourceQuery = "query"
+)
+
+// maxTitleSuffixes limits the suffixes of a title indexed for completion, i.e. the words a title can be completed from
+const maxTitleSuffixes = 16
+
+// suggester keeps the completions of prefixes in memory: terms in the full text index, document titles and past queries.
+// Keys are folded by NFKC, t2s and lowercase, so prefixes in any case or Chinese script complete the same keys.
+type suggester struct {
+	lock    sync.RWMutex
+	folder  text.Normalizer
+	terms   *text.Trie
+	titles  *text.Trie
+	queries *text.Trie
+}
+
+func newSuggester() (*suggester, error) {
+	folder, err := text.NewNormalizer([]string{text.NormalizerNFKC, text.NormalizerT2s, text.NormalizerLowercase})
+	if err != nil {
+		return nil, err
+	}
+	return &suggester{folder: folder, terms: text.NewTrie(), titles: text.NewTrie(), queries: text.NewTrie()}, nil
+}
+
+func (s *suggester) fold(value string) string {
+	folded, err := s.folder.Normalize(value)
+	if err != nil {
+		return strings.ToLower(value)
+	}
+	return folded
+}
+
+// loadSuggestions fills the tries of the suggester from the database
+func (c *Controller) loadSuggestions(ctx context.Context) error {
+	if err := c.loadSuggestionTerms(ctx); err != nil {
+		return err
+	}
+	titles, err := c.queries.ListDocumentTitles(ctx)
+	if err != nil {
+		return err
+	}
+	queries, err := c.queries.ListSearchQueries(ctx)
+	if err != nil {
+		return err
+	}
+	s := c.suggester
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.titles = text.NewTrie()
+	for _, title := range titles {
+		s.addTitle(c.analyzer.Load(), title, 1)
+	}
+	s.queries = text.NewTrie()
+	for _, row := range queries {
+		s.queries.Add(s.fold(row.Query), row.Query, row.Count)
+	}
+	logger.Infof("Loaded %d terms, %d titles and %d queries for suggestions", s.terms.Len(), len(titles), s.queries.Len())
+	return nil
+}
+
+// loadSuggestionTerms rebuilds the terms trie from the full text index, weighted by the number of text chunks
+func (c *Controller) loadSuggestionTerms(ctx context.Context) error {
+	rows, err := c.db.QueryContext(ctx, `SELECT term, doc FROM text_chunk_vocab`)
+	if err != nil {
+		return err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			logger.WithError(err).Error("Failed to close rows")
+		}
+	}(rows)
+	terms := text.NewTrie()
+	for rows.Next() {
+		var term string
+		var count int64
+		if err := rows.Scan(&term, &count); err != nil {
+			return err
+		}
+		terms.Add(c.suggester.fold(term), "", count)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	c.suggester.lock.Lock()
+	defer c.suggester.lock.Unlock()
+	c.suggester.terms = terms
+	return nil
+}
+
+// addSegContent adds delta to the terms of an indexed text chunk, once per text chunk like the counts in text_chunk_vocab
+func (s *suggester) addSegContent(segContent string, delta int64) {
+	tokenizer := text.UnicodeTokenizer{}
+	terms := lo.Uniq(lo.Map(tokenizer.Tokenize(segContent), func(item string, index int) string { return s.fold(item) }))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, term := range terms {
+		s.terms.Add(term, "", delta)
+	}
+}
+
+type suggestionDelta struct {
+	value string
+	delta int64
+}
+
+// suggestionDeltas collects the changes of terms and titles in a transaction, they're applied to the suggester
+// after commit so a rollback doesn't leave the suggestions drifted
+type suggestionDeltas struct {
+	segContents []suggestionDelta
+	titles      []suggestionDelta
+}
+
+// addSegContent adds delta to the terms of an indexed text chunk
+func (d *suggestionDeltas) addSegContent(segContent string, delta int64) {
+	d.segContents = append(d.segContents, suggestionDelta{value: segContent, delta: delta})
+}
+
+// updateTitle adds delta to a document title
+func (d *suggestionDeltas) updateTitle(title string, delta int64) {
+	d.titles = append(d.titles, suggestionDelta{value: title, delta: delta})
+}
+
+// apply applies the deltas of a committed transaction
+func (s *suggester) apply(analyzer *text.Analyzer, deltas *suggestionDeltas) {
+	for _, item := range deltas.segContents {
+		s.addSegContent(item.value, item.delta)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, item := range deltas.titles {
+		s.addTitle(analyzer, item.value, item.delta)
+	}
+}
+
+// addTitle indexes the title by its suffixes from every token, and every character in CJK scripts as compound words
+// are often single tokens, so "war" completes "The Star Wars" and "联邦" completes "美国联邦政府".
+// The caller must hold the lock.
+func (s *suggester) addTitle(analyzer *text.Analyzer, title string, delta int64) {
+	folded := s.fold(title)
+	if strings.TrimSpace(folded) == "" {
+		return
+	}
+	starts := []int{0}
+	offset := 0
+	for _, token := range analyzer.TokenizerOf(analyzer.DetectLanguage(folded)).Tokenize(folded) {
+		index := strings.Index(folded[offset:], token)
+		if index < 0 {
+			continue
+		}
+		for i, r := range token {
+			if i == 0 || unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+				starts = append(starts, offset+index+i)
+			}
+		}
+		offset += index + len(token)
+	}
+	for _, start := range lo.Slice(lo.Uniq(starts), 0, maxTitleSuffixes) {
+		// The title is appended to keep the suffixes of different titles apart
+		s.titles.Add(folded[start:]+"\x00"+title, title, delta)
+	}
+}
+
+// addQuery counts a query which has results
+func (s *suggester) addQuery(query string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.queries.Add(s.fold(query), query, 1)
+}
+
+type Suggestion struct {
+	Text    string   `json:"text" jsonschema:"the completed text"`
+	Sources []string `json:"sources" jsonschema:"where the completion comes from: term, title or query"`
+	Count   int64    `json:"count" jsonschema:"the number of text chunks with the term, documents with the title and searches of the query"`
+}
+
+type SuggestResponse struct {
+	Suggestions []Suggestion `json:"suggestions"`
+}
+
+// suggest completes the prefix by all sources, the most popular ones first
+func (c *Controller) suggest(prefix string, n int) []Suggestion {
+	s := c.suggester
+	analyzer := c.analyzer.Load()
+	foldedPrefix := s.fold(prefix)
+	// Terms complete the last word of the prefix, the words before it are kept as they are typed.
+	// Words are split by the tokenizer, as there are no spaces between words in CJK texts.
+	head, lastWord := "", ""
+	if !strings.HasSuffix(prefix, " ") {
+		lastWord = prefix
+		if tokens := analyzer.TokenizerOf(analyzer.DetectLanguage(prefix)).Tokenize(prefix); len(tokens) > 0 {
+			last := tokens[len(tokens)-1]
+			if index := strings.LastIndex(prefix, last); index >= 0 && index+len(last) == len(prefix) {
+				head, lastWord = prefix[:index], last
+			}
+		}
+	}
+	s.lock.RLock()
+	// Titles and terms may be completed several times, fetch more to fill n after merging
+	termCompletions := make([]text.Completion, 0)
+	if lastWord != "" {
+		termCompletions = s.terms.Complete(s.fold(lastWord), n)
+	}
+	titleCompletions := s.titles.Complete(foldedPrefix, n*4)
+	queryCompletions := s.queries.Complete(foldedPrefix, n)
+	s.lock.RUnlock()
+
+	suggestions := make(map[string]*Suggestion)
+	add := func(display string, source string, count int64) {
+		key := s.fold(display)
+		suggestion, ok := suggestions[key]
+		if !ok {
+			suggestion = &Suggestion{Text: display, Sources: make([]string, 0, 1)}
+			suggestions[key] = suggestion
+		}
+		if !lo.Contains(suggestion.Sources, source) {
+			suggestion.Sources = append(suggestion.Sources, source)
+			suggestion.Count += count
+		}
+	}
+	for _, completion := range queryCompletions {
+		add(completion.Display, SuggestionSourceQuery, completion.Weight)
+	}
+	for _, completion := range titleCompletions {
+		add(completion.Display, SuggestionSourceTitle, completion.Weight)
+	}
+	for _, completion := range termCompletions {
+		add(head+completion.Display, SuggestionSourceTerm, completion.Weight)
+	}
+	result := make([]Suggestion, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		result = append(result, *suggestion)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Text < result[j].Text
+	})
+	return lo.Slice(result, 0, n)
+}
+
+// Suggest returns the popular completions of the prefix from terms, document titles and past queries
+func (c *Controller) Suggest(echoCtx *echo.Context) error {
+	prefix := strings.TrimLeft(echoCtx.QueryParam("prefix"), " ")
+	if prefix == "" {
+		return utils.EchoHandleGenericError(echoCtx, fmt.Errorf("query parameter 'prefix' is required"), http.StatusBadRequest)
+	}
+	n, err := strconv.Atoi(echoCtx.QueryParamOr("n", "10"))
+	if err != nil || n <= 0 {
+		return utils.EchoHandleGenericError(echoCtx, fmt.Errorf("invalid parameter 'n': %s", echoCtx.QueryParam("n")), http.StatusBadRequest)
+	}
+	return utils.EchoJsonResponse(echoCtx, SuggestResponse{Suggestions: c.suggest(prefix, n)}, http.StatusOK)
+}
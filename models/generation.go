@@ -7,17 +7,142 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"text/template"
 
+	"github.com/google/jsonschema-go/jsonschema"
 	"github.com/ollama/ollama/api"
 	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/option"
+	"github.com/openai/openai-go/v3/shared"
 )
 
 const DefaultSystemPrompt = "You're a helpful assistant to summarize the extracted text from web page for search engine in webpage's language."
 
+// DefaultOutputSystemPrompt is the default system prompt of models with an output schema, the schema is appended to it
+const DefaultOutputSystemPrompt = "You're a helpful assistant to extract the fields of the JSON schema from the extracted text of web page for search engine in webpage's language. Reply with only a JSON object of the fields."
+
+// GenerationDocument is the document given to the system prompt template of Generate,
+// e.g. `{{.Title}}` or `{{.Data.category}}`
+type GenerationDocument struct {
+	Title       string
+	Description string
+	Data        map[string]any
+}
+
 type GenerationModel interface {
-	// Generate generates a summary for the given text
-	Generate(ctx context.Context, texts []string) (string, error)
+	// Generate generates a text like a summary from the texts of the document by the configured system prompt
+	Generate(ctx context.Context, document GenerationDocument, texts []string) (string, error)
+	// Chat generates the reply of the prompt with the given system prompt instead of the configured one
+	Chat(ctx context.Context, systemPrompt string, prompt string) (string, error)
+	// ChatStream generates the reply like Chat, and calls onDelta with every piece of the reply as soon as it arrives,
+	// an error of onDelta stops the generation. The full reply is returned.
+	ChatStream(ctx context.Context, systemPrompt string, prompt string, onDelta func(delta string) error) (string, error)
+	// SystemPrompt returns the configured system prompt template used by Generate
+	SystemPrompt() string
+	// OutputSchema returns the JSON schema of the object replied by Generate, nil if Generate replies a free text
+	OutputSchema() *jsonschema.Resolved
+}
+
+// GenerationOptions are the options of all types of generation models
+type GenerationOptions struct {
+	// SystemPrompt is a text/template of the system prompt of Generate with the GenerationDocument
+	SystemPrompt string `json:"system_prompt,omitempty"`
+	// Temperature is the sampling temperature, the default one of the model if not set
+	Temperature *float64 `json:"temperature,omitempty"`
+	// MaxTokens limits the tokens of replies, 0 is unlimited
+	MaxTokens int64 `json:"max_tokens,omitempty"`
+	// MaxInputLength truncates the texts given to Generate to the number of characters, 0 is unlimited
+	MaxInputLength int `json:"max_input_length,omitempty"`
+	// OutputSchema is a JSON schema of an object, e.g. tags, category and entities, Generate replies the object
+	// constrained by the structured outputs of the model server instead of a free text
+	OutputSchema map[string]any `json:"output_schema,omitempty"`
+}
+
+// generationPrompts renders the prompts of Generate by the options
+type generationPrompts struct {
+	options        GenerationOptions
+	systemTemplate *template.Template
+	outputSchema   *jsonschema.Resolved
+	// format is the output schema in JSON given to the model server
+	format json.RawMessage
+}
+
+func newGenerationPrompts(options GenerationOptions) (*generationPrompts, error) {
+	var outputSchema *jsonschema.Resolved
+	var format json.RawMessage
+	if options.OutputSchema != nil {
+		var err error
+		if outputSchema, err = NewOutputSchema(options.OutputSchema); err != nil {
+			return nil, err
+		}
+		if format, err = json.Marshal(options.OutputSchema); err != nil {
+			return nil, err
+		}
+		if options.SystemPrompt == "" {
+			options.SystemPrompt = DefaultOutputSystemPrompt + "\n\nJSON schema:\n" + string(format)
+		}
+	}
+	if options.SystemPrompt == "" {
+		options.SystemPrompt = DefaultSystemPrompt
+	}
+	if options.MaxInputLength < 0 || options.MaxTokens < 0 {
+		return nil, fmt.Errorf("max_input_length and max_tokens can't be negative")
+	}
+	systemTemplate, err := template.New("system_prompt").Parse(options.SystemPrompt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid system prompt template: %w", err)
+	}
+	return &generationPrompts{options: options, systemTemplate: systemTemplate, outputSchema: outputSchema, format: format}, nil
+}
+
+// NewOutputSchema resolves the JSON schema of the objects replied by generation models, it must be an object
+func NewOutputSchema(schema map[string]any) (*jsonschema.Resolved, error) {
+	jsonData, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+	parsed := &jsonschema.Schema{}
+	if err := json.Unmarshal(jsonData, parsed); err != nil {
+		return nil, fmt.Errorf("invalid output schema: %w", err)
+	}
+	if parsed.Type != "object" {
+		return nil, fmt.Errorf("invalid output schema: the type should be object rather than '%s'", parsed.Type)
+	}
+	resolved, err := parsed.Resolve(nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output schema: %w", err)
+	}
+	return resolved, nil
+}
+
+// ParseOutput parses the object in the reply of a model and validates it by the output schema
+func ParseOutput(schema *jsonschema.Resolved, reply string) (map[string]any, error) {
+	// The object may be wrapped by explanations or code blocks if the server doesn't support structured outputs
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no JSON object in the reply: %s", reply)
+	}
+	output := make(map[string]any)
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &output); err != nil {
+		return nil, fmt.Errorf("failed to parse the JSON object in the reply: %w", err)
+	}
+	if err := schema.Validate(output); err != nil {
+		return nil, fmt.Errorf("the reply doesn't match the output schema: %w", err)
+	}
+	return output, nil
+}
+
+// render returns the system prompt of the document and the user prompt of the texts truncated to the max length
+func (p generationPrompts) render(document GenerationDocument, texts []string) (string, string, error) {
+	var systemPrompt strings.Builder
+	if err := p.systemTemplate.Execute(&systemPrompt, document); err != nil {
+		return "", "", fmt.Errorf("failed to render the system prompt: %w", err)
+	}
+	prompt := strings.Join(texts, "\n\n")
+	if runes := []rune(prompt); p.options.MaxInputLength > 0 && len(runes) > p.options.MaxInputLength {
+		prompt = string(runes[:p.options.MaxInputLength])
+	}
+	return systemPrompt.String(), prompt, nil
 }
 
 func NewGenerationModel(modelType string, config map[string]interface{}) (GenerationModel, error) {
@@ -49,14 +174,15 @@ func NewGenerationModel(modelType string, config map[string]interface{}) (Genera
 }
 
 type OllamaGenerationModelInfo struct {
-	Model        string `json:"model"`
-	Endpoint     string `json:"endpoint" default:"http://localhost:11434"`
-	SystemPrompt string `json:"system_prompt,omitempty"`
+	Model    string `json:"model"`
+	Endpoint string `json:"endpoint" default:"http://localhost:11434"`
+	GenerationOptions
 }
 
 type OllamaGenerationModel struct {
-	Info   OllamaGenerationModelInfo
-	client api.Client
+	Info    OllamaGenerationModelInfo
+	client  api.Client
+	prompts *generationPrompts
 }
 
 func NewOllamaGenerationModel(info OllamaGenerationModelInfo) (*OllamaGenerationModel, error) {
@@ -64,30 +190,68 @@ func NewOllamaGenerationModel(info OllamaGenerationModelInfo) (*OllamaGeneration
 	if err != nil {
 		return nil, err
 	}
-	if info.SystemPrompt == "" {
-		info.SystemPrompt = DefaultSystemPrompt
+	prompts, err := newGenerationPrompts(info.GenerationOptions)
+	if err != nil {
+		return nil, err
 	}
+	info.GenerationOptions = prompts.options
 	return &OllamaGenerationModel{
-		Info:   info,
-		client: *api.NewClient(ollamaUrl, http.DefaultClient),
+		Info:    info,
+		client:  *api.NewClient(ollamaUrl, http.DefaultClient),
+		prompts: prompts,
 	}, nil
 }
 
-func (o OllamaGenerationModel) Generate(ctx context.Context, texts []string) (string, error) {
+func (o OllamaGenerationModel) Generate(ctx context.Context, document GenerationDocument, texts []string) (string, error) {
+	systemPrompt, prompt, err := o.prompts.render(document, texts)
+	if err != nil {
+		return "", err
+	}
+	return o.chat(ctx, systemPrompt, prompt, o.prompts.format)
+}
+
+func (o OllamaGenerationModel) SystemPrompt() string {
+	return o.Info.SystemPrompt
+}
+
+func (o OllamaGenerationModel) OutputSchema() *jsonschema.Resolved {
+	return o.prompts.outputSchema
+}
+
+// options returns the model options of requests
+func (o OllamaGenerationModel) options() map[string]any {
+	options := make(map[string]any)
+	if o.Info.Temperature != nil {
+		options["temperature"] = *o.Info.Temperature
+	}
+	if o.Info.MaxTokens > 0 {
+		options["num_predict"] = o.Info.MaxTokens
+	}
+	return options
+}
+
+func (o OllamaGenerationModel) Chat(ctx context.Context, systemPrompt string, prompt string) (string, error) {
+	return o.chat(ctx, systemPrompt, prompt, nil)
+}
+
+// chat generates the reply in the format, which is a JSON schema or empty for free texts
+func (o OllamaGenerationModel) chat(ctx context.Context, systemPrompt string, prompt string, format json.RawMessage) (string, error) {
 	useStream := false
 	req := api.ChatRequest{
 		Model: o.Info.Model,
 		Messages: []api.Message{
 			{
 				Role:    "system",
-				Content: o.Info.SystemPrompt,
+				Content: systemPrompt,
 			},
 			{
 				Role:    "user",
-				Content: strings.Join(texts, "\n\n"),
+				Content: prompt,
 			},
 		},
-		Stream: &useStream,
+		Stream:  &useStream,
+		Format:  format,
+		Options: o.options(),
 	}
 	var respString *string = nil
 	err := o.client.Chat(ctx, &req, func(resp api.ChatResponse) error {
@@ -103,16 +267,48 @@ func (o OllamaGenerationModel) Generate(ctx context.Context, texts []string) (st
 	return *respString, nil
 }
 
+func (o OllamaGenerationModel) ChatStream(ctx context.Context, systemPrompt string, prompt string, onDelta func(delta string) error) (string, error) {
+	useStream := true
+	req := api.ChatRequest{
+		Model: o.Info.Model,
+		Messages: []api.Message{
+			{
+				Role:    "system",
+				Content: systemPrompt,
+			},
+			{
+				Role:    "user",
+				Content: prompt,
+			},
+		},
+		Stream:  &useStream,
+		Options: o.options(),
+	}
+	var reply strings.Builder
+	err := o.client.Chat(ctx, &req, func(resp api.ChatResponse) error {
+		if resp.Message.Content == "" {
+			return nil
+		}
+		reply.WriteString(resp.Message.Content)
+		return onDelta(resp.Message.Content)
+	})
+	if err != nil {
+		return "", err
+	}
+	return reply.String(), nil
+}
+
 type OpenAIGenerationModelInfo struct {
-	Model        string `json:"model"`
-	Endpoint     string `json:"endpoint"`
-	Token        string `json:"token"`
-	SystemPrompt string `json:"system_prompt,omitempty"`
+	Model    string `json:"model"`
+	Endpoint string `json:"endpoint"`
+	Token    string `json:"token"`
+	GenerationOptions
 }
 
 type OpenAIGenerationModel struct {
-	Info   OpenAIGenerationModelInfo
-	client openai.Client
+	Info    OpenAIGenerationModelInfo
+	client  openai.Client
+	prompts *generationPrompts
 }
 
 func NewOpenAIGenerationModel(info OpenAIGenerationModelInfo) (*OpenAIGenerationModel, error) {
@@ -123,25 +319,94 @@ func NewOpenAIGenerationModel(info OpenAIGenerationModelInfo) (*OpenAIGeneration
 	if info.Endpoint != "" {
 		options = append(options, option.WithBaseURL(info.Endpoint))
 	}
-	if info.SystemPrompt == "" {
-		info.SystemPrompt = DefaultSystemPrompt
+	prompts, err := newGenerationPrompts(info.GenerationOptions)
+	if err != nil {
+		return nil, err
 	}
+	info.GenerationOptions = prompts.options
 	return &OpenAIGenerationModel{
-		Info:   info,
-		client: openai.NewClient(options...),
+		Info:    info,
+		client:  openai.NewClient(options...),
+		prompts: prompts,
 	}, nil
 }
 
-func (o OpenAIGenerationModel) Generate(ctx context.Context, texts []string) (string, error) {
-	chatCompletion, err := o.client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+func (o OpenAIGenerationModel) Generate(ctx context.Context, document GenerationDocument, texts []string) (string, error) {
+	systemPrompt, prompt, err := o.prompts.render(document, texts)
+	if err != nil {
+		return "", err
+	}
+	params := o.params(systemPrompt, prompt)
+	if o.Info.OutputSchema != nil {
+		params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{
+				JSONSchema: shared.ResponseFormatJSONSchemaJSONSchemaParam{Name: "output", Schema: o.Info.OutputSchema},
+			},
+		}
+	}
+	return o.complete(ctx, params)
+}
+
+func (o OpenAIGenerationModel) SystemPrompt() string {
+	return o.Info.SystemPrompt
+}
+
+func (o OpenAIGenerationModel) OutputSchema() *jsonschema.Resolved {
+	return o.prompts.outputSchema
+}
+
+// params builds the parameters of chat completions with the options of the model
+func (o OpenAIGenerationModel) params(systemPrompt string, prompt string) openai.ChatCompletionNewParams {
+	params := openai.ChatCompletionNewParams{
 		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.SystemMessage(o.Info.SystemPrompt),
-			openai.UserMessage(strings.Join(texts, "\n\n")),
+			openai.SystemMessage(systemPrompt),
+			openai.UserMessage(prompt),
 		},
 		Model: o.Info.Model,
-	})
+	}
+	if o.Info.Temperature != nil {
+		params.Temperature = openai.Float(*o.Info.Temperature)
+	}
+	if o.Info.MaxTokens > 0 {
+		params.MaxCompletionTokens = openai.Int(o.Info.MaxTokens)
+	}
+	return params
+}
+
+func (o OpenAIGenerationModel) Chat(ctx context.Context, systemPrompt string, prompt string) (string, error) {
+	return o.complete(ctx, o.params(systemPrompt, prompt))
+}
+
+func (o OpenAIGenerationModel) complete(ctx context.Context, params openai.ChatCompletionNewParams) (string, error) {
+	chatCompletion, err := o.client.Chat.Completions.New(ctx, params)
 	if err != nil {
 		return "", err
 	}
+	if len(chatCompletion.Choices) == 0 {
+		return "", fmt.Errorf("no choices from generation model")
+	}
 	return chatCompletion.Choices[0].Message.Content, nil
 }
+
+func (o OpenAIGenerationModel) ChatStream(ctx context.Context, systemPrompt string, prompt string, onDelta func(delta string) error) (string, error) {
+	stream := o.client.Chat.Completions.NewStreaming(ctx, o.params(systemPrompt, prompt))
+	defer func() {
+		_ = stream.Close()
+	}()
+	var reply strings.Builder
+	for stream.Next() {
+		chunk := stream.Current()
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+		delta := chunk.Choices[0].Delta.Content
+		reply.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			return "", err
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return "", err
+	}
+	return reply.String(), nil
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerationPrompts(t *testing.T) {
+	prompts, err := newGenerationPrompts(GenerationOptions{
+		SystemPrompt:   "Summarize {{.Title}}{{with .Data.category}} in category {{.}}{{end}}.",
+		MaxInputLength: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	systemPrompt, prompt, err := prompts.render(GenerationDocument{Title: "联邦宪法", Data: map[string]any{"category": "law"}}, []string{"第一条 联邦", "第二条"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if systemPrompt != "Summarize 联邦宪法 in category law." {
+		t.Errorf("unexpected system prompt: %q", systemPrompt)
+	}
+	if prompt != "第一条 联邦\n\n第二" {
+		t.Errorf("unexpected prompt: %q", prompt)
+	}
+	systemPrompt, _, err = prompts.render(GenerationDocument{Title: "Empty"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if systemPrompt != "Summarize Empty." {
+		t.Errorf("unexpected system prompt: %q", systemPrompt)
+	}
+
+	defaultPrompts, err := newGenerationPrompts(GenerationOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaultPrompts.options.SystemPrompt != DefaultSystemPrompt {
+		t.Errorf("unexpected default system prompt: %q", defaultPrompts.options.SystemPrompt)
+	}
+	if _, err := newGenerationPrompts(GenerationOptions{SystemPrompt: "{{.Title"}); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+}
+
+func TestOutputSchema(t *testing.T) {
+	prompts, err := newGenerationPrompts(GenerationOptions{OutputSchema: map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"category": map[string]any{"type": "string", "enum": []any{"law", "news"}},
+			"tags":     map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		},
+		"required": []any{"category"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(prompts.options.SystemPrompt, DefaultOutputSystemPrompt) || !strings.Contains(prompts.options.SystemPrompt, `"category"`) {
+		t.Errorf("unexpected default system prompt: %q", prompts.options.SystemPrompt)
+	}
+	if _, _, err := prompts.render(GenerationDocument{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := ParseOutput(prompts.outputSchema, "```json\n{\"category\": \"law\", \"tags\": [\"tax\", \"constitution\"]}\n```")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output["category"] != "law" || len(output["tags"].([]any)) != 2 {
+		t.Errorf("unexpected output: %v", output)
+	}
+	for _, reply := range []string{
+		`{"category": "sports"}`,
+		`{"tags": ["tax"]}`,
+		`{"category": "law", "tags": "tax"}`,
+		`no object`,
+	} {
+		if _, err := ParseOutput(prompts.outputSchema, reply); err == nil {
+			t.Errorf("expected an error for the reply %q", reply)
+		}
+	}
+
+	if _, err := NewOutputSchema(map[string]any{"type": "array"}); err == nil {
+		t.Error("expected an error for a schema which isn't an object")
+	}
+}
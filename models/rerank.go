@@ -0,0 +1,171 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// RerankModelTypeTEI is the /rerank API of Hugging Face Text Embeddings Inference
+	RerankModelTypeTEI = "tei"
+	// RerankModelTypeJina is the /v1/rerank API of Jina, Cohere and compatible servers
+	RerankModelTypeJina = "jina"
+)
+
+// maxRerankPassageLength is the max number of characters of each passage in the prompt of generation models
+const maxRerankPassageLength = 1000
+
+const rerankSystemPrompt = `You rate how relevant the numbered passages are to the search query given by the user.
+Reply with only a JSON array of numbers from 0 (irrelevant) to 10 (exactly answers the query), one score per passage in the given order.`
+
+type RerankModel interface {
+	// Rerank returns the relevance scores of the texts to the query, higher is more relevant
+	Rerank(ctx context.Context, query string, texts []string) ([]float64, error)
+}
+
+func NewRerankModel(modelType string, config map[string]interface{}) (RerankModel, error) {
+	switch modelType {
+	case RerankModelTypeTEI, RerankModelTypeJina:
+		info := HTTPRerankModelInfo{}
+		jsonData, err := json.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(jsonData, &info); err != nil {
+			return nil, err
+		}
+		return NewHTTPRerankModel(modelType, info)
+	}
+	return nil, fmt.Errorf("unknown rerank model type: %s", modelType)
+}
+
+type HTTPRerankModelInfo struct {
+	// Endpoint is the URL of the rerank API, e.g. http://localhost:8080/rerank or https://api.jina.ai/v1/rerank
+	Endpoint string `json:"endpoint"`
+	Model    string `json:"model,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// HTTPRerankModel calls a cross-encoder by a rerank API
+type HTTPRerankModel struct {
+	Type   string
+	Info   HTTPRerankModelInfo
+	client *http.Client
+}
+
+func NewHTTPRerankModel(modelType string, info HTTPRerankModelInfo) (*HTTPRerankModel, error) {
+	if info.Endpoint == "" {
+		return nil, fmt.Errorf("endpoint of rerank model is required")
+	}
+	return &HTTPRerankModel{Type: modelType, Info: info, client: http.DefaultClient}, nil
+}
+
+// rerankResult is an item of the responses, TEI returns `[{"index": 0, "score": 0.9}]`,
+// Jina returns `{"results": [{"index": 0, "relevance_score": 0.9}]}`
+type rerankResult struct {
+	Index          int      `json:"index"`
+	Score          *float64 `json:"score"`
+	RelevanceScore *float64 `json:"relevance_score"`
+}
+
+func (m HTTPRerankModel) Rerank(ctx context.Context, query string, texts []string) ([]float64, error) {
+	var body any
+	if m.Type == RerankModelTypeJina {
+		body = map[string]any{"model": m.Info.Model, "query": query, "documents": texts, "top_n": len(texts)}
+	} else {
+		body = map[string]any{"query": query, "texts": texts}
+	}
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.Info.Endpoint, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.Info.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+m.Info.Token)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rerank API returned status code %d: %s", resp.StatusCode, respBody)
+	}
+	var results []rerankResult
+	if err := json.Unmarshal(respBody, &results); err != nil {
+		wrapped := struct {
+			Results []rerankResult `json:"results"`
+		}{}
+		if err := json.Unmarshal(respBody, &wrapped); err != nil {
+			return nil, fmt.Errorf("failed to parse the response of rerank API: %w", err)
+		}
+		results = wrapped.Results
+	}
+	scores := make([]float64, len(texts))
+	for _, result := range results {
+		if result.Index < 0 || result.Index >= len(texts) {
+			return nil, fmt.Errorf("rerank API returned unexpected index %d", result.Index)
+		}
+		switch {
+		case result.Score != nil:
+			scores[result.Index] = *result.Score
+		case result.RelevanceScore != nil:
+			scores[result.Index] = *result.RelevanceScore
+		}
+	}
+	return scores, nil
+}
+
+// GenerationRerankModel asks a generation model to score the texts
+type GenerationRerankModel struct {
+	model GenerationModel
+}
+
+func NewGenerationRerankModel(model GenerationModel) *GenerationRerankModel {
+	return &GenerationRerankModel{model: model}
+}
+
+func (m GenerationRerankModel) Rerank(ctx context.Context, query string, texts []string) ([]float64, error) {
+	var builder strings.Builder
+	builder.WriteString("Query: ")
+	builder.WriteString(query)
+	builder.WriteString("\n\nPassages:\n")
+	for i, text := range texts {
+		if runes := []rune(text); len(runes) > maxRerankPassageLength {
+			text = string(runes[:maxRerankPassageLength])
+		}
+		builder.WriteString(fmt.Sprintf("\n[%d] %s\n", i+1, text))
+	}
+	reply, err := m.model.Chat(ctx, rerankSystemPrompt, builder.String())
+	if err != nil {
+		return nil, err
+	}
+	// The array may be wrapped by explanations or code blocks
+	start, end := strings.Index(reply, "["), strings.LastIndex(reply, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no scores in the reply of generation model: %s", reply)
+	}
+	var scores []float64
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &scores); err != nil {
+		return nil, fmt.Errorf("failed to parse the scores of generation model: %w", err)
+	}
+	if len(scores) != len(texts) {
+		return nil, fmt.Errorf("generation model returned %d scores for %d texts", len(scores), len(texts))
+	}
+	return scores, nil
+}
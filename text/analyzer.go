@@ -0,0 +1,469 @@
+package text
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/longbridgeapp/opencc"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	TokenizerGSE        = "gse"
+	TokenizerWhitespace = "whitespace"
+	TokenizerUnicode    = "unicode"
+	TokenizerNGram      = "ngram"
+
+	NormalizerNFKC      = "nfkc"
+	NormalizerJp2t      = "jp2t"
+	NormalizerT2s       = "t2s"
+	NormalizerLowercase = "lowercase"
+	NormalizerStem      = "stem"
+
+	// nGramSize is the size of n-grams of TokenizerNGram, bigrams work well for Korean
+	nGramSize = 2
+
+	// analyzerVersion should be increased if the analysis of the same options changes, so indexes get rebuilt
+	analyzerVersion = 7
+)
+
+//go:embed stopwords/*.txt
+var builtinStopWords embed.FS
+
+// DefaultNormalizers are the steps used before the analyzer was configurable
+var DefaultNormalizers = []string{NormalizerNFKC, NormalizerT2s, NormalizerLowercase}
+
+// DefaultLanguages are the chains for detected languages, texts in other languages (including Chinese) use the default chain
+var DefaultLanguages = map[string]LanguageOptions{
+	// Japanese shinjitai are converted to traditional kanji, then to simplified Chinese like Chinese texts, so they match each other
+	LanguageJapanese: {Normalizers: []string{NormalizerNFKC, NormalizerJp2t, NormalizerT2s, NormalizerLowercase}},
+	LanguageEnglish:  {Normalizers: []string{NormalizerNFKC, NormalizerLowercase}},
+	LanguageKorean:   {Tokenizer: TokenizerNGram, Normalizers: []string{NormalizerNFKC, NormalizerLowercase}},
+	LanguageGerman:   {Tokenizer: TokenizerUnicode, Normalizers: []string{NormalizerNFKC, NormalizerLowercase}},
+	LanguageFrench:   {Tokenizer: TokenizerUnicode, Normalizers: []string{NormalizerNFKC, NormalizerLowercase}},
+}
+
+// LanguageOptions overrides the analysis for texts in a language
+type LanguageOptions struct {
+	// Tokenizer is the tokenizer for the language, the default one of the analyzer if empty
+	Tokenizer string `json:"tokenizer"`
+	// Normalizers are the steps for the language, the default ones of the analyzer if empty
+	Normalizers []string `json:"normalizers"`
+	// StopWords are paths of stop word files for the language, in addition to the built-in list of the language
+	StopWords []string `json:"stop_words"`
+}
+
+// AnalyzerOptions configures how texts are tokenized and normalized for indexing,
+// the zero value is the default analyzer
+type AnalyzerOptions struct {
+	// Tokenizer is one of TokenizerGSE (default), TokenizerWhitespace, TokenizerUnicode or TokenizerNGram
+	Tokenizer string `json:"tokenizer"`
+	// Normalizers are the steps applied to every token in order, DefaultNormalizers if empty
+	Normalizers []string `json:"normalizers"`
+	// Stemming appends NormalizerStem to all chains, so inflected Latin-script words match each other
+	Stemming bool `json:"stemming"`
+	// Transliterate indexes the pinyin of Hanzi tokens and the romaji of kana tokens,
+	// so they can be found by queries typed in Latin letters
+	Transliterate bool `json:"transliterate"`
+	// KeepStopWords disables stop word filtering
+	KeepStopWords bool `json:"keep_stop_words"`
+	// Dictionaries are paths of user dictionary files for GSE, in the format of "word frequency pos" per line
+	Dictionaries []string `json:"dictionaries"`
+	// StopWords are paths of extra stop word files, one word per line
+	StopWords []string `json:"stop_words"`
+	// Synonyms are paths of synonym files, one group of comma separated terms per line
+	Synonyms []string `json:"synonyms"`
+	// DetectLanguages are ISO 639-1 codes of languages to be detected, DefaultDetectLanguages if empty
+	DetectLanguages []string `json:"detect_languages"`
+	// Languages overrides the non-empty fields of DefaultLanguages by language code, e.g. "ja"
+	Languages map[string]LanguageOptions `json:"languages"`
+}
+
+func (o AnalyzerOptions) withDefaults() AnalyzerOptions {
+	if o.Tokenizer == "" {
+		o.Tokenizer = TokenizerGSE
+	}
+	if len(o.Normalizers) == 0 {
+		o.Normalizers = DefaultNormalizers
+	}
+	if len(o.DetectLanguages) == 0 {
+		o.DetectLanguages = DefaultDetectLanguages
+	}
+	languages := make(map[string]LanguageOptions, len(DefaultLanguages)+len(o.Languages))
+	for language, options := range DefaultLanguages {
+		languages[language] = options
+	}
+	// Only the given fields override the default ones
+	for language, options := range o.Languages {
+		merged := languages[language]
+		if options.Tokenizer != "" {
+			merged.Tokenizer = options.Tokenizer
+		}
+		if len(options.Normalizers) > 0 {
+			merged.Normalizers = options.Normalizers
+		}
+		merged.StopWords = options.StopWords
+		languages[language] = merged
+	}
+	for language, options := range languages {
+		if options.Tokenizer == "" {
+			options.Tokenizer = o.Tokenizer
+		}
+		if len(options.Normalizers) == 0 {
+			options.Normalizers = o.Normalizers
+		}
+		if o.Stemming && !slices.Contains(options.Normalizers, NormalizerStem) {
+			options.Normalizers = append(slices.Clone(options.Normalizers), NormalizerStem)
+		}
+		languages[language] = options
+	}
+	if o.Stemming && !slices.Contains(o.Normalizers, NormalizerStem) {
+		o.Normalizers = append(slices.Clone(o.Normalizers), NormalizerStem)
+	}
+	o.Languages = languages
+	return o
+}
+
+// Fingerprint identifies the effective analysis, texts indexed by analyzers with different fingerprints are not compatible.
+// The content of dictionary files is included, so editing them changes the fingerprint too.
+func (o AnalyzerOptions) Fingerprint() (string, error) {
+	o = o.withDefaults()
+	h := sha256.New()
+	encoded, err := json.Marshal(struct {
+		Version int             `json:"version"`
+		Options AnalyzerOptions `json:"options"`
+	}{analyzerVersion, o})
+	if err != nil {
+		return "", err
+	}
+	h.Write(encoded)
+	paths := slices.Concat(o.Dictionaries, o.StopWords, o.Synonyms)
+	for _, language := range slices.Sorted(maps.Keys(o.Languages)) {
+		paths = append(paths, o.Languages[language].StopWords...)
+	}
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		fileHash := sha256.Sum256(content)
+		h.Write(fileHash[:])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Analyzer detects the language of texts, and tokenizes and normalizes them with the chain of the language
+type Analyzer struct {
+	// Tokenizer is the default tokenizer for texts in unknown languages
+	Tokenizer Tokenizer
+	// Normalizer is the default normalizer for texts in unknown languages
+	Normalizer    Normalizer
+	transliterate bool
+	synonyms      *Synonyms
+	detector      *LanguageDetector
+	languages     map[string]languageChain
+	fingerprint   string
+	// vocabulary are the words in user files, to find the ones changed by reloading
+	vocabulary map[vocabularyEntry]string
+}
+
+type vocabularyEntry struct {
+	kind     string
+	language string
+	word     string
+}
+
+type languageChain struct {
+	tokenizer  Tokenizer
+	normalizer Normalizer
+}
+
+// NewAnalyzer creates the language detector, tokenizers and normalizers by the options
+func NewAnalyzer(options AnalyzerOptions) (*Analyzer, error) {
+	options = options.withDefaults()
+	fingerprint, err := options.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
+	detector, err := NewLanguageDetectorOf(options.DetectLanguages)
+	if err != nil {
+		return nil, err
+	}
+	normalizer, err := newLanguageNormalizer("", options.Normalizers)
+	if err != nil {
+		return nil, err
+	}
+	synonyms, err := loadSynonyms(options.Synonyms, normalizer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load synonyms: %w", err)
+	}
+	vocabulary := make(map[vocabularyEntry]string)
+	for term, canonical := range synonyms.Terms() {
+		vocabulary[vocabularyEntry{kind: "synonym", word: term}] = canonical
+	}
+	// GSE loads large dictionaries, so it's shared by languages
+	var gseTokenizer *GSETokenizer
+	newTokenizer := func(name string) (Tokenizer, error) {
+		switch name {
+		case TokenizerGSE:
+			if gseTokenizer != nil {
+				return gseTokenizer, nil
+			}
+			t, err := NewGSETokenizer(!options.KeepStopWords)
+			if err != nil {
+				return nil, err
+			}
+			for _, path := range options.Dictionaries {
+				if err := t.LoadUserDictionary(path); err != nil {
+					return nil, fmt.Errorf("failed to load dictionary %s: %w", path, err)
+				}
+				entries, err := readDictionary(path)
+				if err != nil {
+					return nil, err
+				}
+				for _, entry := range entries {
+					vocabulary[vocabularyEntry{kind: "dictionary", word: entry[0]}] = strings.Join(entry[1:], " ")
+				}
+			}
+			// Synonyms must not be split, otherwise they can't be found in tokens
+			if err := t.AddWords(synonyms.words); err != nil {
+				return nil, fmt.Errorf("failed to add synonyms to dictionary: %w", err)
+			}
+			gseTokenizer = t
+			return t, nil
+		case TokenizerWhitespace:
+			return &WhitespaceTokenizer{}, nil
+		case TokenizerUnicode:
+			return &UnicodeTokenizer{}, nil
+		case TokenizerNGram:
+			return NewNGramTokenizer(nGramSize), nil
+		}
+		return nil, fmt.Errorf("unknown tokenizer '%s', should be one of %s, %s, %s, %s", name, TokenizerGSE, TokenizerWhitespace, TokenizerUnicode, TokenizerNGram)
+	}
+	if options.Tokenizer != TokenizerGSE && len(options.Dictionaries) > 0 {
+		return nil, fmt.Errorf("dictionaries are not supported by tokenizer %s", options.Tokenizer)
+	}
+	tokenizer, err := newTokenizer(options.Tokenizer)
+	if err != nil {
+		return nil, err
+	}
+	if tokenizer, err = withStopWords(tokenizer, options.KeepStopWords, "", options.StopWords, vocabulary); err != nil {
+		return nil, err
+	}
+	languages := make(map[string]languageChain, len(options.Languages))
+	for language, languageOptions := range options.Languages {
+		languageTokenizer, err := newTokenizer(languageOptions.Tokenizer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create tokenizer for language %s: %w", language, err)
+		}
+		stopWords := append(append([]string{}, options.StopWords...), languageOptions.StopWords...)
+		if languageTokenizer, err = withStopWords(languageTokenizer, options.KeepStopWords, language, stopWords, vocabulary); err != nil {
+			return nil, fmt.Errorf("failed to load stop words for language %s: %w", language, err)
+		}
+		languageNormalizer, err := newLanguageNormalizer(language, languageOptions.Normalizers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create normalizer for language %s: %w", language, err)
+		}
+		languages[language] = languageChain{tokenizer: languageTokenizer, normalizer: languageNormalizer}
+	}
+	return &Analyzer{
+		Tokenizer:     tokenizer,
+		Normalizer:    normalizer,
+		transliterate: options.Transliterate,
+		synonyms:      synonyms,
+		detector:      detector,
+		languages:     languages,
+		fingerprint:   fingerprint,
+		vocabulary:    vocabulary,
+	}, nil
+}
+
+// withStopWords wraps the tokenizer to filter the built-in stop words of the language and the ones in files,
+// the ones in files are recorded in the vocabulary
+func withStopWords(tokenizer Tokenizer, keepStopWords bool, language string, paths []string, vocabulary map[vocabularyEntry]string) (Tokenizer, error) {
+	if keepStopWords {
+		return tokenizer, nil
+	}
+	stopWords := make(map[string]bool)
+	if language != "" {
+		// Not all languages have built-in stop words
+		if content, err := builtinStopWords.ReadFile("stopwords/" + language + ".txt"); err == nil {
+			for _, word := range strings.Fields(string(content)) {
+				stopWords[word] = true
+			}
+		}
+	}
+	for _, path := range paths {
+		words, err := readLines(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, word := range words {
+			stopWords[strings.ToLower(word)] = true
+			vocabulary[vocabularyEntry{kind: "stop_word", language: language, word: word}] = ""
+		}
+	}
+	if len(stopWords) == 0 {
+		return tokenizer, nil
+	}
+	return &stopWordTokenizer{tokenizer: tokenizer, stopWords: stopWords}, nil
+}
+
+// Fingerprint returns the fingerprint of the options creating the analyzer
+func (a *Analyzer) Fingerprint() string {
+	return a.fingerprint
+}
+
+// DetectLanguage returns the ISO 639-1 code of the text, or "" if it's not one of the detected languages
+func (a *Analyzer) DetectLanguage(text string) string {
+	return a.detector.DetectCode(text)
+}
+
+// TokenizerOf returns the tokenizer for the language code, or the default one for unknown languages
+func (a *Analyzer) TokenizerOf(language string) Tokenizer {
+	if chain, ok := a.languages[language]; ok {
+		return chain.tokenizer
+	}
+	return a.Tokenizer
+}
+
+// NormalizerOf returns the normalizer for the language code, or the default one for unknown languages
+func (a *Analyzer) NormalizerOf(language string) Normalizer {
+	if chain, ok := a.languages[language]; ok {
+		return chain.normalizer
+	}
+	return a.Normalizer
+}
+
+// Transliterate returns the pinyin or romaji of the tokens if transliteration is enabled.
+// Kanji in Japanese texts don't have pinyin. GSE splits unknown katakana words into characters,
+// so adjacent katakana tokens are joined and transliterated as a word too.
+func (a *Analyzer) Transliterate(tokens []string, language string) []string {
+	if !a.transliterate {
+		return nil
+	}
+	transliterated := make([]string, 0)
+	katakanaRun := make([]string, 0)
+	flushKatakanaRun := func() {
+		if len(katakanaRun) > 1 {
+			transliterated = append(transliterated, Romaji(strings.Join(katakanaRun, "")))
+		}
+		katakanaRun = katakanaRun[:0]
+	}
+	for _, token := range tokens {
+		if isKatakanaWord(token) {
+			katakanaRun = append(katakanaRun, token)
+		} else {
+			flushKatakanaRun()
+		}
+		if romaji := Romaji(token); romaji != "" {
+			transliterated = append(transliterated, romaji)
+		} else if language != LanguageJapanese {
+			transliterated = append(transliterated, pinyinOfWord(token)...)
+		}
+	}
+	flushKatakanaRun()
+	return transliterated
+}
+
+// Synonym returns the canonical term of the synonym group if the token is another term of the group,
+// the token is normalized by the default chain
+func (a *Analyzer) Synonym(token string) (string, bool) {
+	normalized, err := a.Normalizer.Normalize(token)
+	if err != nil {
+		return "", false
+	}
+	canonical, ok := a.synonyms.Canonical(normalized)
+	if !ok || canonical == normalized {
+		return "", false
+	}
+	return canonical, true
+}
+
+// ChangedWords returns the user words of dictionaries, stop words and synonyms which are added, removed or changed
+// comparing with the previous analyzer, texts containing them should be analyzed again
+func (a *Analyzer) ChangedWords(previous *Analyzer) []string {
+	changed := make(map[string]bool)
+	for entry, value := range a.vocabulary {
+		if previousValue, ok := previous.vocabulary[entry]; !ok || previousValue != value {
+			changed[entry.word] = true
+		}
+	}
+	for entry := range previous.vocabulary {
+		if _, ok := a.vocabulary[entry]; !ok {
+			changed[entry.word] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(changed))
+}
+
+// StepNormalizer applies the configured normalization steps in order
+type StepNormalizer struct {
+	steps []func(string) (string, error)
+}
+
+// NewNormalizer creates a normalizer by step names, see NormalizerNFKC etc.
+func NewNormalizer(steps []string) (Normalizer, error) {
+	return newLanguageNormalizer("", steps)
+}
+
+// newLanguageNormalizer creates a normalizer for texts in the language, which decides the stemmer
+func newLanguageNormalizer(language string, steps []string) (Normalizer, error) {
+	n := &StepNormalizer{steps: make([]func(string) (string, error), 0, len(steps))}
+	for _, step := range steps {
+		switch step {
+		case NormalizerNFKC:
+			n.steps = append(n.steps, func(s string) (string, error) { return norm.NFKC.String(s), nil })
+		case NormalizerJp2t:
+			n.steps = append(n.steps, func(s string) (string, error) { return Jp2t(s), nil })
+		case NormalizerT2s:
+			converter, err := opencc.New(step)
+			if err != nil {
+				return nil, err
+			}
+			n.steps = append(n.steps, converter.Convert)
+		case NormalizerLowercase:
+			n.steps = append(n.steps, func(s string) (string, error) { return strings.ToLower(s), nil })
+		case NormalizerStem:
+			// Languages without stemmers keep the words as they are
+			if stemmer := newStemmer(language); stemmer != nil {
+				n.steps = append(n.steps, stemmer)
+			}
+		default:
+			return nil, fmt.Errorf("unknown normalizer step '%s'", step)
+		}
+	}
+	return n, nil
+}
+
+func (n *StepNormalizer) Normalize(text string) (string, error) {
+	var err error
+	for _, step := range n.steps {
+		if text, err = step(text); err != nil {
+			return "", err
+		}
+	}
+	return text, nil
+}
+
+// readLines reads the non-empty trimmed lines of a file
+func readLines(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]string, 0)
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
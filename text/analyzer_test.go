@@ -0,0 +1,289 @@
+package text
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestNewNormalizer(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []string
+		input string
+		want  string
+	}{
+		{name: "Default", steps: DefaultNormalizers, input: "ＡＢＣ東京國際", want: "abc东京国际"},
+		{name: "NoT2s", steps: []string{NormalizerNFKC, NormalizerLowercase}, input: "ＡＢＣ國際", want: "abc國際"},
+		{name: "Empty", steps: nil, input: "ＡＢＣ", want: "ＡＢＣ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := NewNormalizer(tt.steps)
+			if err != nil {
+				t.Fatalf("NewNormalizer() error = %v", err)
+			}
+			got, err := n.Normalize(tt.input)
+			if err != nil {
+				t.Fatalf("Normalize() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Normalize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if _, err := NewNormalizer([]string{"unknown"}); err == nil {
+		t.Error("NewNormalizer() should fail for unknown steps")
+	}
+}
+
+func TestAnalyzerFingerprint(t *testing.T) {
+	defaultFingerprint, err := AnalyzerOptions{}.Fingerprint()
+	if err != nil {
+		t.Fatalf("Fingerprint() error = %v", err)
+	}
+	explicit, err := AnalyzerOptions{Tokenizer: TokenizerGSE, Normalizers: DefaultNormalizers}.Fingerprint()
+	if err != nil {
+		t.Fatalf("Fingerprint() error = %v", err)
+	}
+	if defaultFingerprint != explicit {
+		t.Error("Fingerprint() of default options should equal the explicit one")
+	}
+	changed, err := AnalyzerOptions{Normalizers: []string{NormalizerNFKC}}.Fingerprint()
+	if err != nil {
+		t.Fatalf("Fingerprint() error = %v", err)
+	}
+	if defaultFingerprint == changed {
+		t.Error("Fingerprint() should change with the normalizers")
+	}
+
+	// Editing a dictionary file changes the fingerprint
+	path := filepath.Join(t.TempDir(), "dict.txt")
+	if err := os.WriteFile(path, []byte("山达尔星 100 n\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	before, err := AnalyzerOptions{Dictionaries: []string{path}}.Fingerprint()
+	if err != nil {
+		t.Fatalf("Fingerprint() error = %v", err)
+	}
+	if err := os.WriteFile(path, []byte("山达尔星 100 n\n联邦议会 100 n\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	after, err := AnalyzerOptions{Dictionaries: []string{path}}.Fingerprint()
+	if err != nil {
+		t.Fatalf("Fingerprint() error = %v", err)
+	}
+	if before == after {
+		t.Error("Fingerprint() should change with the dictionary content")
+	}
+	if _, err := (AnalyzerOptions{Dictionaries: []string{filepath.Join(t.TempDir(), "missing.txt")}}).Fingerprint(); err == nil {
+		t.Error("Fingerprint() should fail for missing files")
+	}
+}
+
+func TestNewAnalyzer(t *testing.T) {
+	dir := t.TempDir()
+	dictPath := filepath.Join(dir, "dict.txt")
+	if err := os.WriteFile(dictPath, []byte("山达尔星 10000 n\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stopPath := filepath.Join(dir, "stop.txt")
+	if err := os.WriteFile(stopPath, []byte("联邦\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("GSEWithUserDictionary", func(t *testing.T) {
+		analyzer, err := NewAnalyzer(AnalyzerOptions{Dictionaries: []string{dictPath}, StopWords: []string{stopPath}})
+		if err != nil {
+			t.Fatalf("NewAnalyzer() error = %v", err)
+		}
+		tokens := analyzer.Tokenizer.Tokenize("山达尔星联邦")
+		if !slices.Contains(tokens, "山达尔星") {
+			t.Errorf("Tokenize() = %v, should contain the word in the user dictionary", tokens)
+		}
+		if slices.Contains(tokens, "联邦") {
+			t.Errorf("Tokenize() = %v, should not contain the stop word", tokens)
+		}
+	})
+
+	t.Run("Whitespace", func(t *testing.T) {
+		analyzer, err := NewAnalyzer(AnalyzerOptions{Tokenizer: TokenizerWhitespace, StopWords: []string{stopPath}})
+		if err != nil {
+			t.Fatalf("NewAnalyzer() error = %v", err)
+		}
+		tokens := analyzer.Tokenizer.Tokenize("山达尔星 联邦  宪法")
+		if !slices.Equal(tokens, []string{"山达尔星", "宪法"}) {
+			t.Errorf("Tokenize() = %v", tokens)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		if _, err := NewAnalyzer(AnalyzerOptions{Tokenizer: "unknown"}); err == nil {
+			t.Error("NewAnalyzer() should fail for unknown tokenizers")
+		}
+		if _, err := NewAnalyzer(AnalyzerOptions{Tokenizer: TokenizerWhitespace, Dictionaries: []string{dictPath}}); err == nil {
+			t.Error("NewAnalyzer() should fail for dictionaries with whitespace tokenizer")
+		}
+	})
+}
+
+func TestAnalyzerNormalizerOf(t *testing.T) {
+	analyzer, err := NewAnalyzer(AnalyzerOptions{
+		Languages: map[string]LanguageOptions{"ko": {Normalizers: []string{NormalizerNFKC}}},
+	})
+	if err != nil {
+		t.Fatalf("NewAnalyzer() error = %v", err)
+	}
+	tests := []struct {
+		language string
+		input    string
+		want     string
+	}{
+		{language: LanguageChinese, input: "國際", want: "国际"},
+		{language: LanguageJapanese, input: "国際検索", want: "国际检索"},
+		{language: LanguageEnglish, input: "國際", want: "國際"},
+		{language: "ko", input: "ＡＢＣ", want: "ABC"},
+		{language: "", input: "國際", want: "国际"},
+	}
+	for _, tt := range tests {
+		got, err := analyzer.NormalizerOf(tt.language).Normalize(tt.input)
+		if err != nil {
+			t.Fatalf("Normalize() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("NormalizerOf(%q).Normalize(%q) = %q, want %q", tt.language, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyzerLanguageRouting(t *testing.T) {
+	dir := t.TempDir()
+	stopPath := filepath.Join(dir, "stop_de.txt")
+	if err := os.WriteFile(stopPath, []byte("Bundesstaat\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	analyzer, err := NewAnalyzer(AnalyzerOptions{
+		DetectLanguages: []string{"zh", "ja", "en", "ko", "de", "fr"},
+		Languages:       map[string]LanguageOptions{LanguageGerman: {StopWords: []string{stopPath}}},
+	})
+	if err != nil {
+		t.Fatalf("NewAnalyzer() error = %v", err)
+	}
+
+	text := "Die Bundesrepublik Deutschland ist ein Bundesstaat in Mitteleuropa"
+	if got := analyzer.DetectLanguage(text); got != LanguageGerman {
+		t.Fatalf("DetectLanguage() = %q, want %q", got, LanguageGerman)
+	}
+	tokens := analyzer.TokenizerOf(LanguageGerman).Tokenize(text)
+	// Built-in stop words and the ones in the file are removed
+	want := []string{"Bundesrepublik", "Deutschland", "Mitteleuropa"}
+	if !slices.Equal(tokens, want) {
+		t.Errorf("TokenizerOf(de).Tokenize() = %v, want %v", tokens, want)
+	}
+
+	tokens = analyzer.TokenizerOf(LanguageKorean).Tokenize("한국어를 공부합니다")
+	if !slices.Contains(tokens, "한국") || slices.Contains(tokens, "한국어를") {
+		t.Errorf("TokenizerOf(ko).Tokenize() = %v, should be bigrams", tokens)
+	}
+
+	// Unknown languages use the default tokenizer
+	if analyzer.TokenizerOf("xx") != analyzer.Tokenizer {
+		t.Error("TokenizerOf() should return the default tokenizer for unknown languages")
+	}
+
+	if _, err := NewAnalyzer(AnalyzerOptions{DetectLanguages: []string{"zh"}}); err == nil {
+		t.Error("NewAnalyzer() should fail with less than 2 detected languages")
+	}
+	if _, err := NewAnalyzer(AnalyzerOptions{Languages: map[string]LanguageOptions{"ko": {Tokenizer: "unknown"}}}); err == nil {
+		t.Error("NewAnalyzer() should fail with unknown tokenizers of languages")
+	}
+}
+
+func TestAnalyzerSynonyms(t *testing.T) {
+	dir := t.TempDir()
+	synonymPath := filepath.Join(dir, "synonyms.txt")
+	if err := os.WriteFile(synonymPath, []byte("# fictional planets\n山达尔星, 山星, Sandar\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dictPath := filepath.Join(dir, "dict.txt")
+	if err := os.WriteFile(dictPath, []byte("银河议会\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	options := AnalyzerOptions{Synonyms: []string{synonymPath}, Dictionaries: []string{dictPath}}
+	analyzer, err := NewAnalyzer(options)
+	if err != nil {
+		t.Fatalf("NewAnalyzer() error = %v", err)
+	}
+	for _, token := range []string{"山星", "SANDAR"} {
+		if got, ok := analyzer.Synonym(token); !ok || got != "山达尔星" {
+			t.Errorf("Synonym(%q) = %q, %v, want 山达尔星", token, got, ok)
+		}
+	}
+	if _, ok := analyzer.Synonym("山达尔星"); ok {
+		t.Error("Synonym() of the canonical term should be false")
+	}
+	// Synonyms and words without frequencies in dictionaries are kept as single tokens
+	tokens := analyzer.Tokenizer.Tokenize("山星和银河议会")
+	for _, want := range []string{"山星", "银河议会"} {
+		if !slices.Contains(tokens, want) {
+			t.Errorf("Tokenize() = %v, should contain %s", tokens, want)
+		}
+	}
+
+	if err := os.WriteFile(synonymPath, []byte("山达尔星, 山星\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := NewAnalyzer(options)
+	if err != nil {
+		t.Fatalf("NewAnalyzer() error = %v", err)
+	}
+	if got := reloaded.ChangedWords(analyzer); !slices.Equal(got, []string{"sandar"}) {
+		t.Errorf("ChangedWords() = %v, want [sandar]", got)
+	}
+	if reloaded.Fingerprint() == analyzer.Fingerprint() {
+		t.Error("Fingerprint() should change with the synonyms")
+	}
+
+	if err := os.WriteFile(synonymPath, []byte("山达尔星\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewAnalyzer(options); err == nil {
+		t.Error("NewAnalyzer() should fail for a synonym group with a single term")
+	}
+}
+
+func TestStemming(t *testing.T) {
+	n, err := NewNormalizer([]string{NormalizerLowercase, NormalizerStem})
+	if err != nil {
+		t.Fatalf("NewNormalizer() error = %v", err)
+	}
+	for input, want := range map[string]string{"Running": "run", "runs": "run", "connection": "connect", "東京": "東京", "3.14": "3.14"} {
+		if got, _ := n.Normalize(input); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", input, got, want)
+		}
+	}
+
+	withStemming := AnalyzerOptions{Stemming: true}.withDefaults()
+	if !slices.Contains(withStemming.Normalizers, NormalizerStem) || !slices.Contains(withStemming.Languages[LanguageEnglish].Normalizers, NormalizerStem) {
+		t.Errorf("withDefaults() should append the stem step to all chains, got %v", withStemming.Normalizers)
+	}
+	if slices.Contains(AnalyzerOptions{}.withDefaults().Normalizers, NormalizerStem) {
+		t.Error("withDefaults() should not stem by default")
+	}
+	// Languages without Snowball stemmers keep the words
+	german, err := newLanguageNormalizer(LanguageGerman, []string{NormalizerStem})
+	if err != nil {
+		t.Fatalf("newLanguageNormalizer() error = %v", err)
+	}
+	if got, _ := german.Normalize("häuser"); got != "häuser" {
+		t.Errorf("Normalize() = %q, want häuser", got)
+	}
+	french, err := newLanguageNormalizer(LanguageFrench, []string{NormalizerStem})
+	if err != nil {
+		t.Fatalf("newLanguageNormalizer() error = %v", err)
+	}
+	if got, _ := french.Normalize("continuellement"); got != "continuel" {
+		t.Errorf("Normalize() = %q, want continuel", got)
+	}
+}
@@ -0,0 +1,25 @@
+package text
+
+import "testing"
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		max  int
+		want int
+	}{
+		{a: "federation", b: "federation", max: 2, want: 0},
+		{a: "federation", b: "fedaration", max: 2, want: 1},
+		{a: "federation", b: "federaton", max: 2, want: 1},
+		{a: "kitten", b: "sitting", max: 3, want: 3},
+		{a: "kitten", b: "sitting", max: 2, want: 3},
+		{a: "联邦政府", b: "联邦攻府", max: 1, want: 1},
+		{a: "", b: "abc", max: 5, want: 3},
+		{a: "a", b: "abcdef", max: 2, want: 3},
+	}
+	for _, tt := range tests {
+		if got := EditDistance(tt.a, tt.b, tt.max); got != tt.want {
+			t.Errorf("EditDistance(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.max, got, tt.want)
+		}
+	}
+}
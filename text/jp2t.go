@@ -0,0 +1,41 @@
+package text
+
+import (
+	_ "embed"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+//go:embed jp2t.txt
+var jp2tTable string
+
+// jp2tMapping maps Japanese shinjitai to kyūjitai, it's parsed from jp2tTable on the first use
+var jp2tMapping = sync.OnceValue(func() map[rune]rune {
+	mapping := make(map[rune]rune)
+	for _, line := range strings.Split(jp2tTable, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || utf8.RuneCountInString(fields[0]) != 1 || utf8.RuneCountInString(fields[1]) != 1 {
+			continue
+		}
+		from, _ := utf8.DecodeRuneInString(fields[0])
+		to, _ := utf8.DecodeRuneInString(fields[1])
+		mapping[from] = to
+	}
+	return mapping
+})
+
+// Jp2t converts Japanese shinjitai (new kanji) to kyūjitai, which are the traditional Chinese characters mostly,
+// e.g. "検索" to "檢索", so Japanese texts can be unified with Chinese texts by converting to simplified Chinese later
+func Jp2t(text string) string {
+	mapping := jp2tMapping()
+	return strings.Map(func(r rune) rune {
+		if to, ok := mapping[r]; ok {
+			return to
+		}
+		return r
+	}, text)
+}
@@ -2,9 +2,26 @@
 package text
 
 import (
+	"fmt"
+	"slices"
+	"strings"
+
 	"github.com/pemistahl/lingua-go"
 )
 
+// ISO 639-1 codes of languages with built-in analysis chains
+const (
+	LanguageChinese  = "zh"
+	LanguageJapanese = "ja"
+	LanguageEnglish  = "en"
+	LanguageKorean   = "ko"
+	LanguageGerman   = "de"
+	LanguageFrench   = "fr"
+)
+
+// DefaultDetectLanguages are the languages detected by NewLanguageDetector
+var DefaultDetectLanguages = []string{LanguageChinese, LanguageJapanese, LanguageEnglish}
+
 // LanguageDetector detects the language of a given text
 type LanguageDetector struct {
 	detector lingua.LanguageDetector
@@ -29,6 +46,28 @@ func NewLanguageDetector() *LanguageDetector {
 	}
 }
 
+// NewLanguageDetectorOf creates a language detector for the languages by ISO 639-1 codes, e.g. "ko",
+// at least 2 languages are required
+func NewLanguageDetectorOf(codes []string) (*LanguageDetector, error) {
+	isoCodes := make([]lingua.IsoCode639_1, 0, len(codes))
+	for _, code := range codes {
+		isoCode := lingua.GetIsoCode639_1FromValue(code)
+		if isoCode == lingua.UnknownIsoCode639_1 {
+			return nil, fmt.Errorf("unknown language code '%s'", code)
+		}
+		if !slices.Contains(isoCodes, isoCode) {
+			isoCodes = append(isoCodes, isoCode)
+		}
+	}
+	if len(isoCodes) < 2 {
+		return nil, fmt.Errorf("at least 2 languages are required for detection, got %v", codes)
+	}
+	detector := lingua.NewLanguageDetectorBuilder().
+		FromIsoCodes639_1(isoCodes...).
+		Build()
+	return &LanguageDetector{detector: detector}, nil
+}
+
 // Detect detects the language of the given text
 // Returns one of: lingua.Chinese, lingua.Japanese, lingua.English, or lingua.Unknown
 func (d *LanguageDetector) Detect(text string) lingua.Language {
@@ -43,3 +82,13 @@ func (d *LanguageDetector) Detect(text string) lingua.Language {
 
 	return detectedLang
 }
+
+// DetectCode detects the language of the given text and returns its ISO 639-1 code in lower case,
+// e.g. "zh", or "" if the language is unknown
+func (d *LanguageDetector) DetectCode(text string) string {
+	language := d.Detect(text)
+	if language == lingua.Unknown {
+		return ""
+	}
+	return strings.ToLower(language.IsoCode639_1().String())
+}
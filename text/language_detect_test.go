@@ -182,3 +182,49 @@ func BenchmarkLanguageDetector_Detect_English(b *testing.B) {
 		detector.Detect(text)
 	}
 }
+
+func TestLanguageDetector_DetectCode(t *testing.T) {
+	detector := NewLanguageDetector()
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "", want: ""},
+		{text: "山达尔星联邦共和国是一个强大的政治实体", want: LanguageChinese},
+		{text: "これは日本語の文章です。東京は日本の首都です。", want: LanguageJapanese},
+		{text: "The quick brown fox jumps over the lazy dog", want: LanguageEnglish},
+	}
+	for _, tt := range tests {
+		if got := detector.DetectCode(tt.text); got != tt.want {
+			t.Errorf("DetectCode(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestNewLanguageDetectorOf(t *testing.T) {
+	detector, err := NewLanguageDetectorOf([]string{"zh", "ja", "en", "ko", "de", "fr"})
+	if err != nil {
+		t.Fatalf("NewLanguageDetectorOf() error = %v", err)
+	}
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "한국어는 대한민국의 공용어입니다", want: LanguageKorean},
+		{text: "Die Bundesrepublik Deutschland ist ein Bundesstaat in Mitteleuropa", want: LanguageGerman},
+		{text: "La France est un pays dont la capitale est Paris", want: LanguageFrench},
+	}
+	for _, tt := range tests {
+		if got := detector.DetectCode(tt.text); got != tt.want {
+			t.Errorf("DetectCode(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	if _, err := NewLanguageDetectorOf([]string{"zh"}); err == nil {
+		t.Error("NewLanguageDetectorOf() should fail with less than 2 languages")
+	}
+	if _, err := NewLanguageDetectorOf([]string{"zh", "xx"}); err == nil {
+		t.Error("NewLanguageDetectorOf() should fail with unknown languages")
+	}
+}
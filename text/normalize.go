@@ -12,7 +12,7 @@ type Normalizer interface {
 }
 
 type CJKNormalizer struct {
-	jp2t *opencc.OpenCC
+	jp2t bool
 	t2s  *opencc.OpenCC
 }
 
@@ -23,21 +23,15 @@ type CJKNormalizer struct {
 // 3. Traditional Chinese Kanji -> Simplified Chinese Kanji
 // The parameters control whether to apply the respective conversions.
 func NewCJKNormalizer(useJp2t, useT2s bool) (Normalizer, error) {
-	var jp2t, t2s *opencc.OpenCC = nil, nil
+	var t2s *opencc.OpenCC = nil
 	var err error
-	if useJp2t { // TODO jp2t is not valid yet
-		jp2t, err = opencc.New("jp2t") // JP new Kanji -> Traditional Chinese Kanji / JP old Kanji
-		if err != nil {
-			return nil, err
-		}
-	}
 	if useT2s {
 		t2s, err = opencc.New("t2s") // Traditional Chinese Kanji -> Simplified Chinese Kanji
 		if err != nil {
 			return nil, err
 		}
 	}
-	return &CJKNormalizer{jp2t: jp2t, t2s: t2s}, nil
+	return &CJKNormalizer{jp2t: useJp2t, t2s: t2s}, nil
 }
 
 func (n *CJKNormalizer) Normalize(text string) (string, error) {
@@ -45,11 +39,9 @@ func (n *CJKNormalizer) Normalize(text string) (string, error) {
 	s := norm.NFKC.String(text)
 	var err error
 	// 2. Japanese New Kanji -> Traditional Chinese Kanji/Japanese Old Kanji
-	if n.jp2t != nil {
-		s, err = n.jp2t.Convert(s)
-		if err != nil {
-			return "", err
-		}
+	if n.jp2t {
+		// OpenCC jp2t is not supported by the Go port, an embedded table is used instead
+		s = Jp2t(s)
 	}
 	// 3. Traditional Chinese Kanji -> Simplified Chinese Kanji
 	if n.t2s != nil {
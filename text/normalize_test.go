@@ -23,6 +23,12 @@ func TestNewCJKNormalizer(t *testing.T) {
 			useT2s:  true,
 			wantErr: false,
 		},
+		{
+			name:    "创建jp2t和t2s的normalizer",
+			useJp2t: true,
+			useT2s:  true,
+			wantErr: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -104,7 +110,6 @@ func TestCJKNormalizer_Normalize_NFKC(t *testing.T) {
 }
 
 func TestCJKNormalizer_Normalize_JP2T(t *testing.T) {
-	t.Skip("jp2t not supported yet")
 	n, err := NewCJKNormalizer(true, false)
 	if err != nil {
 		t.Fatalf("NewCJKNormalizer() error = %v", err)
@@ -128,6 +133,18 @@ func TestCJKNormalizer_Normalize_JP2T(t *testing.T) {
 			want:    "日本國",
 			wantErr: false,
 		},
+		{
+			name:    "学校和检索",
+			input:   "学校で検索する",
+			want:    "學校で檢索する",
+			wantErr: false,
+		},
+		{
+			name:    "旧字体不变",
+			input:   "國學",
+			want:    "國學",
+			wantErr: false,
+		},
 		{
 			name:    "普通文本不变",
 			input:   "Hello",
@@ -197,7 +214,6 @@ func TestCJKNormalizer_Normalize_T2S(t *testing.T) {
 }
 
 func TestCJKNormalizer_Normalize_Full(t *testing.T) {
-	t.Skip("jp2t not supported yet")
 	n, err := NewCJKNormalizer(true, true)
 	if err != nil {
 		t.Fatalf("NewCJKNormalizer() error = %v", err)
@@ -243,6 +259,68 @@ func TestCJKNormalizer_Normalize_Full(t *testing.T) {
 	}
 }
 
+func TestCJKNormalizer_CrossScript(t *testing.T) {
+	// 日文按 jp2t+t2s 处理，中文按 t2s 处理，新字体、旧字体、繁体和简体应得到相同的结果
+	japanese, err := NewCJKNormalizer(true, true)
+	if err != nil {
+		t.Fatalf("NewCJKNormalizer() error = %v", err)
+	}
+	chinese, err := NewCJKNormalizer(false, true)
+	if err != nil {
+		t.Fatalf("NewCJKNormalizer() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		japanese []string
+		chinese  []string
+		want     string
+	}{
+		{name: "国", japanese: []string{"国", "國"}, chinese: []string{"国", "國"}, want: "国"},
+		{name: "学", japanese: []string{"学", "學"}, chinese: []string{"学", "學"}, want: "学"},
+		{name: "検索", japanese: []string{"検索", "檢索"}, chinese: []string{"检索", "檢索"}, want: "检索"},
+		{name: "駅", japanese: []string{"駅", "驛"}, chinese: []string{"驿", "驛"}, want: "驿"},
+		{name: "広島", japanese: []string{"広島", "廣島"}, chinese: []string{"广岛", "廣島"}, want: "广岛"},
+		{name: "国際貿易", japanese: []string{"国際貿易", "國際貿易"}, chinese: []string{"国际贸易", "國際貿易"}, want: "国际贸易"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, input := range tt.japanese {
+				if got, _ := japanese.Normalize(input); got != tt.want {
+					t.Errorf("Japanese Normalize(%v) = %v, want %v", input, got, tt.want)
+				}
+			}
+			for _, input := range tt.chinese {
+				if got, _ := chinese.Normalize(input); got != tt.want {
+					t.Errorf("Chinese Normalize(%v) = %v, want %v", input, got, tt.want)
+				}
+			}
+		})
+	}
+
+	// 按语言启用：日文的默认处理链包含 jp2t，中文的不包含
+	a := &Analyzer{languages: map[string]languageChain{}}
+	for _, language := range []string{LanguageJapanese} {
+		normalizer, err := newLanguageNormalizer(language, AnalyzerOptions{}.withDefaults().Languages[language].Normalizers)
+		if err != nil {
+			t.Fatalf("newLanguageNormalizer() error = %v", err)
+		}
+		a.languages[language] = languageChain{normalizer: normalizer}
+	}
+	a.Normalizer, err = NewNormalizer(DefaultNormalizers)
+	if err != nil {
+		t.Fatalf("NewNormalizer() error = %v", err)
+	}
+	if got, _ := a.NormalizerOf(LanguageJapanese).Normalize("検索"); got != "检索" {
+		t.Errorf("Japanese chain Normalize() = %v, want 检索", got)
+	}
+	// 中文里的 "芸" 不是 "藝" 的新字体
+	if got, _ := a.NormalizerOf(LanguageChinese).Normalize("芸"); got != "芸" {
+		t.Errorf("Chinese chain Normalize() = %v, want 芸", got)
+	}
+}
+
 func TestCJKNormalizer_Interface(t *testing.T) {
 	// 测试 CJKNormalizer 实现了 Normalizer 接口
 	var _ Normalizer = &CJKNormalizer{}
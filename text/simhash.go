@@ -0,0 +1,93 @@
+package text
+
+import (
+	"hash/fnv"
+	"math/bits"
+)
+
+// SimHashBits is the length of a SimHash signature
+const SimHashBits = 64
+
+// SimHash computes the 64-bit SimHash signature of tokens, every token is weighted by its frequency.
+// Texts sharing most of their tokens have signatures with a small Hamming distance.
+// It returns 0 if there's no token.
+func SimHash(tokens []string) uint64 {
+	var weights [SimHashBits]int
+	count := 0
+	for _, token := range tokens {
+		if token == "" {
+			continue
+		}
+		count++
+		h := hashToken(token)
+		for i := 0; i < SimHashBits; i++ {
+			if h&(1<<i) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	var signature uint64
+	for i, w := range weights {
+		if w > 0 {
+			signature |= 1 << i
+		}
+	}
+	return signature
+}
+
+// HammingDistance returns the number of different bits between two signatures
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// SimHashBand is a slice of bits of a SimHash signature, it's comparable and can be used as a map key
+type SimHashBand struct {
+	Index int
+	Bits  uint64
+}
+
+// SimHashBands splits the signature into n bands.
+// By the pigeonhole principle, two signatures within Hamming distance n-1 share at least one band,
+// so the bands can be used to find candidates without comparing all pairs.
+func SimHashBands(signature uint64, n int) []SimHashBand {
+	if n <= 0 {
+		n = 1
+	}
+	if n > SimHashBits {
+		n = SimHashBits
+	}
+	bands := make([]SimHashBand, 0, n)
+	start := 0
+	for i := 0; i < n; i++ {
+		width := SimHashBits / n
+		if i < SimHashBits%n {
+			width++
+		}
+		band := signature >> start
+		if width < SimHashBits {
+			band &= 1<<width - 1
+		}
+		bands = append(bands, SimHashBand{Index: i, Bits: band})
+		start += width
+	}
+	return bands
+}
+
+// hashToken hashes a token with FNV-1a and mixes the bits, since FNV-1a is poorly distributed for short inputs
+func hashToken(token string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(token))
+	x := h.Sum64()
+	// SplitMix64 finalizer
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,85 @@
+package text
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSimHash(t *testing.T) {
+	if got := SimHash(nil); got != 0 {
+		t.Errorf("SimHash(nil) = %x, want 0", got)
+	}
+	tokens := strings.Fields("the quick brown fox jumps over the lazy dog")
+	if SimHash(tokens) != SimHash(tokens) {
+		t.Error("SimHash() is not deterministic")
+	}
+	if SimHash([]string{"", "fox"}) != SimHash([]string{"fox"}) {
+		t.Error("SimHash() should ignore empty tokens")
+	}
+}
+
+func TestSimHashNearDuplicates(t *testing.T) {
+	base := make([]string, 0, 200)
+	for i := 0; i < 200; i++ {
+		base = append(base, fmt.Sprintf("word%d", i))
+	}
+	// A page with a changed timestamp and an extra advertisement
+	near := append(append([]string{}, base[:199]...), "2024-01-01", "广告")
+	different := make([]string, 0, 200)
+	for i := 0; i < 200; i++ {
+		different = append(different, fmt.Sprintf("other%d", i))
+	}
+
+	nearDistance := HammingDistance(SimHash(base), SimHash(near))
+	differentDistance := HammingDistance(SimHash(base), SimHash(different))
+	if nearDistance > 6 {
+		t.Errorf("distance of near duplicates = %d, want <= 6", nearDistance)
+	}
+	if differentDistance <= 16 {
+		t.Errorf("distance of different texts = %d, want > 16", differentDistance)
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	tests := []struct {
+		a, b uint64
+		want int
+	}{
+		{0, 0, 0},
+		{0, 1, 1},
+		{0xFF, 0x0F, 4},
+		{0, ^uint64(0), 64},
+	}
+	for _, tt := range tests {
+		if got := HammingDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("HammingDistance(%x, %x) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSimHashBands(t *testing.T) {
+	for _, n := range []int{1, 3, 4, 7, 64} {
+		bands := SimHashBands(0x0123456789ABCDEF, n)
+		if len(bands) != n {
+			t.Fatalf("SimHashBands(n=%d) returned %d bands", n, len(bands))
+		}
+	}
+	// Signatures within distance 3 share at least one of 4 bands
+	a := uint64(0x0123456789ABCDEF)
+	b := a ^ (1 << 3) ^ (1 << 20) ^ (1 << 40)
+	shared := false
+	bandsB := SimHashBands(b, 4)
+	for i, band := range SimHashBands(a, 4) {
+		if band == bandsB[i] {
+			shared = true
+		}
+	}
+	if !shared {
+		t.Error("signatures within distance 3 should share a band")
+	}
+	// Full width band keeps all bits
+	if got := SimHashBands(a, 1)[0].Bits; got != a {
+		t.Errorf("SimHashBands(n=1) = %x, want %x", got, a)
+	}
+}
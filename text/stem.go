@@ -0,0 +1,48 @@
+package text
+
+import (
+	"unicode"
+
+	"github.com/kljensen/snowball"
+)
+
+// snowballLanguages maps ISO 639-1 codes to the languages of Snowball stemmers
+var snowballLanguages = map[string]string{
+	LanguageEnglish: "english",
+	LanguageFrench:  "french",
+	"es":            "spanish",
+	"sv":            "swedish",
+	"no":            "norwegian",
+	"hu":            "hungarian",
+}
+
+// newStemmer creates a Snowball stemmer for Latin-script tokens in the language,
+// English is used for texts in unknown languages, and nil is returned if there's no stemmer for the language
+func newStemmer(language string) func(string) (string, error) {
+	if language == "" {
+		language = LanguageEnglish
+	}
+	snowballLanguage, ok := snowballLanguages[language]
+	if !ok {
+		return nil
+	}
+	return func(token string) (string, error) {
+		if !isLatinWord(token) {
+			return token, nil
+		}
+		return snowball.Stem(token, snowballLanguage, false)
+	}
+}
+
+// isLatinWord reports whether the token consists of Latin letters only
+func isLatinWord(token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, r := range token {
+		if !unicode.Is(unicode.Latin, r) {
+			return false
+		}
+	}
+	return true
+}
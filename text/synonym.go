@@ -0,0 +1,64 @@
+package text
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Synonyms maps every term of a synonym group to the first term of the group,
+// texts containing any term of the group are indexed with the first one, so they match each other
+type Synonyms struct {
+	canonical map[string]string
+	// words are the terms before normalization, they are added to the dictionary of GSE
+	words []string
+}
+
+// loadSynonyms reads synonym files, one group of comma separated terms per line, lines starting with # are comments.
+// Terms are normalized by the normalizer, so the lookup is insensitive to case, width etc.
+func loadSynonyms(paths []string, normalizer Normalizer) (*Synonyms, error) {
+	s := &Synonyms{canonical: make(map[string]string)}
+	for _, path := range paths {
+		lines, err := readLines(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+			terms := make([]string, 0)
+			for _, term := range strings.Split(line, ",") {
+				if term = strings.TrimSpace(term); term == "" {
+					continue
+				}
+				s.words = append(s.words, term)
+				normalized, err := normalizer.Normalize(term)
+				if err != nil {
+					return nil, err
+				}
+				terms = append(terms, normalized)
+			}
+			if len(terms) < 2 {
+				return nil, fmt.Errorf("synonym group '%s' in %s should have at least 2 terms", line, path)
+			}
+			for _, term := range terms {
+				if existing, ok := s.canonical[term]; ok && existing != terms[0] {
+					return nil, fmt.Errorf("synonym term '%s' in %s belongs to groups of both '%s' and '%s'", term, path, existing, terms[0])
+				}
+				s.canonical[term] = terms[0]
+			}
+		}
+	}
+	return s, nil
+}
+
+// Canonical returns the first term of the group of the normalized term
+func (s *Synonyms) Canonical(term string) (string, bool) {
+	canonical, ok := s.canonical[term]
+	return canonical, ok
+}
+
+// Terms returns all normalized terms with their canonical terms
+func (s *Synonyms) Terms() map[string]string {
+	return s.canonical
+}
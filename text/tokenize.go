@@ -1,9 +1,16 @@
 package text
 
 import (
+	"strconv"
+	"strings"
+	"unicode"
+
 	"github.com/go-ego/gse"
 )
 
+// defaultWordFrequency is the frequency of user words without one, high enough to keep them as single tokens
+const defaultWordFrequency = 1000
+
 // Tokenizer is the interface for text tokenization
 type Tokenizer interface {
 	// Tokenize splits text into tokens
@@ -66,3 +73,132 @@ func (t *GSETokenizer) filterStopWords(tokens []string) []string {
 	}
 	return filtered
 }
+
+// LoadUserDictionary adds the words in a dictionary file, one word per line in the format of "word frequency pos",
+// frequency and pos are optional
+func (t *GSETokenizer) LoadUserDictionary(path string) error {
+	entries, err := readDictionary(path)
+	if err != nil {
+		return err
+	}
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		lines = append(lines, strings.Join(entry, " "))
+	}
+	return t.seg.LoadDictStr(strings.Join(lines, "\n"))
+}
+
+// AddWords adds the words which are not in the dictionary yet, so they are kept as single tokens
+func (t *GSETokenizer) AddWords(words []string) error {
+	added := false
+	for _, word := range words {
+		if _, _, ok := t.seg.Find(word); ok {
+			continue
+		}
+		if err := t.seg.AddToken(word, defaultWordFrequency); err != nil {
+			return err
+		}
+		added = true
+	}
+	if added {
+		t.seg.CalcToken()
+	}
+	return nil
+}
+
+// readDictionary reads the entries of a dictionary file as [word, frequency, pos...],
+// defaultWordFrequency is used if the frequency is missing
+func readDictionary(path string) ([][]string, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([][]string, 0, len(lines))
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry := strings.Fields(line)
+		if len(entry) == 1 {
+			entry = append(entry, strconv.Itoa(defaultWordFrequency))
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// LoadStopWords adds the words in a file as stop words, one word per line
+func (t *GSETokenizer) LoadStopWords(path string) error {
+	words, err := readLines(path)
+	if err != nil {
+		return err
+	}
+	t.seg.LoadStopArr(words)
+	return nil
+}
+
+// WhitespaceTokenizer splits text by whitespaces, for texts which are already segmented
+type WhitespaceTokenizer struct{}
+
+func (t *WhitespaceTokenizer) Tokenize(text string) []string {
+	return strings.Fields(text)
+}
+
+// UnicodeTokenizer splits text into runs of letters and numbers, a fallback for languages separating words
+// by spaces and punctuations but not supported by GSE, e.g. German and French
+type UnicodeTokenizer struct{}
+
+func (t *UnicodeTokenizer) Tokenize(text string) []string {
+	return strings.FieldsFunc(text, isNotWordRune)
+}
+
+// NGramTokenizer splits text like UnicodeTokenizer, and words in scripts without reliable word boundaries,
+// e.g. Hangul, are split into overlapped n-grams, so queries can match words with different suffixes
+type NGramTokenizer struct {
+	n int
+}
+
+// NewNGramTokenizer creates a n-gram tokenizer, n should be positive
+func NewNGramTokenizer(n int) *NGramTokenizer {
+	return &NGramTokenizer{n: n}
+}
+
+func (t *NGramTokenizer) Tokenize(text string) []string {
+	tokens := make([]string, 0)
+	for _, word := range strings.FieldsFunc(text, isNotWordRune) {
+		runes := []rune(word)
+		if len(runes) <= t.n || !strings.ContainsFunc(word, isNGramRune) {
+			tokens = append(tokens, word)
+			continue
+		}
+		for i := 0; i+t.n <= len(runes); i++ {
+			tokens = append(tokens, string(runes[i:i+t.n]))
+		}
+	}
+	return tokens
+}
+
+func isNotWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsMark(r)
+}
+
+func isNGramRune(r rune) bool {
+	return unicode.In(r, unicode.Hangul, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Thai)
+}
+
+// stopWordTokenizer removes stop words from tokens of another tokenizer
+type stopWordTokenizer struct {
+	tokenizer Tokenizer
+	stopWords map[string]bool
+}
+
+func (t *stopWordTokenizer) Tokenize(text string) []string {
+	tokens := t.tokenizer.Tokenize(text)
+	filtered := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if !t.stopWords[strings.ToLower(token)] {
+			filtered = append(filtered, token)
+		}
+	}
+	return filtered
+}
@@ -1,6 +1,7 @@
 package text
 
 import (
+	"slices"
 	"testing"
 )
 
@@ -134,3 +135,29 @@ func BenchmarkGSETokenizer_Tokenize_Mixed(b *testing.B) {
 		_ = tokenizer.Tokenize(text)
 	}
 }
+
+func TestUnicodeTokenizer(t *testing.T) {
+	tokenizer := &UnicodeTokenizer{}
+	got := tokenizer.Tokenize("Die Straße ist schön, l'été est là! 2024")
+	want := []string{"Die", "Straße", "ist", "schön", "l", "été", "est", "là", "2024"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Tokenize() = %v, want %v", got, want)
+	}
+}
+
+func TestNGramTokenizer(t *testing.T) {
+	tokenizer := NewNGramTokenizer(2)
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{text: "한국어를 공부합니다", want: []string{"한국", "국어", "어를", "공부", "부합", "합니", "니다"}},
+		{text: "서울 hello", want: []string{"서울", "hello"}},
+		{text: "가", want: []string{"가"}},
+	}
+	for _, tt := range tests {
+		if got := tokenizer.Tokenize(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
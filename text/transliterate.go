@@ -0,0 +1,125 @@
+package text
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/mozillazg/go-pinyin"
+)
+
+// Pinyin returns the toneless pinyin of a token of Hanzi, e.g. "lianbang" for "联邦",
+// or "" if the token contains other characters
+func Pinyin(token string) string {
+	if token == "" || strings.ContainsFunc(token, func(r rune) bool { return !unicode.Is(unicode.Han, r) }) {
+		return ""
+	}
+	return strings.Join(pinyin.LazyPinyin(token, pinyin.NewArgs()), "")
+}
+
+// pinyinOfWord returns the pinyin of a Hanzi word, and the ones of its bigrams if it's longer,
+// since GSE keeps compound words like "联邦政府" as single tokens, but users type the pinyin of shorter words
+func pinyinOfWord(token string) []string {
+	whole := Pinyin(token)
+	if whole == "" {
+		return nil
+	}
+	result := []string{whole}
+	runes := []rune(token)
+	if len(runes) > 2 {
+		for i := 0; i+2 <= len(runes); i++ {
+			result = append(result, Pinyin(string(runes[i:i+2])))
+		}
+	}
+	return result
+}
+
+// hiraganaRomaji is the Hepburn romanization of hiragana, katakana are converted to hiragana before the lookup
+var hiraganaRomaji = map[rune]string{
+	'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+	'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+	'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+	'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+	'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+	'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+	'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+	'や': "ya", 'ゆ': "yu", 'よ': "yo",
+	'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+	'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n",
+	'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+	'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+	'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+	'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+	'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+	'ゔ': "vu",
+}
+
+// smallKana are the small kana combined with the previous kana, e.g. "kya" for "きゃ", "fa" for "ふぁ"
+var smallKana = map[rune]string{
+	'ゃ': "ya", 'ゅ': "yu", 'ょ': "yo",
+	'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o", 'ゎ': "wa",
+}
+
+// Romaji returns the Hepburn romanization of a token of kana, e.g. "toukyou" for "とうきょう" and "konpyuutaa" for "コンピューター",
+// or "" if the token contains other characters
+func Romaji(token string) string {
+	if token == "" {
+		return ""
+	}
+	syllables := make([]string, 0, len(token)/3)
+	doubleConsonant := false
+	for _, r := range token {
+		if r >= 'ァ' && r <= 'ヶ' {
+			// Katakana to hiragana
+			r -= 'ァ' - 'ぁ'
+		}
+		switch {
+		case r == 'っ':
+			doubleConsonant = true
+			continue
+		case r == 'ー':
+			// Long vowel mark repeats the last vowel
+			if len(syllables) > 0 {
+				last := syllables[len(syllables)-1]
+				syllables = append(syllables, last[len(last)-1:])
+			}
+			continue
+		}
+		var syllable string
+		if small, ok := smallKana[r]; ok {
+			if len(syllables) == 0 {
+				syllables = append(syllables, small)
+				continue
+			}
+			previous := syllables[len(syllables)-1]
+			syllables = syllables[:len(syllables)-1]
+			switch {
+			case strings.HasPrefix(small, "y") && (strings.HasSuffix(previous, "shi") || strings.HasSuffix(previous, "chi") || strings.HasSuffix(previous, "ji")):
+				// しゃ is "sha" rather than "shya"
+				syllable = previous[:len(previous)-1] + small[1:]
+			case len(previous) > 1:
+				syllable = previous[:len(previous)-1] + small
+			default:
+				syllable = previous + small
+			}
+		} else if romaji, ok := hiraganaRomaji[r]; ok {
+			syllable = romaji
+		} else {
+			return ""
+		}
+		if doubleConsonant {
+			if strings.HasPrefix(syllable, "ch") {
+				syllable = "t" + syllable
+			} else if !strings.ContainsRune("aiueon", rune(syllable[0])) {
+				syllable = syllable[:1] + syllable
+			}
+			doubleConsonant = false
+		}
+		syllables = append(syllables, syllable)
+	}
+	return strings.Join(syllables, "")
+}
+
+// isKatakanaWord reports whether the token consists of katakana and long vowel marks only
+func isKatakanaWord(token string) bool {
+	return token != "" && !strings.ContainsFunc(token, func(r rune) bool { return !unicode.Is(unicode.Katakana, r) && r != 'ー' })
+}
@@ -0,0 +1,56 @@
+package text
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPinyin(t *testing.T) {
+	tests := map[string]string{
+		"联邦":   "lianbang",
+		"聯邦":   "lianbang",
+		"山达尔星": "shandaerxing",
+		"联邦a":  "",
+		"":     "",
+	}
+	for input, want := range tests {
+		if got := Pinyin(input); got != want {
+			t.Errorf("Pinyin(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestRomaji(t *testing.T) {
+	tests := map[string]string{
+		"とうきょう":   "toukyou",
+		"すし":      "sushi",
+		"きって":     "kitte",
+		"まっちゃ":    "matcha",
+		"しゃしん":    "shashin",
+		"コンピューター": "konpyuutaa",
+		"ファイル":    "fairu",
+		"東京":      "",
+		"ひらがなabc": "",
+	}
+	for input, want := range tests {
+		if got := Romaji(input); got != want {
+			t.Errorf("Romaji(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestAnalyzerTransliterate(t *testing.T) {
+	a := &Analyzer{transliterate: true}
+	got := a.Transliterate([]string{"联邦政府", "コ", "ン", "ピュー", "タ", "ー", "を"}, LanguageChinese)
+	want := []string{"lianbangzhengfu", "lianbang", "bangzheng", "zhengfu", "ko", "n", "pyuu", "ta", "konpyuutaa", "o"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Transliterate() = %v, want %v", got, want)
+	}
+	// Kanji in Japanese texts don't have pinyin
+	if got := a.Transliterate([]string{"東京", "すし"}, LanguageJapanese); !slices.Equal(got, []string{"sushi"}) {
+		t.Errorf("Transliterate() = %v, want [sushi]", got)
+	}
+	if got := (&Analyzer{}).Transliterate([]string{"联邦"}, LanguageChinese); len(got) != 0 {
+		t.Errorf("Transliterate() = %v, should be empty if disabled", got)
+	}
+}
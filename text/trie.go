@@ -0,0 +1,231 @@
+package text
+
+import (
+	"container/heap"
+	"sort"
+	"strings"
+)
+
+// Completion is a key in a Trie completing a prefix
+type Completion struct {
+	Key     string
+	Display string
+	Weight  int64
+}
+
+// Trie is a prefix tree of weighted keys, it finds the heaviest completions of a prefix
+// without visiting lighter subtrees, so completing is fast even for large vocabularies.
+// It's not safe for concurrent use.
+type Trie struct {
+	root *trieNode
+	size int
+}
+
+type trieNode struct {
+	children map[rune]*trieNode
+	// weight is positive for the node at the end of a key
+	weight  int64
+	key     string
+	display string
+	// maxWeight is the largest weight in the subtree
+	maxWeight int64
+}
+
+func NewTrie() *Trie {
+	return &Trie{root: &trieNode{}}
+}
+
+// Len returns the number of keys
+func (t *Trie) Len() int {
+	return t.size
+}
+
+// Add adds delta to the weight of the key, the key is removed once its weight is not positive.
+// The display text is shown in completions instead of the key, the key is shown if it's "".
+func (t *Trie) Add(key, display string, delta int64) {
+	if key == "" || delta == 0 {
+		return
+	}
+	path := []*trieNode{t.root}
+	runes := []rune(key)
+	node := t.root
+	for _, r := range runes {
+		child, ok := node.children[r]
+		if !ok {
+			if delta < 0 {
+				// Removing a missing key
+				return
+			}
+			if node.children == nil {
+				node.children = make(map[rune]*trieNode)
+			}
+			child = &trieNode{}
+			node.children[r] = child
+		}
+		node = child
+		path = append(path, node)
+	}
+	existed := node.weight > 0
+	node.weight += delta
+	if node.weight > 0 {
+		node.key = key
+		if display != "" {
+			node.display = display
+		} else if node.display == "" {
+			node.display = key
+		}
+		if !existed {
+			t.size++
+		}
+	} else {
+		node.weight = 0
+		node.key = ""
+		node.display = ""
+		if existed {
+			t.size--
+		}
+	}
+	// Update the max weights from the bottom and prune empty nodes
+	for i := len(path) - 1; i >= 0; i-- {
+		node := path[i]
+		node.maxWeight = node.weight
+		for _, child := range node.children {
+			node.maxWeight = max(node.maxWeight, child.maxWeight)
+		}
+		if i > 0 && node.maxWeight == 0 {
+			delete(path[i-1].children, runes[i-1])
+		}
+	}
+}
+
+// Weight returns the weight of the key, 0 if it's missing
+func (t *Trie) Weight(key string) int64 {
+	node := t.find(key)
+	if node == nil {
+		return 0
+	}
+	return node.weight
+}
+
+func (t *Trie) find(prefix string) *trieNode {
+	node := t.root
+	for _, r := range prefix {
+		if node = node.children[r]; node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// Complete returns at most n keys starting with the prefix, the heaviest ones first
+func (t *Trie) Complete(prefix string, n int) []Completion {
+	completions := make([]Completion, 0, n)
+	start := t.find(prefix)
+	if start == nil || n <= 0 {
+		return completions
+	}
+	// Best-first search, a node is expanded only if its subtree may be heavier than the found keys
+	queue := &trieQueue{{node: start, priority: start.maxWeight}}
+	for queue.Len() > 0 && len(completions) < n {
+		item := heap.Pop(queue).(trieQueueItem)
+		if item.terminal {
+			completions = append(completions, Completion{Key: item.node.key, Display: item.node.display, Weight: item.node.weight})
+			continue
+		}
+		if item.node.weight > 0 {
+			heap.Push(queue, trieQueueItem{node: item.node, priority: item.node.weight, terminal: true})
+		}
+		for _, child := range item.node.children {
+			heap.Push(queue, trieQueueItem{node: child, priority: child.maxWeight})
+		}
+	}
+	return completions
+}
+
+// Similarity is a key in a Trie within an edit distance of another key
+type Similarity struct {
+	Key      string
+	Distance int
+	Weight   int64
+}
+
+// Similar returns the keys within the Levenshtein distance of the key, the closest and then the heaviest ones first.
+// A row of the distances is kept along each path, and a subtree is skipped once every prefix is too far,
+// so only the neighbourhood of the key is visited rather than the whole vocabulary.
+func (t *Trie) Similar(key string, maxDistance int) []Similarity {
+	target := []rune(key)
+	similar := make([]Similarity, 0)
+	first := make([]int, len(target)+1)
+	for i := range first {
+		first[i] = i
+	}
+	var visit func(node *trieNode, r rune, previous []int)
+	visit = func(node *trieNode, r rune, previous []int) {
+		row := make([]int, len(target)+1)
+		row[0] = previous[0] + 1
+		closest := row[0]
+		for i := 1; i <= len(target); i++ {
+			cost := 1
+			if target[i-1] == r {
+				cost = 0
+			}
+			row[i] = min(previous[i]+1, row[i-1]+1, previous[i-1]+cost)
+			closest = min(closest, row[i])
+		}
+		if closest > maxDistance {
+			return
+		}
+		if distance := row[len(target)]; node.weight > 0 && distance <= maxDistance {
+			similar = append(similar, Similarity{Key: node.key, Distance: distance, Weight: node.weight})
+		}
+		for r, child := range node.children {
+			visit(child, r, row)
+		}
+	}
+	for r, child := range t.root.children {
+		visit(child, r, first)
+	}
+	sort.Slice(similar, func(i, j int) bool {
+		if similar[i].Distance != similar[j].Distance {
+			return similar[i].Distance < similar[j].Distance
+		}
+		if similar[i].Weight != similar[j].Weight {
+			return similar[i].Weight > similar[j].Weight
+		}
+		return similar[i].Key < similar[j].Key
+	})
+	return similar
+}
+
+type trieQueueItem struct {
+	node     *trieNode
+	priority int64
+	// terminal items are the keys themselves rather than their subtrees
+	terminal bool
+}
+
+type trieQueue []trieQueueItem
+
+func (q trieQueue) Len() int { return len(q) }
+
+func (q trieQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	// Keys before subtrees of the same weight, then in the lexical order for stable results
+	if q[i].terminal != q[j].terminal {
+		return q[i].terminal
+	}
+	return strings.Compare(q[i].node.key, q[j].node.key) < 0
+}
+
+func (q trieQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *trieQueue) Push(x any) { *q = append(*q, x.(trieQueueItem)) }
+
+func (q *trieQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,88 @@
+package text
+
+import (
+	"slices"
+	"testing"
+)
+
+func completionKeys(completions []Completion) []string {
+	keys := make([]string, 0, len(completions))
+	for _, completion := range completions {
+		keys = append(keys, completion.Key)
+	}
+	return keys
+}
+
+func TestTrie(t *testing.T) {
+	trie := NewTrie()
+	trie.Add("federal", "", 3)
+	trie.Add("federation", "Federation", 5)
+	trie.Add("fed", "", 1)
+	trie.Add("feature", "", 4)
+	trie.Add("联邦", "", 2)
+	trie.Add("联邦政府", "", 7)
+
+	if got := completionKeys(trie.Complete("fe", 10)); !slices.Equal(got, []string{"federation", "feature", "federal", "fed"}) {
+		t.Errorf("Complete(fe) = %v", got)
+	}
+	if got := completionKeys(trie.Complete("fed", 2)); !slices.Equal(got, []string{"federation", "federal"}) {
+		t.Errorf("Complete(fed, 2) = %v", got)
+	}
+	if got := trie.Complete("federat", 1); len(got) != 1 || got[0].Display != "Federation" || got[0].Weight != 5 {
+		t.Errorf("Complete(federat) = %v", got)
+	}
+	if got := completionKeys(trie.Complete("联", 10)); !slices.Equal(got, []string{"联邦政府", "联邦"}) {
+		t.Errorf("Complete(联) = %v", got)
+	}
+	if got := trie.Complete("x", 10); len(got) != 0 {
+		t.Errorf("Complete(x) = %v", got)
+	}
+
+	// Removing keys
+	trie.Add("federation", "", -5)
+	trie.Add("missing", "", -1)
+	if got := completionKeys(trie.Complete("fed", 10)); !slices.Equal(got, []string{"federal", "fed"}) {
+		t.Errorf("Complete(fed) after removal = %v", got)
+	}
+	trie.Add("联邦政府", "", -3)
+	if got := trie.Weight("联邦政府"); got != 4 {
+		t.Errorf("Weight(联邦政府) = %d, want 4", got)
+	}
+	if trie.Len() != 5 {
+		t.Errorf("Len() = %d, want 5", trie.Len())
+	}
+}
+
+func TestTrieSimilar(t *testing.T) {
+	keys := map[string]int64{"federal": 3, "federation": 5, "general": 2, "feral": 4, "funeral": 1, "联邦政府": 7, "联合政府": 2}
+	trie := NewTrie()
+	for key, weight := range keys {
+		trie.Add(key, "", weight)
+	}
+	got := trie.Similar("fedral", 2)
+	want := []Similarity{{"feral", 1, 4}, {"federal", 1, 3}}
+	if !slices.Equal(got, want) {
+		t.Errorf("Similar(fedral, 2) = %v, want %v", got, want)
+	}
+	if got := trie.Similar("联邦政付", 1); !slices.Equal(got, []Similarity{{"联邦政府", 1, 7}}) {
+		t.Errorf("Similar(联邦政付, 1) = %v", got)
+	}
+	if got := trie.Similar("federal", 0); !slices.Equal(got, []Similarity{{"federal", 0, 3}}) {
+		t.Errorf("Similar(federal, 0) = %v", got)
+	}
+	// Same as the edit distances of all keys
+	for _, target := range []string{"federa", "genral", "fenural", "联邦", "x"} {
+		for _, maxDistance := range []int{1, 2, 3} {
+			found := make(map[string]int)
+			for _, similarity := range trie.Similar(target, maxDistance) {
+				found[similarity.Key] = similarity.Distance
+			}
+			for key := range keys {
+				distance, ok := found[key]
+				if expected := EditDistance(target, key, maxDistance); expected <= maxDistance != ok || ok && distance != expected {
+					t.Errorf("Similar(%s, %d) found %s at %d, want %d", target, maxDistance, key, distance, expected)
+				}
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/labstack/echo/v5"
@@ -36,3 +37,23 @@ func EchoJsonResponse(echoCtx *echo.Context, data any, status int) error {
 	}
 	return (*echoCtx).JSONBlob(status, jsonString)
 }
+
+// EchoSSE starts a response of Server-Sent Events, the returned function sends an event with the data as JSON
+func EchoSSE(echoCtx *echo.Context) func(event string, data any) error {
+	response := (*echoCtx).Response()
+	response.Header().Set(echo.HeaderContentType, "text/event-stream")
+	response.Header().Set(echo.HeaderCacheControl, "no-cache")
+	response.Header().Set(echo.HeaderConnection, "keep-alive")
+	response.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(response)
+	return func(event string, data any) error {
+		jsonString, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(response, "event: %s\ndata: %s\n\n", event, jsonString); err != nil {
+			return err
+		}
+		return controller.Flush()
+	}
+}